@@ -0,0 +1,115 @@
+package badgerManager
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ErrorCode is the stable kind of a failure, clients may branch on it while messages change
+type ErrorCode string
+
+const (
+	CodeNotFound    ErrorCode = "not_found"
+	CodeConflict    ErrorCode = "conflict"
+	CodeValidation  ErrorCode = "validation"
+	CodeTooLarge    ErrorCode = "too_large"
+	CodeUnavailable ErrorCode = "unavailable"
+)
+
+// Error is a failure with a kind, errors.Is matches it against the sentinel of its code
+type Error struct {
+	Code    ErrorCode
+	Message string
+	// Details is extra data for the client, for instance the failed items of a batch
+	Details any
+	// Cause is the underlying error, if any
+	Cause error
+}
+
+var (
+	ErrNotFound    = &Error{Code: CodeNotFound, Message: "not found"}
+	ErrConflict    = &Error{Code: CodeConflict, Message: "conflict"}
+	ErrValidation  = &Error{Code: CodeValidation, Message: "validation failed"}
+	ErrTooLarge    = &Error{Code: CodeTooLarge, Message: "too large"}
+	ErrUnavailable = &Error{Code: CodeUnavailable, Message: "database unavailable"}
+)
+
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// NotFound, Conflict and Invalid are the errors the managers refuse requests with
+func NotFound(message string) *Error {
+	return NewError(CodeNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return NewError(CodeConflict, message)
+}
+
+func Invalid(message string) *Error {
+	return NewError(CodeValidation, message)
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches any error of the same code when target is one of the sentinels
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	switch t {
+	case ErrNotFound, ErrConflict, ErrValidation, ErrTooLarge, ErrUnavailable:
+		return e.Code == t.Code
+	}
+	return e == t
+}
+
+// WithDetails returns a copy of e carrying details
+func (e *Error) WithDetails(details any) *Error {
+	res := *e
+	res.Details = details
+	return &res
+}
+
+// CodeOf is the code of the first Error in the chain of err, empty when err has no kind
+func CodeOf(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// wrapError gives a kind to the errors of badger itself, errors returned by callers' transactions pass through
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	code := ErrorCode("")
+	switch {
+	case errors.Is(err, badger.ErrKeyNotFound):
+		code = CodeNotFound
+	case errors.Is(err, badger.ErrConflict):
+		code = CodeConflict
+	case errors.Is(err, badger.ErrTxnTooBig):
+		code = CodeTooLarge
+	case errors.Is(err, badger.ErrDBClosed), errors.Is(err, badger.ErrBlockedWrites):
+		code = CodeUnavailable
+	}
+	if code == "" {
+		return err
+	}
+	return &Error{Code: code, Message: err.Error(), Cause: err}
+}
@@ -16,22 +16,26 @@ type DataSet struct {
 type IterationFunc func(key []byte, value []byte)
 
 type Manager struct {
-	logger     logger.ILogger
-	dbPath     string
-	internalDB *badger.DB
-	stopSignal chan int
-	stopOnce   sync.Once
-	initOnce   sync.Once
+	logger        logger.ILogger
+	dbPath        string
+	internalDB    *badger.DB
+	sequencesLock sync.Mutex
+	sequences     map[string]*badger.Sequence
+	stopSignal    chan int
+	stopOnce      sync.Once
+	initOnce      sync.Once
 }
 
 func NewBadgerManager(l logger.ILogger, dbPath string) *Manager {
 	return &Manager{
-		logger:     l,
-		dbPath:     dbPath,
-		internalDB: nil,
-		stopSignal: make(chan int),
-		stopOnce:   sync.Once{},
-		initOnce:   sync.Once{},
+		logger:        l,
+		dbPath:        dbPath,
+		internalDB:    nil,
+		sequencesLock: sync.Mutex{},
+		sequences:     make(map[string]*badger.Sequence),
+		stopSignal:    make(chan int),
+		stopOnce:      sync.Once{},
+		initOnce:      sync.Once{},
 	}
 }
 
@@ -48,13 +52,18 @@ func (p *Manager) Stop() {
 		default:
 
 		}
+		p.releaseSequences()
 		close(p.stopSignal)
 	})
 }
 
 func (p *Manager) checkDB() error {
 	if p.internalDB == nil || p.internalDB.IsClosed() {
-		return p.openDataBase()
+		err := p.openDataBase()
+		if err != nil {
+			return &Error{Code: CodeUnavailable, Message: "open database failed : " + err.Error(), Cause: err}
+		}
+		return nil
 	} else {
 		return nil
 	}
@@ -65,6 +74,10 @@ func (p *Manager) openDataBase() error {
 	p.initOnce.Do(func() {
 		p.internalDB, err = badger.Open(badger.DefaultOptions(p.dbPath))
 	})
+	//a concurrent caller waits in Do for the first open and can use its result
+	if err != nil && p.internalDB != nil && !p.internalDB.IsClosed() {
+		return nil
+	}
 	return err
 }
 
@@ -82,7 +95,7 @@ func (p *Manager) InsertData(dList []DataSet) error {
 		}
 		return nil
 	})
-	return err
+	return wrapError(err)
 }
 
 func (p *Manager) LoadData(keyList [][]byte) ([]DataSet, error) {
@@ -113,7 +126,7 @@ func (p *Manager) LoadData(keyList [][]byte) ([]DataSet, error) {
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
 	return res, nil
@@ -133,7 +146,7 @@ func (p *Manager) DeleteData(keyList [][]byte) error {
 		}
 		return nil
 	})
-	return err
+	return wrapError(err)
 }
 
 func (p *Manager) ViewData(raw func(txn *badger.Txn) error) error {
@@ -142,7 +155,7 @@ func (p *Manager) ViewData(raw func(txn *badger.Txn) error) error {
 		return err
 	}
 	err = p.internalDB.View(raw)
-	return err
+	return wrapError(err)
 }
 
 func (p *Manager) UpdateData(raw func(txn *badger.Txn) error) error {
@@ -151,7 +164,7 @@ func (p *Manager) UpdateData(raw func(txn *badger.Txn) error) error {
 		return err
 	}
 	err = p.internalDB.Update(raw)
-	return err
+	return wrapError(err)
 }
 
 /*
@@ -194,5 +207,40 @@ func (p *Manager) IterateData(loadFunc IterationFunc, prefix []byte) error {
 		}
 		return nil
 	})
-	return err
+	return wrapError(err)
+}
+
+// sequenceBandwidth numbers are leased at once, the unused part of a lease is skipped after a restart
+const sequenceBandwidth = 100
+
+// NextSequence returns the next number of the sequence stored under key, starting at 0
+func (p *Manager) NextSequence(key []byte) (uint64, error) {
+	err := p.checkDB()
+	if err != nil {
+		return 0, err
+	}
+	p.sequencesLock.Lock()
+	defer p.sequencesLock.Unlock()
+	seq, ok := p.sequences[string(key)]
+	if !ok {
+		seq, err = p.internalDB.GetSequence(key, sequenceBandwidth)
+		if err != nil {
+			return 0, wrapError(err)
+		}
+		p.sequences[string(key)] = seq
+	}
+	next, err := seq.Next()
+	return next, wrapError(err)
+}
+
+func (p *Manager) releaseSequences() {
+	p.sequencesLock.Lock()
+	defer p.sequencesLock.Unlock()
+	for key, seq := range p.sequences {
+		err := seq.Release()
+		if err != nil {
+			p.logger.Error("release sequence failed", zap.String("key", key), zap.Error(err))
+		}
+		delete(p.sequences, key)
+	}
 }
@@ -1,6 +1,9 @@
 package badgerManager
 
 import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
 	"go.uber.org/zap/zapcore"
 	"moonlighting/common/logger/console"
 	"sync"
@@ -96,3 +99,25 @@ func TestBadgerManager(t *testing.T) {
 	<-time.After(10 * time.Second)
 
 }
+
+func TestErrorCodes(t *testing.T) {
+	err := wrapError(fmt.Errorf("load : %w", badger.ErrKeyNotFound))
+	if CodeOf(err) != CodeNotFound || !errors.Is(err, ErrNotFound) || !errors.Is(err, badger.ErrKeyNotFound) {
+		t.Fatal("badger errors should get a code and keep their cause", err)
+	}
+	if errors.Is(err, ErrConflict) {
+		t.Fatal("codes should not match each other", err)
+	}
+	if CodeOf(wrapError(badger.ErrTxnTooBig)) != CodeTooLarge || CodeOf(wrapError(badger.ErrDBClosed)) != CodeUnavailable {
+		t.Fatal("unexpected codes")
+	}
+
+	own := NewError(CodeConflict, "record is referenced").WithDetails([]string{"a"})
+	if wrapError(own) != error(own) || !errors.Is(fmt.Errorf("delete : %w", own), ErrConflict) {
+		t.Fatal("typed errors should pass through", own)
+	}
+	plain := errors.New("plain")
+	if wrapError(plain) != plain || CodeOf(plain) != "" {
+		t.Fatal("other errors should pass through untouched")
+	}
+}
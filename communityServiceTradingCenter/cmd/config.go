@@ -3,7 +3,14 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/httpApiServer"
+	"moonlighting/communityServiceTradingCenter/limitManager"
+	"moonlighting/communityServiceTradingCenter/matchManager"
+	"moonlighting/communityServiceTradingCenter/recommendManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
 	"os"
+	"sort"
 )
 
 type rootConfig struct {
@@ -11,6 +18,19 @@ type rootConfig struct {
 	DbPath         string `json:"dbPath"`
 	StaticServeDir string `json:"staticServeDir"`
 	ServeAddress   string `json:"serveAddress"`
+	// WordListPath holds the prohibited terms, one per line, changes are picked up while running
+	WordListPath string `json:"wordListPath"`
+	// Http configures the api server, AllowOrigins limits cross origin browser calls and
+	// TrustedProxies lists the reverse proxies allowed to set the client ip
+	Http  httpApiServer.Options `json:"http"`
+	Users userManager.Options   `json:"users"`
+	// Limits holds the per route request rates and the daily write quota of each principal
+	Limits limitManager.Options `json:"limits"`
+	// Datasets holds per dataset options keyed by dataset name, the datasets created through the api are kept in the db
+	Datasets map[string]dataManager.Options `json:"datasets"`
+	Matching matchManager.Options           `json:"matching"`
+	// Recommendation configures the job filling the recommender dataset
+	Recommendation recommendManager.Options `json:"recommendation"`
 }
 
 var defaultConfig = rootConfig{
@@ -18,6 +38,55 @@ var defaultConfig = rootConfig{
 	DbPath:         "./db",
 	StaticServeDir: "./static",
 	ServeAddress:   ":12345",
+	WordListPath:   "./wordList.txt",
+	Users:          userManager.DefaultOptions(),
+	Limits:         limitManager.DefaultOptions(),
+	Datasets: map[string]dataManager.Options{
+		"provider": {},
+		// the lifecycle and moderation are opt-in, without them inserted records are listed by /query right away as
+		// they always were. dataManager.DefaultLifecycle("declareDeadlineMs") is the lifecycle the publisher dataset
+		// is meant for, moderation needs reviewers and then the content filter may hold records for them.
+		"publisher": {
+			Keys:          dataManager.KeyOptions{Strategy: dataManager.KeyStrategySequence, Format: "PUB-{year}-{seq:6}"},
+			ContentFilter: &dataManager.ContentFilterOptions{Action: dataManager.ContentMask},
+		},
+		"recommender": {
+			References: []dataManager.Reference{
+				{Field: "providerKey", Dataset: "provider", OnDelete: dataManager.OnDeleteCascade},
+				{Field: "publisherKey", Dataset: "publisher", OnDelete: dataManager.OnDeleteNullify},
+			},
+		},
+	},
+	Matching:       matchManager.DefaultOptions(),
+	Recommendation: recommendManager.DefaultOptions(),
+}
+
+// builtinDatasets always exist, the matching and recommendation jobs work on them
+var builtinDatasets = []string{"provider", "publisher", "recommender"}
+
+// datasets lists the config datasets for the registry, the key prefix is the name followed by a dot
+func (rc rootConfig) datasets() []dataManager.Dataset {
+	names := append([]string{}, builtinDatasets...)
+	for name := range rc.Datasets {
+		found := false
+		for _, b := range builtinDatasets {
+			if b == name {
+				found = true
+			}
+		}
+		if !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[len(builtinDatasets):])
+	res := make([]dataManager.Dataset, 0, len(names))
+	for _, name := range names {
+		res = append(res, dataManager.Dataset{
+			Name:    name,
+			Options: rc.Datasets[name],
+		})
+	}
+	return res
 }
 
 func readConfig() rootConfig {
@@ -32,7 +101,9 @@ func readConfig() rootConfig {
 		fmt.Println("read config failed : " + err.Error() + "   using default config and save it to local disk")
 		return defaultConfig
 	}
-	var res rootConfig
+	// fields missing from the users and limits sections keep their defaults, a config may set admins or
+	// the daily quota alone
+	res := rootConfig{Users: userManager.DefaultOptions(), Limits: limitManager.DefaultOptions()}
 	err = json.Unmarshal(data, &res)
 	if err != nil {
 		fmt.Println("parse config failed : " + err.Error() + "   using default config and save it to local disk")
@@ -4,11 +4,17 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"moonlighting/common/database/badgerManager"
 	"moonlighting/common/logger/base"
 	"moonlighting/communityServiceTradingCenter/dataManager"
 	"moonlighting/communityServiceTradingCenter/httpApiServer"
+	"moonlighting/communityServiceTradingCenter/limitManager"
+	"moonlighting/communityServiceTradingCenter/matchManager"
+	"moonlighting/communityServiceTradingCenter/recommendManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"moonlighting/communityServiceTradingCenter/webhookManager"
 	"os"
 	"os/signal"
 	"path"
@@ -62,19 +68,70 @@ func startServer() {
 	l.Info("wait 3 second for internal db to prepare")
 	<-time.After(3 * time.Second)
 
-	providerDataManager := dataManager.NewDataManager(l, "provider.", m)
-	go providerDataManager.Start()
-	defer providerDataManager.Stop()
+	wm := webhookManager.NewWebhookManager(l, m)
+	go wm.Start()
+	defer wm.Stop()
 
-	publisherDataManager := dataManager.NewDataManager(l, "publisher.", m)
-	go publisherDataManager.Start()
-	defer publisherDataManager.Stop()
+	wf := dataManager.NewWordFilter(l, rc.WordListPath)
+	go wf.Start()
+	defer wf.Stop()
 
-	recommenderDataManager := dataManager.NewDataManager(l, "recommender.", m)
-	go recommenderDataManager.Start()
-	defer recommenderDataManager.Stop()
+	registry := dataManager.NewRegistry(l, m)
+	registry.OnOpen(wm.Watch)
+	registry.SetWordFilter(wf)
+	err := registry.Load()
+	if err != nil {
+		l.Error("load datasets failed", zap.Error(err))
+		return
+	}
+	defer registry.Close()
+	err = registry.Ensure(rc.datasets())
+	if err != nil {
+		l.Error("apply dataset config failed", zap.Error(err))
+		return
+	}
+	providerDataManager, _ := registry.Get("provider")
+	publisherDataManager, _ := registry.Get("publisher")
+	recommenderDataManager, _ := registry.Get("recommender")
+
+	mm := matchManager.NewMatchManager(l, providerDataManager, publisherDataManager)
+	if len(rc.Matching.Rules) > 0 {
+		mm.SetOptions(rc.Matching)
+	}
+
+	rm := recommendManager.NewRecommendManager(l, m, registry.Directory(), recommenderDataManager)
+	if len(rc.Recommendation.Sources) > 0 {
+		rm.SetOptions(rc.Recommendation)
+	}
+	go rm.Start()
+	defer rm.Stop()
 
-	has := httpApiServer.NewHttpApiServer(rc.ServeAddress, rc.StaticServeDir, providerDataManager, publisherDataManager, recommenderDataManager)
+	um := userManager.NewUserManager(l, m)
+	um.SetOptions(rc.Users)
+	err = um.Load()
+	if err != nil {
+		l.Error("load roles failed", zap.Error(err))
+		return
+	}
+	go um.Start()
+	defer um.Stop()
+
+	lm := limitManager.NewLimitManager(l, m)
+	err = lm.SetOptions(rc.Limits)
+	if err != nil {
+		l.Error("apply limits config failed", zap.Error(err))
+		return
+	}
+	go lm.Start()
+	defer lm.Stop()
+
+	has := httpApiServer.NewHttpApiServer(rc.ServeAddress, rc.StaticServeDir, registry, wm, mm, rm, um, lm)
+	err = has.SetOptions(rc.Http)
+	if err != nil {
+		l.Error("apply http config failed", zap.Error(err))
+		has.Stop()
+		return
+	}
 	go has.Start()
 	defer has.Stop()
 
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger/console"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"os"
+)
+
+var transferFlags struct {
+	dataset        string
+	file           string
+	format         string
+	keyColumn      string
+	priorityColumn string
+	mapping        []string
+	columns        []string
+	dryRun         bool
+	mode           string
+	bestEffort     bool
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import csv or ndjson rows into a dataset",
+	Long:  `import works on the local db directly, stop the server before running it`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runOffline(func(dm *dataManager.Manager) error {
+			return importDataset(dm)
+		})
+	},
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export a dataset as csv or ndjson",
+	Long:  `export works on the local db directly, stop the server before running it`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runOffline(func(dm *dataManager.Manager) error {
+			return exportDataset(dm)
+		})
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{importCmd, exportCmd} {
+		c.Flags().StringVarP(&transferFlags.dataset, "dataset", "d", "", "dataset name")
+		c.Flags().StringVarP(&transferFlags.file, "file", "f", "", "file path, stdin/stdout when empty")
+		c.Flags().StringVar(&transferFlags.format, "format", "csv", "csv or ndjson")
+		c.Flags().StringVar(&transferFlags.keyColumn, "key-column", "key", "column holding the record key")
+		c.Flags().StringVar(&transferFlags.priorityColumn, "priority-column", "priority", "column holding the record priority")
+		_ = c.MarkFlagRequired("dataset")
+		rootCmd.AddCommand(c)
+	}
+	importCmd.Flags().StringArrayVarP(&transferFlags.mapping, "map", "m", nil, "column=field mapping, repeatable, all columns are imported when omitted")
+	importCmd.Flags().BoolVar(&transferFlags.dryRun, "dry-run", false, "validate only and print the report")
+	importCmd.Flags().StringVar(&transferFlags.mode, "mode", "upsert", "write mode : create, update or upsert")
+	importCmd.Flags().BoolVar(&transferFlags.bestEffort, "best-effort", false, "write valid rows even if others in the batch fail")
+	exportCmd.Flags().StringSliceVar(&transferFlags.columns, "columns", nil, "value fields to export, all when omitted")
+}
+
+func runOffline(f func(dm *dataManager.Manager) error) error {
+	rc := readConfig()
+	l := console.NewConsoleLogger(zapcore.WarnLevel)
+
+	m := badgerManager.NewBadgerManager(l, rc.DbPath)
+	go m.Start()
+	defer m.Stop()
+
+	// every dataset is opened so references into the others can be checked
+	registry := dataManager.NewRegistry(l, m)
+	err := registry.Load()
+	if err != nil {
+		return err
+	}
+	defer registry.Close()
+	err = registry.Ensure(rc.datasets())
+	if err != nil {
+		return err
+	}
+
+	dm, ok := registry.Get(transferFlags.dataset)
+	if !ok {
+		return errors.New("unknown dataset : " + transferFlags.dataset)
+	}
+	return f(dm)
+}
+
+func importDataset(dm *dataManager.Manager) error {
+	format, err := dataManager.ParseTransferFormat(transferFlags.format)
+	if err != nil {
+		return err
+	}
+	mapping, err := dataManager.ParseFieldMapping(transferFlags.mapping)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = os.Stdin
+	if transferFlags.file != "" {
+		file, err := os.Open(transferFlags.file)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	report, err := dm.Import(r, dataManager.ImportOption{
+		Format:         format,
+		KeyColumn:      transferFlags.keyColumn,
+		PriorityColumn: transferFlags.priorityColumn,
+		FieldMapping:   mapping,
+		DryRun:         transferFlags.dryRun,
+		Insert: dataManager.InsertOption{
+			Mode:       dataManager.WriteMode(transferFlags.mode),
+			BestEffort: transferFlags.bestEffort,
+		},
+	})
+	if report != nil {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Fprintln(os.Stderr, string(data))
+	}
+	return err
+}
+
+func exportDataset(dm *dataManager.Manager) error {
+	format, err := dataManager.ParseTransferFormat(transferFlags.format)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if transferFlags.file != "" {
+		file, err := os.Create(transferFlags.file)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return dm.Export(w, dataManager.ExportOption{
+		Format:         format,
+		KeyColumn:      transferFlags.keyColumn,
+		PriorityColumn: transferFlags.priorityColumn,
+		Columns:        transferFlags.columns,
+	})
+}
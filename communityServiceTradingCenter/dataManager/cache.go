@@ -0,0 +1,253 @@
+package dataManager
+
+import (
+	"container/list"
+	"encoding/json"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultCacheSize  = 256
+	defaultCacheTTLMs = 10 * 1000
+)
+
+type CacheOptions struct {
+	Disabled bool `json:"disabled"`
+	// Size is the number of query results kept, 256 when 0
+	Size int `json:"size"`
+	// TTLMs bounds how long scores depending on time (boosts, pins, decay) may be served from the cache, 10s when 0
+	TTLMs uint64 `json:"ttlMs"`
+}
+
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Bypassed  uint64 `json:"bypassed"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Capacity  int    `json:"capacity"`
+}
+
+type cacheEntry struct {
+	key string
+	// generations of this dataset and of the expanded ones when the result was computed
+	generations []uint64
+	expireMs    uint64
+	res         []Record
+	totalCount  int
+}
+
+type queryCache struct {
+	lock      sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List
+	hits      uint64
+	misses    uint64
+	bypassed  uint64
+	evictions uint64
+}
+
+func newQueryCache() *queryCache {
+	return &queryCache{
+		lock:    sync.Mutex{},
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (o CacheOptions) size() int {
+	if o.Size <= 0 {
+		return defaultCacheSize
+	}
+	return o.Size
+}
+
+func (o CacheOptions) ttlMs() uint64 {
+	if o.TTLMs == 0 {
+		return defaultCacheTTLMs
+	}
+	return o.TTLMs
+}
+
+// bumpGeneration invalidates every cached query of the dataset
+func (p *Manager) bumpGeneration() {
+	atomic.AddUint64(&p.generation, 1)
+}
+
+func (p *Manager) Generation() uint64 {
+	return atomic.LoadUint64(&p.generation)
+}
+
+// cacheGenerations are the generations a query result depends on
+func (p *Manager) cacheGenerations(query Query) []uint64 {
+	res := []uint64{p.Generation()}
+	for _, field := range query.Expand {
+		ref, ok := p.reference(field)
+		if !ok {
+			continue
+		}
+		if target, err := p.referenceTarget(ref); err == nil {
+			res = append(res, target.Generation())
+		}
+	}
+	return res
+}
+
+func sortedCopy(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	res := append([]string{}, list...)
+	sort.Strings(res)
+	return res
+}
+
+// cacheKey normalizes query so requests differing only in field order share an entry
+func cacheKey(query Query) string {
+	query.NoCache = false
+	query.Fields = sortedCopy(query.Fields)
+	query.ExcludeFields = sortedCopy(query.ExcludeFields)
+	query.PreviewFields = sortedCopy(query.PreviewFields)
+	query.States = sortedCopy(query.States)
+	query.Expand = sortedCopy(query.Expand)
+	if len(query.MatchRules) == 0 {
+		query.MatchRules = nil
+	}
+	if query.Limit <= 0 || query.Page <= 0 {
+		query.Limit = 0
+		query.Page = 0
+	}
+	// map keys are sorted by json
+	key, _ := json.Marshal(query)
+	return string(key)
+}
+
+// copyRecords keeps the cached results apart from the ones handed to callers, both sides may change theirs
+func copyRecords(list []Record) []Record {
+	res := make([]Record, 0, len(list))
+	for _, record := range list {
+		record.Data = copyData(record.Data)
+		if record.DistanceKm != nil {
+			distance := *record.DistanceKm
+			record.DistanceKm = &distance
+		}
+		if record.Expanded != nil {
+			expanded := make(map[string]*Data, len(record.Expanded))
+			for field, data := range record.Expanded {
+				if data != nil {
+					copied := copyData(*data)
+					data = &copied
+				}
+				expanded[field] = data
+			}
+			record.Expanded = expanded
+		}
+		res = append(res, record)
+	}
+	return res
+}
+
+func sameGenerations(a []uint64, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *queryCache) get(key string, generations []uint64, now uint64) (*cacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses += 1
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !sameGenerations(entry.generations, generations) || entry.expireMs <= now {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		c.misses += 1
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	c.hits += 1
+	return entry, true
+}
+
+func (c *queryCache) put(entry *cacheEntry, size int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[entry.key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions += 1
+	}
+}
+
+func (c *queryCache) bypass() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.bypassed += 1
+}
+
+func (p *Manager) CacheStats() CacheStats {
+	c := p.cache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Bypassed:  c.bypassed,
+		Evictions: c.evictions,
+		Entries:   c.order.Len(),
+		Capacity:  p.GetOptions().Cache.size(),
+	}
+}
+
+// QueryData answers from the cache when the dataset did not change since the same query was computed
+func (p *Manager) QueryData(query Query) (res []Record, count int, totalCount int, err error) {
+	options := p.GetOptions().Cache
+	if options.Disabled {
+		return p.queryData(query)
+	}
+	if query.NoCache {
+		p.cache.bypass()
+		return p.queryData(query)
+	}
+
+	key := cacheKey(query)
+	// generations are taken before computing so a concurrent write makes the entry stale instead of wrong
+	generations := p.cacheGenerations(query)
+	now := nowMs()
+	if entry, ok := p.cache.get(key, generations, now); ok {
+		res = copyRecords(entry.res)
+		return res, len(res), entry.totalCount, nil
+	}
+
+	res, count, totalCount, err = p.queryData(query)
+	if err != nil {
+		return res, count, totalCount, err
+	}
+	p.cache.put(&cacheEntry{
+		key:         key,
+		generations: generations,
+		expireMs:    now + options.ttlMs(),
+		res:         copyRecords(res),
+		totalCount:  totalCount,
+	}, options.size())
+	return res, count, totalCount, nil
+}
@@ -0,0 +1,152 @@
+package dataManager
+
+import (
+	"go.uber.org/zap/zapcore"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger/console"
+	"testing"
+)
+
+func TestQueryCache(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	// the managers are not started so the sort key list only changes when the test asks for it
+	directory := NewDirectory()
+	provider := NewDataManager(l, "provider.", m)
+	directory.Add("provider", provider)
+	recommender := NewDataManager(l, "recommender.", m)
+	recommender.SetOptions(Options{
+		References: []Reference{{Field: "providerKey", Dataset: "provider"}},
+		Cache:      CacheOptions{Size: 2},
+	})
+	directory.Add("recommender", recommender)
+
+	_, err := provider.InsertData([]Data{{Key: "pv1", Value: map[string]string{"name": "a"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recommender.InsertData([]Data{
+		{Key: "r1", Value: map[string]string{"providerKey": "pv1", "kind": "x"}, Priority: 2},
+		{Key: "r2", Value: map[string]string{"kind": "y"}, Priority: 1},
+	}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.updateSortKeyList()
+	recommender.updateSortKeyList()
+
+	query := func(q Query) []Record {
+		res, _, _, err := recommender.QueryData(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	expectStats := func(hits uint64, misses uint64) {
+		stats := recommender.CacheStats()
+		if stats.Hits != hits || stats.Misses != misses {
+			t.Fatal("unexpected stats", stats)
+		}
+	}
+
+	query(Query{Fields: []string{"kind", "providerKey"}})
+	res := query(Query{Fields: []string{"providerKey", "kind"}})
+	if len(res) != 2 {
+		t.Fatal("unexpected result", res)
+	}
+	expectStats(1, 1)
+
+	_, err = recommender.InsertData([]Data{{Key: "r3", Value: map[string]string{"kind": "z"}, Priority: 3}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recommender.updateSortKeyList()
+	res = query(Query{Fields: []string{"kind", "providerKey"}})
+	if len(res) != 3 || res[0].Key != "r3" {
+		t.Fatal("write did not invalidate the cache", res)
+	}
+	expectStats(1, 2)
+
+	query(Query{NoCache: true})
+	if stats := recommender.CacheStats(); stats.Bypassed != 1 || stats.Misses != 2 {
+		t.Fatal("bypass should skip the cache", stats)
+	}
+
+	// writes to the expanded dataset invalidate too
+	expand := Query{Expand: []string{"providerKey"}, MatchRules: []map[string]string{{"kind": "^x$"}}}
+	query(expand)
+	err = provider.PatchData([]Patch{{Key: "pv1", Set: map[string]string{"name": "b"}}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = query(expand)
+	if len(res) != 1 || res[0].Expanded["providerKey"].Value["name"] != "b" {
+		t.Fatal("expanded record is stale", res)
+	}
+	expectStats(1, 4)
+
+	// size 2 evicts the least recently used query
+	query(Query{Limit: 1, Page: 1})
+	if stats := recommender.CacheStats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Fatal("unexpected eviction", stats)
+	}
+	query(expand)
+	expectStats(2, 5)
+
+	options := recommender.GetOptions()
+	options.Cache.Disabled = true
+	recommender.SetOptions(options)
+	query(expand)
+	expectStats(2, 5)
+}
+
+func TestQueryCacheCopies(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	directory := NewDirectory()
+	provider := NewDataManager(l, "provider.", m)
+	directory.Add("provider", provider)
+	recommender := NewDataManager(l, "recommender.", m)
+	recommender.SetOptions(Options{References: []Reference{{Field: "providerKey", Dataset: "provider"}}})
+	directory.Add("recommender", recommender)
+
+	_, err := provider.InsertData([]Data{{Key: "pv1", Value: map[string]string{"name": "a"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recommender.InsertData([]Data{{Key: "r1", Value: map[string]string{"providerKey": "pv1", "kind": "x"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = recommender.SetEditors("r1", []string{"bob"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.updateSortKeyList()
+	recommender.updateSortKeyList()
+
+	query := Query{Expand: []string{"providerKey"}}
+	for i := 0; i < 3; i++ {
+		res, _, _, err := recommender.QueryData(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 1 || res[0].Value["kind"] != "x" || res[0].Editors[0] != "bob" || res[0].Expanded["providerKey"].Value["name"] != "a" {
+			t.Fatal("cached result was changed by a caller", i, res)
+		}
+		// the first result is the one put in the cache, the next ones come from it
+		res[0].Value["kind"] = "changed"
+		res[0].Editors[0] = "mallory"
+		res[0].Expanded["providerKey"].Value["name"] = "changed"
+		res[0].Expanded["providerKey"] = nil
+	}
+	if stats := recommender.CacheStats(); stats.Hits != 2 {
+		t.Fatal("results should come from the cache", stats)
+	}
+}
@@ -0,0 +1,99 @@
+package dataManager
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	ContentReject   = "reject"
+	ContentMask     = "mask"
+	ContentModerate = "moderate"
+)
+
+// ContentFilterOptions runs the word filter over record values on every insert and patch
+type ContentFilterOptions struct {
+	// Fields limits the checked fields, every field is checked when empty
+	Fields []string `json:"fields"`
+	// Action is reject, mask the terms with * or moderate, which needs Moderation on the dataset
+	Action string `json:"action"`
+}
+
+func (o *ContentFilterOptions) Check(options Options) error {
+	switch o.Action {
+	case ContentReject, ContentMask:
+	case ContentModerate:
+		if options.Moderation == nil {
+			return invalid("content filter action moderate needs moderation")
+		}
+	default:
+		return invalid("unknown content filter action : " + o.Action)
+	}
+	return nil
+}
+
+func (p *Manager) SetWordFilter(f *WordFilter) {
+	p.optionsLock.Lock()
+	defer p.optionsLock.Unlock()
+	p.wordFilter = f
+}
+
+func (p *Manager) getWordFilter() *WordFilter {
+	p.optionsLock.RLock()
+	defer p.optionsLock.RUnlock()
+	return p.wordFilter
+}
+
+// filterContent checks the values of data and returns the prohibited terms found.
+// The mask action replaces data.Value with a masked copy, reject returns an error naming the terms
+// and moderate sets held so the record goes to the moderation queue.
+func (p *Manager) filterContent(data *Data) (terms []string, held bool, err error) {
+	options := p.GetOptions().ContentFilter
+	filter := p.getWordFilter()
+	if options == nil || filter == nil {
+		return nil, false, nil
+	}
+	fields := options.Fields
+	if len(fields) == 0 {
+		fields = make([]string, 0, len(data.Value))
+		for k := range data.Value {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+	}
+
+	var masked map[string]string
+	seen := make(map[string]struct{})
+	for _, field := range fields {
+		v, ok := data.Value[field]
+		if !ok {
+			continue
+		}
+		res, found := filter.Check(v)
+		if len(found) == 0 {
+			continue
+		}
+		for _, term := range found {
+			if _, ok := seen[term]; !ok {
+				seen[term] = struct{}{}
+				terms = append(terms, term)
+			}
+		}
+		if masked == nil {
+			masked = copyValue(data.Value)
+		}
+		masked[field] = res
+	}
+	if len(terms) == 0 {
+		return nil, false, nil
+	}
+	switch options.Action {
+	case ContentReject:
+		return terms, false, invalid("prohibited terms : " + strings.Join(terms, ","))
+	case ContentMask:
+		data.Value = masked
+	case ContentModerate:
+		held = true
+	}
+	return terms, held, nil
+}
@@ -0,0 +1,66 @@
+package dataManager
+
+import (
+	"sort"
+	"sync"
+)
+
+// Directory resolves dataset names to the managers sharing one badger db, references between datasets go through it
+type Directory struct {
+	lock     sync.RWMutex
+	managers map[string]*Manager
+}
+
+func NewDirectory() *Directory {
+	return &Directory{
+		lock:     sync.RWMutex{},
+		managers: make(map[string]*Manager),
+	}
+}
+
+func (d *Directory) Add(name string, m *Manager) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	m.name = name
+	m.directory = d
+	d.managers[name] = m
+}
+
+func (d *Directory) Remove(name string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.managers, name)
+}
+
+func (d *Directory) Get(name string) (*Manager, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	m, ok := d.managers[name]
+	return m, ok
+}
+
+func (d *Directory) Names() []string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	res := make([]string, 0, len(d.managers))
+	for name := range d.managers {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (d *Directory) byPrefix(prefix string) (*Manager, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for _, m := range d.managers {
+		if m.prefix == prefix {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+func (p *Manager) Name() string {
+	return p.name
+}
@@ -0,0 +1,324 @@
+package dataManager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	DuplicateReject = "reject"
+	DuplicateFlag   = "flag"
+	DuplicateMerge  = "merge"
+
+	dupExactPrefix = "_dup.exact."
+	dupSimPrefix   = "_dup.sim."
+
+	simHashBands   = 4
+	simHashBandLen = 64 / simHashBands
+	// a distance below the number of bands leaves at least one band equal, so the band index finds every match
+	maxSimHashDistance     = simHashBands - 1
+	defaultSimHashDistance = 3
+	shingleSize            = 3
+)
+
+type DuplicateOptions struct {
+	// ExactFields make two records duplicates when all of them are equal, ignoring case and spacing
+	ExactFields []string `json:"exactFields"`
+	// TextFields are joined into the content compared by SimHash
+	TextFields []string `json:"textFields"`
+	// MaxDistance is the largest SimHash hamming distance of near-duplicates, at most 3 and 3 when missing,
+	// 0 matches equal fingerprints only
+	MaxDistance *int `json:"maxDistance"`
+	// Action is reject, flag or merge into the oldest duplicate
+	Action string `json:"action"`
+}
+
+type DuplicateCluster struct {
+	Keys []string `json:"keys"`
+}
+
+func (o *DuplicateOptions) Check() error {
+	switch o.Action {
+	case DuplicateReject, DuplicateFlag, DuplicateMerge:
+	default:
+		return invalid("unknown duplicate action : " + o.Action)
+	}
+	if o.MaxDistance != nil && (*o.MaxDistance < 0 || *o.MaxDistance > maxSimHashDistance) {
+		return invalid(fmt.Sprintf("max distance must be between 0 and %d", maxSimHashDistance))
+	}
+	if len(o.ExactFields) == 0 && len(o.TextFields) == 0 {
+		return invalid("no duplicate fields")
+	}
+	return nil
+}
+
+func (o *DuplicateOptions) maxDistance() int {
+	if o.MaxDistance == nil || *o.MaxDistance < 0 || *o.MaxDistance > maxSimHashDistance {
+		return defaultSimHashDistance
+	}
+	return *o.MaxDistance
+}
+
+func normalizeText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// exactHash is empty when one of the fields is missing, such records are never exact duplicates
+func (o *DuplicateOptions) exactHash(data Data) string {
+	if len(o.ExactFields) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, field := range o.ExactFields {
+		v := normalizeText(data.Value[field])
+		if v == "" {
+			return ""
+		}
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// simHash fingerprints the text fields with rune shingles, which works for text without spaces too.
+// ok is false when there is too little text to compare.
+func (o *DuplicateOptions) simHash(data Data) (uint64, bool) {
+	if len(o.TextFields) == 0 {
+		return 0, false
+	}
+	runes := make([]rune, 0)
+	for _, field := range o.TextFields {
+		for _, r := range strings.ToLower(data.Value[field]) {
+			if unicode.IsLetter(r) || unicode.IsNumber(r) {
+				runes = append(runes, r)
+			}
+		}
+	}
+	if len(runes) < shingleSize {
+		return 0, false
+	}
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(string(runes[i : i+shingleSize])))
+		sum := h.Sum64()
+		for b := 0; b < 64; b++ {
+			if sum&(1<<b) != 0 {
+				weights[b] += 1
+			} else {
+				weights[b] -= 1
+			}
+		}
+	}
+	var res uint64
+	for b := 0; b < 64; b++ {
+		if weights[b] > 0 {
+			res |= 1 << b
+		}
+	}
+	return res, true
+}
+
+func (p *Manager) dupExactKey(hash string, key string) []byte {
+	return []byte(dupExactPrefix + p.prefix + hash + refSeparator + key)
+}
+
+func (p *Manager) dupSimBandPrefix(fingerprint uint64, band int) string {
+	value := (fingerprint >> (band * simHashBandLen)) & (1<<simHashBandLen - 1)
+	return fmt.Sprintf("%s%s%d%04x%s", dupSimPrefix, p.prefix, band, value, refSeparator)
+}
+
+func (p *Manager) updateDuplicateIndex(txn *badger.Txn, data Data, remove bool) error {
+	options := p.GetOptions().Duplicates
+	if options == nil {
+		return nil
+	}
+	keys := make([][]byte, 0, simHashBands+1)
+	if hash := options.exactHash(data); hash != "" {
+		keys = append(keys, p.dupExactKey(hash, data.Key))
+	}
+	if fingerprint, ok := options.simHash(data); ok {
+		for band := 0; band < simHashBands; band++ {
+			keys = append(keys, []byte(p.dupSimBandPrefix(fingerprint, band)+data.Key))
+		}
+	}
+	for _, key := range keys {
+		var err error
+		if remove {
+			err = txn.Delete(key)
+		} else {
+			err = txn.Set(key, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanKeys(txn *badger.Txn, prefix string, f func(key string)) {
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = []byte(prefix)
+	iter := txn.NewIterator(opt)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		f(string(iter.Item().Key()[len(prefix):]))
+	}
+}
+
+// findDuplicates returns the keys of the stored records duplicating data, oldest first.
+// Candidates from the index are checked again so entries left by an options change do no harm.
+func (p *Manager) findDuplicates(txn *badger.Txn, options *DuplicateOptions, data Data) ([]Data, error) {
+	candidates := make(map[string]struct{})
+	hash := options.exactHash(data)
+	if hash != "" {
+		scanKeys(txn, dupExactPrefix+p.prefix+hash+refSeparator, func(key string) {
+			candidates[key] = struct{}{}
+		})
+	}
+	fingerprint, hasFingerprint := options.simHash(data)
+	if hasFingerprint {
+		for band := 0; band < simHashBands; band++ {
+			scanKeys(txn, p.dupSimBandPrefix(fingerprint, band), func(key string) {
+				candidates[key] = struct{}{}
+			})
+		}
+	}
+	delete(candidates, data.Key)
+
+	res := make([]Data, 0)
+	for key := range candidates {
+		other, exists, err := p.loadData(txn, key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		duplicate := hash != "" && options.exactHash(other) == hash
+		if !duplicate && hasFingerprint {
+			otherFingerprint, ok := options.simHash(other)
+			duplicate = ok && bits.OnesCount64(fingerprint^otherFingerprint) <= options.maxDistance()
+		}
+		if duplicate {
+			res = append(res, other)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].CreateTimeMs != res[j].CreateTimeMs {
+			return res[i].CreateTimeMs < res[j].CreateTimeMs
+		}
+		return res[i].Key < res[j].Key
+	})
+	return res, nil
+}
+
+// checkDuplicates applies the duplicate action to a record about to be created.
+// A merge turns data into the updated oldest duplicate and returns that record as old.
+func (p *Manager) checkDuplicates(txn *badger.Txn, data *Data, res *InsertResult) (old *Data, err error) {
+	options := p.GetOptions().Duplicates
+	if options == nil {
+		return nil, nil
+	}
+	duplicates, err := p.findDuplicates(txn, options, *data)
+	if err != nil || len(duplicates) == 0 {
+		return nil, err
+	}
+	keys := make([]string, 0, len(duplicates))
+	for _, d := range duplicates {
+		keys = append(keys, d.Key)
+	}
+	switch options.Action {
+	case DuplicateReject:
+		res.Status = ItemStatusDuplicate
+		res.Message = "duplicate of " + strings.Join(keys, ",")
+	case DuplicateFlag:
+		data.DuplicateOf = keys
+		res.Message = "possible duplicate of " + strings.Join(keys, ",")
+	case DuplicateMerge:
+		target := duplicates[0]
+		merged := target
+		merged.Value = copyValue(target.Value)
+		for k, v := range data.Value {
+			merged.Value[k] = v
+		}
+		if data.Priority > merged.Priority {
+			merged.Priority = data.Priority
+		}
+		if data.Location != nil {
+			merged.Location = data.Location
+		}
+		*data = merged
+		res.Key = target.Key
+		res.Status = ItemStatusMerged
+		res.Message = "merged into " + target.Key
+		return &target, nil
+	}
+	return nil, nil
+}
+
+// DuplicateClusters groups the stored records that duplicate each other
+func (p *Manager) DuplicateClusters() ([]DuplicateCluster, error) {
+	options := p.GetOptions().Duplicates
+	if options == nil {
+		return nil, invalid("dataset has no duplicate detection")
+	}
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(k string) string {
+		if parent[k] == "" || parent[k] == k {
+			parent[k] = k
+			return k
+		}
+		parent[k] = find(parent[k])
+		return parent[k]
+	}
+
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		return p.iterateDataset(func(data Data) error {
+			duplicates, err := p.findDuplicates(txn, options, data)
+			if err != nil {
+				return err
+			}
+			for _, d := range duplicates {
+				a, b := find(data.Key), find(d.Key)
+				if a != b {
+					parent[a] = b
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]string)
+	for k := range parent {
+		root := find(k)
+		groups[root] = append(groups[root], k)
+	}
+	res := make([]DuplicateCluster, 0, len(groups))
+	for _, keys := range groups {
+		if len(keys) < 2 {
+			continue
+		}
+		sort.Strings(keys)
+		res = append(res, DuplicateCluster{Keys: keys})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i].Keys) != len(res[j].Keys) {
+			return len(res[i].Keys) > len(res[j].Keys)
+		}
+		return res[i].Keys[0] < res[j].Keys[0]
+	})
+	return res, nil
+}
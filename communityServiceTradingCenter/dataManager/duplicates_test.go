@@ -0,0 +1,99 @@
+package dataManager
+
+import (
+	"testing"
+)
+
+const (
+	testListing        = "Experienced cleaner offering weekly apartment cleaning, windows and kitchen included, friendly and reliable service"
+	testListingRepost  = "Experienced cleaner offering weekly apartment cleaning, windows and kitchen included, friendly and reliable service!!"
+	testListingChanged = "Gardener available for lawn mowing and hedge trimming on weekends, bring your own tools"
+)
+
+func TestDuplicateDetection(t *testing.T) {
+	dm, stop := newTestDataManager(t, "provider.")
+	defer stop()
+
+	options := &DuplicateOptions{
+		ExactFields: []string{"phone", "title"},
+		TextFields:  []string{"content"},
+		Action:      DuplicateReject,
+	}
+	if (&DuplicateOptions{TextFields: []string{"content"}, Action: "drop"}).Check() == nil {
+		t.Fatal("unknown action should be rejected")
+	}
+	exact, tooFar := 0, 4
+	if d := (&DuplicateOptions{MaxDistance: &exact}).maxDistance(); d != 0 {
+		t.Fatal("max distance 0 should match equal fingerprints only", d)
+	}
+	if d := (&DuplicateOptions{}).maxDistance(); d != defaultSimHashDistance {
+		t.Fatal("missing max distance should be the default", d)
+	}
+	if (&DuplicateOptions{TextFields: []string{"content"}, Action: DuplicateFlag, MaxDistance: &tooFar}).Check() == nil {
+		t.Fatal("max distance above the bands should be rejected")
+	}
+	dm.SetOptions(Options{Duplicates: options})
+
+	_, err := dm.InsertData([]Data{
+		{Key: "a", Value: map[string]string{"phone": "123", "title": "Cleaning", "content": testListing}},
+		{Key: "b", Value: map[string]string{"phone": "456", "title": "Garden", "content": testListingChanged}},
+	}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := dm.InsertData([]Data{
+		{Key: "c", Value: map[string]string{"phone": "123", "title": " cleaning ", "content": "short"}},
+		{Key: "d", Value: map[string]string{"phone": "789", "title": "Other", "content": testListingRepost}},
+		{Key: "e", Value: map[string]string{"phone": "789", "title": "Other", "content": "something else entirely, nothing alike"}},
+		{Key: "a", Value: map[string]string{"phone": "123", "title": "Cleaning", "content": testListing}},
+	}, InsertOption{BestEffort: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != ItemStatusDuplicate || results[1].Status != ItemStatusDuplicate {
+		t.Fatal("exact and near duplicates should be rejected", results)
+	}
+	// e matches nothing stored and updates of an existing key are not checked
+	if results[2].Status != ItemStatusCreated || results[3].Status != ItemStatusUpdated {
+		t.Fatal("unexpected results", results)
+	}
+
+	options.Action = DuplicateFlag
+	results, err = dm.InsertData([]Data{{Key: "f", Value: map[string]string{"content": testListingRepost}}}, InsertOption{})
+	if err != nil || results[0].Status != ItemStatusCreated {
+		t.Fatal("flagged duplicate should be created", results, err)
+	}
+	if data, _ := dm.getTestData(t, "f"); len(data.DuplicateOf) != 1 || data.DuplicateOf[0] != "a" {
+		t.Fatal("duplicate not flagged", data.DuplicateOf)
+	}
+
+	options.Action = DuplicateMerge
+	results, err = dm.InsertData([]Data{{Key: "g", Value: map[string]string{"phone": "456", "title": "garden", "price": "30"}, Priority: 9}}, InsertOption{})
+	if err != nil || results[0].Status != ItemStatusMerged || results[0].Key != "b" {
+		t.Fatal("duplicate should be merged", results, err)
+	}
+	if _, exists := dm.getTestData(t, "g"); exists {
+		t.Fatal("merged record should not be created")
+	}
+	if data, _ := dm.getTestData(t, "b"); data.Value["price"] != "30" || data.Value["content"] != testListingChanged || data.Priority != 9 {
+		t.Fatal("unexpected merged record", data)
+	}
+
+	clusters, err := dm.DuplicateClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || len(clusters[0].Keys) != 2 || clusters[0].Keys[0] != "a" || clusters[0].Keys[1] != "f" {
+		t.Fatal("unexpected clusters", clusters)
+	}
+
+	err = dm.DeleteData([]string{"f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, _ = dm.DuplicateClusters()
+	if len(clusters) != 0 {
+		t.Fatal("deleted record still clustered", clusters)
+	}
+}
@@ -0,0 +1,26 @@
+package dataManager
+
+import (
+	"moonlighting/common/database/badgerManager"
+)
+
+// The kinds of errors are those of badgerManager, so errors.Is(err, ErrNotFound) holds whichever layer failed
+var (
+	ErrNotFound    = badgerManager.ErrNotFound
+	ErrConflict    = badgerManager.ErrConflict
+	ErrValidation  = badgerManager.ErrValidation
+	ErrTooLarge    = badgerManager.ErrTooLarge
+	ErrUnavailable = badgerManager.ErrUnavailable
+)
+
+func notFound(message string) *badgerManager.Error {
+	return badgerManager.NewError(badgerManager.CodeNotFound, message)
+}
+
+func conflict(message string) *badgerManager.Error {
+	return badgerManager.NewError(badgerManager.CodeConflict, message)
+}
+
+func invalid(message string) *badgerManager.Error {
+	return badgerManager.NewError(badgerManager.CodeValidation, message)
+}
@@ -0,0 +1,289 @@
+package dataManager
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"math"
+)
+
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+func (g GeoPoint) Valid() bool {
+	return g.Lat >= -90 && g.Lat <= 90 && g.Lng >= -180 && g.Lng <= 180
+}
+
+// GeoRadius selects records within RadiusKm of the point, a radius of 0 only computes distances
+type GeoRadius struct {
+	Lat      float64 `json:"lat"`
+	Lng      float64 `json:"lng"`
+	RadiusKm float64 `json:"radiusKm"`
+}
+
+// GeoBox selects records inside the box, MinLng greater than MaxLng means the box crosses the 180th meridian
+type GeoBox struct {
+	MinLat float64 `json:"minLat"`
+	MinLng float64 `json:"minLng"`
+	MaxLat float64 `json:"maxLat"`
+	MaxLng float64 `json:"maxLng"`
+}
+
+const (
+	geoIndexPrefix    = "_geo."
+	geoIndexPrecision = 9
+	geoMaxCoverCells  = 64
+	earthRadiusKm     = 6371.0088
+	kmPerDegree       = 2 * math.Pi * earthRadiusKm / 360
+	geohashBase32     = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+func encodeGeohash(lat float64, lng float64, precision int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLng, maxLng := -180.0, 180.0
+	res := make([]byte, 0, precision)
+	bit, ch := 0, 0
+	evenBit := true
+	for len(res) < precision {
+		if evenBit {
+			mid := (minLng + maxLng) / 2
+			if lng >= mid {
+				ch = ch<<1 | 1
+				minLng = mid
+			} else {
+				ch = ch << 1
+				maxLng = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if lat >= mid {
+				ch = ch<<1 | 1
+				minLat = mid
+			} else {
+				ch = ch << 1
+				maxLat = mid
+			}
+		}
+		evenBit = !evenBit
+		bit += 1
+		if bit == 5 {
+			res = append(res, geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(res)
+}
+
+// geohashCellSize returns the height and width in degrees of a cell with the given precision
+func geohashCellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	lngBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lngBits))
+}
+
+func wrapLng(lng float64) float64 {
+	for lng > 180 {
+		lng -= 360
+	}
+	for lng < -180 {
+		lng += 360
+	}
+	return lng
+}
+
+func clampLat(lat float64) float64 {
+	return math.Max(-90, math.Min(90, lat))
+}
+
+// DistanceKm is the great-circle distance between a and b
+func DistanceKm(a GeoPoint, b GeoPoint) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (b.Lng - a.Lng) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// radiusCells returns geohash cells covering the circle, nil when the circle is too large for the index to help
+func radiusCells(center GeoPoint, radiusKm float64) []string {
+	// the narrowest cell width inside the circle is found at the latitude closest to a pole
+	edgeLat := math.Min(90, math.Abs(center.Lat)+radiusKm/kmPerDegree)
+	for precision := geoIndexPrecision; precision >= 1; precision-- {
+		latDeg, lngDeg := geohashCellSize(precision)
+		if latDeg*kmPerDegree < radiusKm || lngDeg*kmPerDegree*math.Cos(edgeLat*math.Pi/180) < radiusKm {
+			continue
+		}
+		set := make(map[string]struct{})
+		res := make([]string, 0, 9)
+		for _, dLat := range []float64{-latDeg, 0, latDeg} {
+			for _, dLng := range []float64{-lngDeg, 0, lngDeg} {
+				cell := encodeGeohash(clampLat(center.Lat+dLat), wrapLng(center.Lng+dLng), precision)
+				if _, ok := set[cell]; !ok {
+					set[cell] = struct{}{}
+					res = append(res, cell)
+				}
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func (b GeoBox) lngSpan() float64 {
+	if b.MinLng <= b.MaxLng {
+		return b.MaxLng - b.MinLng
+	}
+	return b.MaxLng + 360 - b.MinLng
+}
+
+func (b GeoBox) contains(g GeoPoint) bool {
+	if g.Lat < b.MinLat || g.Lat > b.MaxLat {
+		return false
+	}
+	if b.MinLng <= b.MaxLng {
+		return g.Lng >= b.MinLng && g.Lng <= b.MaxLng
+	}
+	return g.Lng >= b.MinLng || g.Lng <= b.MaxLng
+}
+
+// boxCells returns geohash cells covering the box, nil when it needs too many cells
+func boxCells(b GeoBox) []string {
+	for precision := geoIndexPrecision; precision >= 1; precision-- {
+		latDeg, lngDeg := geohashCellSize(precision)
+		nLat := int(math.Ceil((b.MaxLat-b.MinLat)/latDeg)) + 1
+		nLng := int(math.Ceil(b.lngSpan()/lngDeg)) + 1
+		if nLat*nLng > geoMaxCoverCells {
+			continue
+		}
+		set := make(map[string]struct{})
+		res := make([]string, 0, nLat*nLng)
+		// sampling with a step of one cell hits every row and column the box touches
+		for i := 0; i <= nLat; i++ {
+			lat := math.Min(b.MinLat+float64(i)*latDeg, b.MaxLat)
+			for j := 0; j <= nLng; j++ {
+				lng := wrapLng(b.MinLng + math.Min(float64(j)*lngDeg, b.lngSpan()))
+				cell := encodeGeohash(lat, lng, precision)
+				if _, ok := set[cell]; !ok {
+					set[cell] = struct{}{}
+					res = append(res, cell)
+				}
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func (p *Manager) geoIndexKey(hash string, key string) []byte {
+	return []byte(geoIndexPrefix + p.prefix + hash + "." + key)
+}
+
+func (p *Manager) addGeoIndex(txn *badger.Txn, data Data) error {
+	if data.Location == nil {
+		return nil
+	}
+	hash := encodeGeohash(data.Location.Lat, data.Location.Lng, geoIndexPrecision)
+	return txn.Set(p.geoIndexKey(hash, data.Key), nil)
+}
+
+func (p *Manager) removeGeoIndex(txn *badger.Txn, old Data) error {
+	if old.Location == nil {
+		return nil
+	}
+	hash := encodeGeohash(old.Location.Lat, old.Location.Lng, geoIndexPrecision)
+	return txn.Delete(p.geoIndexKey(hash, old.Key))
+}
+
+// geoCandidates collects the keys indexed under any of the cells
+func (p *Manager) geoCandidates(txn *badger.Txn, cells []string) map[string]struct{} {
+	res := make(map[string]struct{})
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	skip := len(geoIndexPrefix) + len(p.prefix) + geoIndexPrecision + 1
+	for _, cell := range cells {
+		opt.Prefix = []byte(geoIndexPrefix + p.prefix + cell)
+		iter := txn.NewIterator(opt)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			key := iter.Item().Key()
+			if len(key) > skip {
+				res[string(key[skip:])] = struct{}{}
+			}
+		}
+		iter.Close()
+	}
+	return res
+}
+
+type geoFilter struct {
+	near   *GeoRadius
+	within *GeoBox
+}
+
+func newGeoFilter(query Query) (*geoFilter, error) {
+	if query.Near == nil && query.Within == nil {
+		if query.SortByDistance {
+			return nil, invalid("sortByDistance needs near")
+		}
+		return nil, nil
+	}
+	if query.Near != nil {
+		if !(GeoPoint{Lat: query.Near.Lat, Lng: query.Near.Lng}).Valid() || query.Near.RadiusKm < 0 {
+			return nil, invalid("invalid near filter")
+		}
+	}
+	if query.Within != nil {
+		b := query.Within
+		if !(GeoPoint{Lat: b.MinLat, Lng: b.MinLng}).Valid() || !(GeoPoint{Lat: b.MaxLat, Lng: b.MaxLng}).Valid() || b.MinLat > b.MaxLat {
+			return nil, invalid("invalid within filter")
+		}
+	}
+	return &geoFilter{
+		near:   query.Near,
+		within: query.Within,
+	}, nil
+}
+
+// candidates narrows the keys to scan with the geohash index, nil means every key has to be checked
+func (f *geoFilter) candidates(p *Manager, txn *badger.Txn) map[string]struct{} {
+	var res map[string]struct{}
+	if f.near != nil && f.near.RadiusKm > 0 {
+		if cells := radiusCells(GeoPoint{Lat: f.near.Lat, Lng: f.near.Lng}, f.near.RadiusKm); cells != nil {
+			res = p.geoCandidates(txn, cells)
+		}
+	}
+	if f.within != nil {
+		if cells := boxCells(*f.within); cells != nil {
+			boxRes := p.geoCandidates(txn, cells)
+			if res == nil {
+				res = boxRes
+			} else {
+				for k := range res {
+					if _, ok := boxRes[k]; !ok {
+						delete(res, k)
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+// match reports whether data passes the filter and its distance to the near point if there is one
+func (f *geoFilter) match(data Data) (bool, *float64) {
+	if data.Location == nil {
+		return false, nil
+	}
+	if f.within != nil && !f.within.contains(*data.Location) {
+		return false, nil
+	}
+	if f.near == nil {
+		return true, nil
+	}
+	d := DistanceKm(GeoPoint{Lat: f.near.Lat, Lng: f.near.Lng}, *data.Location)
+	if f.near.RadiusKm > 0 && d > f.near.RadiusKm {
+		return false, nil
+	}
+	return true, &d
+}
@@ -0,0 +1,75 @@
+package dataManager
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeohash(t *testing.T) {
+	if h := encodeGeohash(57.64911, 10.40744, 11); h != "u4pruydqqvj" {
+		t.Fatal("unexpected geohash", h)
+	}
+	d := DistanceKm(GeoPoint{Lat: 39.9042, Lng: 116.4074}, GeoPoint{Lat: 31.2304, Lng: 121.4737})
+	if math.Abs(d-1067) > 5 {
+		t.Fatal("unexpected distance", d)
+	}
+	cells := boxCells(GeoBox{MinLat: 10, MinLng: 179.9, MaxLat: 10.1, MaxLng: -179.9})
+	if len(cells) == 0 || len(cells) > geoMaxCoverCells {
+		t.Fatal("unexpected box cover", cells)
+	}
+}
+
+func TestGeoQuery(t *testing.T) {
+	dm, stop := newTestDataManager(t, "geo.")
+	defer stop()
+
+	// offsets of about 0.5, 1.5 and 3 km north of the center
+	center := GeoPoint{Lat: 39.9042, Lng: 116.4074}
+	_, err := dm.InsertData([]Data{
+		{Key: "near", Priority: 1, Location: &GeoPoint{Lat: center.Lat + 0.0045, Lng: center.Lng}},
+		{Key: "mid", Priority: 3, Location: &GeoPoint{Lat: center.Lat + 0.0135, Lng: center.Lng}},
+		{Key: "far", Priority: 2, Location: &GeoPoint{Lat: center.Lat + 0.027, Lng: center.Lng}},
+		{Key: "none", Priority: 4},
+	}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm.updateSortKeyList()
+
+	res, _, totalCount, err := dm.QueryData(Query{
+		Near:           &GeoRadius{Lat: center.Lat, Lng: center.Lng, RadiusKm: 2},
+		SortByDistance: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totalCount != 2 || res[0].Key != "near" || res[1].Key != "mid" || *res[0].DistanceKm > 0.6 {
+		t.Fatal("unexpected radius result", res)
+	}
+
+	res, _, _, err = dm.QueryData(Query{
+		Within: &GeoBox{MinLat: center.Lat + 0.01, MinLng: center.Lng - 0.01, MaxLat: center.Lat + 0.03, MaxLng: center.Lng + 0.01},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Key != "mid" || res[1].Key != "far" || res[0].DistanceKm != nil {
+		t.Fatal("unexpected box result", res)
+	}
+
+	_, err = dm.InsertData([]Data{
+		{Key: "near", Priority: 1, Location: &GeoPoint{Lat: center.Lat + 0.5, Lng: center.Lng}},
+	}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _, _, err = dm.QueryData(Query{
+		Near: &GeoRadius{Lat: center.Lat, Lng: center.Lng, RadiusKm: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Key != "mid" {
+		t.Fatal("moved record should leave the old index cell", res)
+	}
+}
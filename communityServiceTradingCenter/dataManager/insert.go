@@ -0,0 +1,215 @@
+package dataManager
+
+import (
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"strings"
+)
+
+type WriteMode string
+
+const (
+	// WriteModeUpsert is used when no mode is given, it keeps the old overwrite behavior
+	WriteModeUpsert WriteMode = "upsert"
+	WriteModeCreate WriteMode = "create"
+	WriteModeUpdate WriteMode = "update"
+)
+
+type ItemStatus string
+
+const (
+	ItemStatusCreated  ItemStatus = "created"
+	ItemStatusUpdated  ItemStatus = "updated"
+	ItemStatusConflict ItemStatus = "conflict"
+	ItemStatusInvalid  ItemStatus = "invalid"
+	// ItemStatusDuplicate is a create rejected by duplicate detection
+	ItemStatusDuplicate ItemStatus = "duplicate"
+	// ItemStatusMerged is a create merged into an existing duplicate, Key is the key of that record
+	ItemStatusMerged ItemStatus = "merged"
+	// ItemStatusFiltered is an item rejected by the content filter
+	ItemStatusFiltered ItemStatus = "filtered"
+	// ItemStatusForbidden is an update of a record the actor neither owns nor edits
+	ItemStatusForbidden ItemStatus = "forbidden"
+)
+
+type InsertOption struct {
+	Mode WriteMode `json:"mode"`
+	// BestEffort writes every acceptable item, otherwise one failed item rolls back the whole batch
+	BestEffort bool `json:"bestEffort"`
+	// Actor owns the created records and must own or edit the updated ones, nil skips the checks and creates
+	// records without an owner
+	Actor *Actor `json:"-"`
+	// IfMatch lists ETags of which the stored record must have one, it is checked in the write transaction
+	// so a record changed since the client read it fails with a conflict
+	IfMatch []string `json:"ifMatch"`
+}
+
+type InsertResult struct {
+	Key     string     `json:"key"`
+	Status  ItemStatus `json:"status"`
+	Message string     `json:"message,omitempty"`
+	// Matches are the prohibited terms the content filter found
+	Matches []string `json:"matches,omitempty"`
+	// held is set when the content filter sends the item to moderation
+	held bool
+}
+
+func etagIn(etag string, list []string) bool {
+	for _, candidate := range list {
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ItemStatus) Failed() bool {
+	return s == ItemStatusConflict || s == ItemStatusInvalid || s == ItemStatusDuplicate || s == ItemStatusFiltered ||
+		s == ItemStatusForbidden
+}
+
+func (p *Manager) validateData(data Data) error {
+	if data.Key == "" {
+		return invalid("empty key")
+	}
+	if data.Location != nil && !data.Location.Valid() {
+		return invalid("location out of range")
+	}
+	if schema := p.GetOptions().Schema; schema != nil {
+		return schema.validate(data.Value)
+	}
+	return nil
+}
+
+// InsertData writes list according to option.Mode and reports the outcome of every item.
+// When the batch is rolled back the returned results still describe what each item would have become.
+func (p *Manager) InsertData(list []Data, option InsertOption) (results []InsertResult, err error) {
+	mode := option.Mode
+	if mode == "" {
+		mode = WriteModeUpsert
+	}
+	if mode != WriteModeUpsert && mode != WriteModeCreate && mode != WriteModeUpdate {
+		return nil, invalid("unknown write mode : " + string(mode))
+	}
+
+	err = p.update(func(b *writeBatch) error {
+		results = make([]InsertResult, len(list))
+		failed := 0
+		var err error
+		generate := p.GetOptions().Keys.Strategy != ""
+		for i, data := range list {
+			itemMode := mode
+			if data.Key == "" && generate {
+				data.Key, err = p.newKey()
+				if err != nil {
+					return err
+				}
+				itemMode = generatedKeyMode(mode)
+			}
+			var old *Data
+			results[i], old, err = p.insertOne(b.txn, &data, itemMode, option.Actor, option.IfMatch)
+			if err != nil {
+				return err
+			}
+			if results[i].Status.Failed() {
+				failed += 1
+				continue
+			}
+			prepareOwnership(old, &data, option.Actor)
+			p.prepareModeration(old, &data, nowMs(), results[i].held)
+			if data.Moderation != nil && data.Moderation.Status == ModerationPending && results[i].Message == "" {
+				results[i].Message = "pending review"
+			}
+			err = p.putData(b, old, data)
+			if err != nil {
+				return err
+			}
+		}
+		if failed > 0 && !option.BestEffort {
+			return invalid(fmt.Sprintf("batch rejected : %d of %d items failed", failed, len(list))).WithDetails(results)
+		}
+		return nil
+	})
+	return results, err
+}
+
+// insertOne decides what writing data would do, old is the stored record when there is one.
+// ifMatch is the precondition of InsertOption.IfMatch, empty skips it.
+func (p *Manager) insertOne(txn *badger.Txn, data *Data, mode WriteMode, actor *Actor, ifMatch []string) (res InsertResult, old *Data, err error) {
+	res = InsertResult{
+		Key: data.Key,
+	}
+	err = p.validateData(*data)
+	if err != nil {
+		res.Status = ItemStatusInvalid
+		res.Message = err.Error()
+		return res, nil, nil
+	}
+	res.Matches, res.held, err = p.filterContent(data)
+	if err != nil {
+		res.Status = ItemStatusFiltered
+		res.Message = err.Error()
+		return res, nil, nil
+	}
+	if res.held {
+		res.Message = "held for review : " + strings.Join(res.Matches, ",")
+	} else if len(res.Matches) > 0 {
+		res.Message = "masked : " + strings.Join(res.Matches, ",")
+	}
+	err = p.checkReferences(txn, *data)
+	if err != nil {
+		res.Status = ItemStatusInvalid
+		res.Message = err.Error()
+		return res, nil, nil
+	}
+	stored, exists, err := p.loadData(txn, data.Key)
+	if err != nil {
+		return res, nil, err
+	}
+	if exists {
+		old = &stored
+	}
+	if lifecycle := p.GetOptions().Lifecycle; lifecycle != nil {
+		err = lifecycle.prepareState(old, data, nowMs())
+		if err != nil {
+			res.Status = ItemStatusInvalid
+			res.Message = err.Error()
+			return res, old, nil
+		}
+	}
+	switch {
+	case len(ifMatch) > 0 && !exists:
+		res.Status = ItemStatusConflict
+		res.Message = "key not found"
+	case len(ifMatch) > 0 && !etagIn(stored.ETag(), ifMatch):
+		res.Status = ItemStatusConflict
+		res.Message = "record changed"
+	case exists && mode == WriteModeCreate:
+		res.Status = ItemStatusConflict
+		res.Message = "key already exists"
+	case !exists && mode == WriteModeUpdate:
+		res.Status = ItemStatusConflict
+		res.Message = "key not found"
+	case exists && !actor.mayEdit(stored):
+		res.Status = ItemStatusForbidden
+		res.Message = forbidden(data.Key).Error()
+	case exists:
+		res.Status = ItemStatusUpdated
+	default:
+		res.Status = ItemStatusCreated
+		// only creates are checked, reposting under a new key is what duplicates look like
+		merged, err := p.checkDuplicates(txn, data, &res)
+		if err != nil {
+			return res, nil, err
+		}
+		if merged != nil && !actor.mayEdit(*merged) {
+			res.Status = ItemStatusForbidden
+			res.Message = "duplicate of a record owned by another : " + merged.Key
+			return res, nil, nil
+		}
+		if merged != nil {
+			old = merged
+		}
+	}
+	return res, old, nil
+}
@@ -0,0 +1,104 @@
+package dataManager
+
+import (
+	"testing"
+)
+
+func TestInsertDataModes(t *testing.T) {
+	dm, stop := newTestDataManager(t, "insert.")
+	defer stop()
+
+	results, err := dm.InsertData([]Data{
+		{Key: "key1", Priority: 1},
+		{Key: "key2", Priority: 2},
+	}, InsertOption{Mode: WriteModeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if res.Status != ItemStatusCreated {
+			t.Fatal("unexpected status", res)
+		}
+	}
+
+	results, err = dm.InsertData([]Data{
+		{Key: "key1", Priority: 10},
+		{Key: "key3", Priority: 3},
+		{Key: "", Priority: 4},
+	}, InsertOption{Mode: WriteModeCreate})
+	if err == nil {
+		t.Fatal("all-or-nothing batch with failed items should be rejected")
+	}
+	if results[0].Status != ItemStatusConflict || results[1].Status != ItemStatusCreated || results[2].Status != ItemStatusInvalid {
+		t.Fatal("unexpected results", results)
+	}
+	if _, exists := dm.getTestData(t, "key3"); exists {
+		t.Fatal("rejected batch should not be written")
+	}
+
+	results, err = dm.InsertData([]Data{
+		{Key: "key1", Priority: 10},
+		{Key: "key3", Priority: 3},
+	}, InsertOption{Mode: WriteModeUpdate, BestEffort: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != ItemStatusUpdated || results[1].Status != ItemStatusConflict {
+		t.Fatal("unexpected results", results)
+	}
+	data, _ := dm.getTestData(t, "key1")
+	if data.Priority != 10 {
+		t.Fatal("update not applied", data)
+	}
+	if _, exists := dm.getTestData(t, "key3"); exists {
+		t.Fatal("update mode should not create records")
+	}
+
+	results, err = dm.InsertData([]Data{
+		{Key: "key3", Priority: 3},
+		{Key: "key2", Priority: 20},
+	}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != ItemStatusCreated || results[1].Status != ItemStatusUpdated {
+		t.Fatal("unexpected results", results)
+	}
+}
+
+func TestInsertIfMatch(t *testing.T) {
+	dm, stop := newTestDataManager(t, "ifMatch.")
+	defer stop()
+	dm.SetOptions(Options{
+		Lifecycle: DefaultLifecycle("deadline"),
+	})
+
+	_, err := dm.InsertData([]Data{{Key: "key1", Priority: 1}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// drafts are left out of queries but are still compared
+	stored, _ := dm.getTestData(t, "key1")
+	if stored.State != StateDraft || stored.Version != 1 {
+		t.Fatal("unexpected record", stored)
+	}
+	etag := stored.ETag()
+
+	update := func(key string, ifMatch ...string) InsertResult {
+		results, _ := dm.InsertData([]Data{{Key: key, Priority: 2}}, InsertOption{Mode: WriteModeUpdate, IfMatch: ifMatch})
+		return results[0]
+	}
+	if res := update("missing", etag); res.Status != ItemStatusConflict {
+		t.Fatal("missing record should fail the precondition", res)
+	}
+	if res := update("key1", `"other"`, etag); res.Status != ItemStatusUpdated {
+		t.Fatal("matching etag should update", res)
+	}
+	if res := update("key1", etag); res.Status != ItemStatusConflict || res.Message != "record changed" {
+		t.Fatal("stale etag should fail the precondition", res)
+	}
+	stored, _ = dm.getTestData(t, "key1")
+	if stored.Version != 2 || stored.ETag() == etag {
+		t.Fatal("write should change the version", stored)
+	}
+}
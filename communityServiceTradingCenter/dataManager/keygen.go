@@ -0,0 +1,135 @@
+package dataManager
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KeyStrategyULID     = "ulid"
+	KeyStrategyUUID     = "uuid"
+	KeyStrategySequence = "sequence"
+
+	sequencePrefix = "_seq."
+	// generatedKeyPlaceholder stands for the key a dry run would generate
+	generatedKeyPlaceholder = "<generated>"
+
+	crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+// KeyOptions generates the key of records inserted without one, an empty Strategy rejects them
+type KeyOptions struct {
+	Strategy string `json:"strategy"`
+	// Format of sequence keys, {seq} or {seq:N} is the number zero padded to N digits and
+	// {year}, {month} and {day} are the insert date. Every distinct date part has its own counter,
+	// so "PUB-{year}-{seq:6}" starts again at PUB-2027-000001.
+	Format string `json:"format"`
+}
+
+var sequencePlaceholder = regexp.MustCompile(`\{seq(?::(\d+))?\}`)
+
+func (o KeyOptions) Check() error {
+	switch o.Strategy {
+	case "", KeyStrategyULID, KeyStrategyUUID:
+	case KeyStrategySequence:
+		if len(sequencePlaceholder.FindAllString(o.Format, -1)) != 1 {
+			return invalid("sequence format needs exactly one {seq} : " + o.Format)
+		}
+	default:
+		return invalid("unknown key strategy : " + o.Strategy)
+	}
+	return nil
+}
+
+func randomBytes(n int) []byte {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// newULID returns 48 bits of unix ms and 80 random bits in crockford base32, sortable by creation time
+func newULID(now time.Time) string {
+	var id [16]byte
+	ms := uint64(now.UnixMilli())
+	binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	copy(id[6:], randomBytes(10))
+
+	// 128 bits are written as 26 characters of 5 bits, the first one only holds 3
+	res := make([]byte, 26)
+	hi := binary.BigEndian.Uint64(id[0:8])
+	lo := binary.BigEndian.Uint64(id[8:16])
+	for i := 25; i >= 0; i-- {
+		res[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(res)
+}
+
+// newUUID returns a random version 4 uuid
+func newUUID() string {
+	id := randomBytes(16)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	s := hex.EncodeToString(id)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+func (o KeyOptions) renderDate(now time.Time) string {
+	return strings.NewReplacer(
+		"{year}", fmt.Sprintf("%04d", now.Year()),
+		"{month}", fmt.Sprintf("%02d", int(now.Month())),
+		"{day}", fmt.Sprintf("%02d", now.Day()),
+	).Replace(o.Format)
+}
+
+// generatedKeyMode is the write mode of an item whose key the server generated. Such an item is always
+// created, a stored record under the same key (a reset sequence or an imported key) is a conflict and is not replaced.
+func generatedKeyMode(mode WriteMode) WriteMode {
+	if mode == WriteModeUpdate {
+		return mode
+	}
+	return WriteModeCreate
+}
+
+// newKey generates a key for a record inserted without one, numbers of rolled back batches are not reused
+func (p *Manager) newKey() (string, error) {
+	options := p.GetOptions().Keys
+	now := time.UnixMilli(int64(nowMs()))
+	switch options.Strategy {
+	case KeyStrategyULID:
+		return newULID(now), nil
+	case KeyStrategyUUID:
+		return newUUID(), nil
+	case KeyStrategySequence:
+		format := options.renderDate(now)
+		match := sequencePlaceholder.FindStringSubmatchIndex(format)
+		if match == nil {
+			return "", invalid("sequence format needs {seq} : " + options.Format)
+		}
+		counter := format[:match[0]] + format[match[1]:]
+		n, err := p.dbManager.NextSequence([]byte(sequencePrefix + p.prefix + counter))
+		if err != nil {
+			return "", err
+		}
+		number := strconv.FormatUint(n+1, 10)
+		if match[2] >= 0 {
+			width, _ := strconv.Atoi(format[match[2]:match[3]])
+			if pad := width - len(number); pad > 0 {
+				number = strings.Repeat("0", pad) + number
+			}
+		}
+		return format[:match[0]] + number + format[match[1]:], nil
+	}
+	return "", invalid("empty key")
+}
@@ -0,0 +1,79 @@
+package dataManager
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestKeyGeneration(t *testing.T) {
+	dm, stop := newTestDataManager(t, "publisher.")
+	defer stop()
+
+	defer func(f func() uint64) { nowMs = f }(nowMs)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	nowMs = func() uint64 { return uint64(now.UnixMilli()) }
+
+	results, err := dm.InsertData([]Data{{Value: map[string]string{"a": "1"}}}, InsertOption{})
+	if err == nil || results[0].Status != ItemStatusInvalid {
+		t.Fatal("empty key should be rejected without a key strategy", results, err)
+	}
+
+	if (KeyOptions{Strategy: KeyStrategySequence, Format: "PUB-{year}"}).Check() == nil {
+		t.Fatal("sequence format without {seq} should be rejected")
+	}
+	dm.SetOptions(Options{Keys: KeyOptions{Strategy: KeyStrategySequence, Format: "PUB-{year}-{seq:6}"}})
+	results, err = dm.InsertData([]Data{
+		{Value: map[string]string{"a": "1"}},
+		{Key: "manual", Value: map[string]string{"a": "2"}},
+		{Value: map[string]string{"a": "3"}},
+	}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Key != "PUB-2026-000001" || results[1].Key != "manual" || results[2].Key != "PUB-2026-000002" {
+		t.Fatal("unexpected keys", results)
+	}
+	if data, exists := dm.getTestData(t, "PUB-2026-000002"); !exists || data.Value["a"] != "3" {
+		t.Fatal("generated key not stored", data)
+	}
+
+	// a generated key never replaces a record stored under it, even in the default upsert mode
+	_, err = dm.InsertData([]Data{{Key: "PUB-2026-000003", Value: map[string]string{"a": "imported"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ = dm.InsertData([]Data{{Value: map[string]string{"a": "new"}}}, InsertOption{BestEffort: true})
+	if results[0].Key != "PUB-2026-000003" || results[0].Status != ItemStatusConflict {
+		t.Fatal("taken generated key should conflict", results)
+	}
+	if data, _ := dm.getTestData(t, "PUB-2026-000003"); data.Value["a"] != "imported" {
+		t.Fatal("stored record should be kept", data)
+	}
+
+	// a dry run does not consume numbers
+	results, err = dm.CheckData([]Data{{Value: map[string]string{"a": "4"}}}, WriteModeCreate)
+	if err != nil || results[0].Status != ItemStatusCreated {
+		t.Fatal("unexpected dry run", results, err)
+	}
+	now = now.AddDate(1, 0, 0)
+	results, _ = dm.InsertData([]Data{{}}, InsertOption{})
+	if results[0].Key != "PUB-2027-000001" {
+		t.Fatal("sequence should restart every year", results)
+	}
+
+	dm.SetOptions(Options{Keys: KeyOptions{Strategy: KeyStrategyULID}})
+	results, _ = dm.InsertData([]Data{{}}, InsertOption{})
+	first := results[0].Key
+	now = now.Add(time.Millisecond)
+	results, _ = dm.InsertData([]Data{{}}, InsertOption{})
+	if !regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}$`).MatchString(first) || results[0].Key <= first {
+		t.Fatal("unexpected ulid", first, results[0].Key)
+	}
+
+	dm.SetOptions(Options{Keys: KeyOptions{Strategy: KeyStrategyUUID}})
+	results, _ = dm.InsertData([]Data{{}}, InsertOption{})
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(results[0].Key) {
+		t.Fatal("unexpected uuid", results[0].Key)
+	}
+}
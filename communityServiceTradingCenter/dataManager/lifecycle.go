@@ -0,0 +1,266 @@
+package dataManager
+
+import (
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StateDraft         = "draft"
+	StatePendingReview = "pending_review"
+	StatePublished     = "published"
+	StateClosed        = "closed"
+	StateArchived      = "archived"
+
+	// AllStates in Query.States turns the state filter off
+	AllStates = "*"
+
+	lifecycleCheckInterval = time.Minute
+)
+
+type StateChange struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	AtMs   uint64 `json:"atMs"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type LifecycleOptions struct {
+	States []string `json:"states"`
+	// InitialState is given to created records that do not name a state
+	InitialState string              `json:"initialState"`
+	Transitions  map[string][]string `json:"transitions"`
+	// VisibleStates are returned by queries that do not ask for states
+	VisibleStates []string `json:"visibleStates"`
+	// LegacyState is assumed for records stored before the lifecycle was enabled
+	LegacyState string `json:"legacyState"`
+	// DeadlineField names the Value field holding the deadline as unix ms, RFC3339 or yyyy-mm-dd
+	DeadlineField string `json:"deadlineField"`
+	// DeadlineTransitions moves records from a state to another once their deadline has passed
+	DeadlineTransitions map[string]string `json:"deadlineTransitions"`
+}
+
+func DefaultLifecycle(deadlineField string) *LifecycleOptions {
+	return &LifecycleOptions{
+		States:       []string{StateDraft, StatePendingReview, StatePublished, StateClosed, StateArchived},
+		InitialState: StateDraft,
+		Transitions: map[string][]string{
+			StateDraft:         {StatePendingReview},
+			StatePendingReview: {StatePublished, StateDraft},
+			StatePublished:     {StateClosed},
+			StateClosed:        {StatePublished, StateArchived},
+		},
+		VisibleStates:       []string{StatePublished},
+		LegacyState:         StatePublished,
+		DeadlineField:       deadlineField,
+		DeadlineTransitions: map[string]string{StatePublished: StateClosed},
+	}
+}
+
+func (o *LifecycleOptions) hasState(state string) bool {
+	for _, s := range o.States {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *LifecycleOptions) allowed(from string, to string) bool {
+	for _, s := range o.Transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *LifecycleOptions) stateOf(data Data) string {
+	if data.State == "" {
+		return o.LegacyState
+	}
+	return data.State
+}
+
+// parseDeadline accepts unix ms, RFC3339 or a plain date which lasts until the end of that day
+func parseDeadline(s string) (uint64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	if ms, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return ms, true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return uint64(t.UnixMilli()), true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return uint64(t.AddDate(0, 0, 1).UnixMilli()), true
+	}
+	return 0, false
+}
+
+// prepareState sets the state of a record about to be written, an update keeps the stored state and history
+func (o *LifecycleOptions) prepareState(old *Data, data *Data, now uint64) error {
+	if old != nil {
+		data.State = o.stateOf(*old)
+		data.StateHistory = old.StateHistory
+		data.StateChangedMs = old.StateChangedMs
+		return nil
+	}
+	if data.State == "" {
+		data.State = o.InitialState
+	}
+	if !o.hasState(data.State) {
+		return invalid("unknown state : " + data.State)
+	}
+	data.StateHistory = []StateChange{{To: data.State, AtMs: now}}
+	data.StateChangedMs = now
+	return nil
+}
+
+func changeState(data *Data, to string, reason string, now uint64, from string) {
+	data.State = to
+	data.StateChangedMs = now
+	data.StateHistory = append(append([]StateChange{}, data.StateHistory...), StateChange{
+		From:   from,
+		To:     to,
+		AtMs:   now,
+		Reason: reason,
+	})
+}
+
+// TransitionState moves every key to state to, the batch fails if one of the moves is not allowed
+func (p *Manager) TransitionState(keys []string, to string, reason string) error {
+	return p.TransitionStateAs(keys, to, reason, nil)
+}
+
+// TransitionStateAs moves keys to state when actor may change every record of them
+func (p *Manager) TransitionStateAs(keys []string, to string, reason string, actor *Actor) error {
+	lifecycle := p.GetOptions().Lifecycle
+	if lifecycle == nil {
+		return invalid("dataset has no lifecycle")
+	}
+	if !lifecycle.hasState(to) {
+		return invalid("unknown state : " + to)
+	}
+	now := nowMs()
+	return p.update(func(b *writeBatch) error {
+		for _, key := range keys {
+			data, exists, err := p.loadData(b.txn, key)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return notFound("key not found : " + key)
+			}
+			if !actor.mayEdit(data) {
+				return forbidden(key)
+			}
+			from := lifecycle.stateOf(data)
+			if !lifecycle.allowed(from, to) {
+				return conflict("transition not allowed : " + key + " " + from + " -> " + to)
+			}
+			old := data
+			changeState(&data, to, reason, now, from)
+			err = p.putData(b, &old, data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// applyDeadlines runs the deadline transitions for every record whose deadline has passed
+func (p *Manager) applyDeadlines() {
+	lifecycle := p.GetOptions().Lifecycle
+	if lifecycle == nil || lifecycle.DeadlineField == "" || len(lifecycle.DeadlineTransitions) == 0 {
+		return
+	}
+	now := nowMs()
+	due := make([]string, 0)
+	err := p.iterateDataset(func(data Data) error {
+		if _, ok := lifecycle.DeadlineTransitions[lifecycle.stateOf(data)]; !ok {
+			return nil
+		}
+		deadline, ok := parseDeadline(data.Value[lifecycle.DeadlineField])
+		if ok && deadline <= now {
+			due = append(due, data.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("scan deadlines failed", zap.String("prefix", p.prefix), zap.Error(err))
+		return
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	moved := 0
+	err = p.update(func(b *writeBatch) error {
+		moved = 0
+		for _, key := range due {
+			data, exists, err := p.loadData(b.txn, key)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			from := lifecycle.stateOf(data)
+			to, ok := lifecycle.DeadlineTransitions[from]
+			if !ok {
+				continue
+			}
+			old := data
+			changeState(&data, to, "deadline passed", now, from)
+			err = p.putData(b, &old, data)
+			if err != nil {
+				return err
+			}
+			moved += 1
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("apply deadlines failed", zap.String("prefix", p.prefix), zap.Error(err))
+		return
+	}
+	p.logger.Info("deadline transitions applied", zap.String("prefix", p.prefix), zap.Int("count", moved))
+}
+
+type stateFilter map[string]struct{}
+
+// newStateFilter returns nil when every state should be returned
+func newStateFilter(lifecycle *LifecycleOptions, states []string) stateFilter {
+	if len(states) == 0 {
+		if lifecycle == nil {
+			return nil
+		}
+		states = lifecycle.VisibleStates
+	}
+	res := make(stateFilter)
+	for _, s := range states {
+		if s == AllStates {
+			return nil
+		}
+		res[s] = struct{}{}
+	}
+	return res
+}
+
+func (f stateFilter) match(lifecycle *LifecycleOptions, data Data) bool {
+	if f == nil {
+		return true
+	}
+	state := data.State
+	if lifecycle != nil {
+		state = lifecycle.stateOf(data)
+	}
+	_, ok := f[state]
+	return ok
+}
@@ -0,0 +1,112 @@
+package dataManager
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLifecycle(t *testing.T) {
+	dm, stop := newTestDataManager(t, "lifecycle.")
+	defer stop()
+
+	// a record stored before the lifecycle is enabled counts as published
+	_, err := dm.InsertData([]Data{{Key: "legacy", Priority: 1}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm.SetOptions(Options{
+		Lifecycle: DefaultLifecycle("deadline"),
+	})
+
+	deadline := strconv.FormatUint(nowMs()+3600*1000, 10)
+	results, err := dm.InsertData([]Data{
+		{Key: "p1", Priority: 3, Value: map[string]string{"deadline": deadline}},
+		{Key: "p2", Priority: 2, State: "unknown"},
+	}, InsertOption{BestEffort: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[1].Status != ItemStatusInvalid {
+		t.Fatal("unknown state should be rejected", results)
+	}
+	data, _ := dm.getTestData(t, "p1")
+	if data.State != StateDraft || len(data.StateHistory) != 1 {
+		t.Fatal("unexpected initial state", data)
+	}
+
+	queryKeys := func(states ...string) []string {
+		dm.updateSortKeyList()
+		res, _, _, err := dm.QueryData(Query{States: states})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := make([]string, 0)
+		for _, r := range res {
+			keys = append(keys, r.Key)
+		}
+		return keys
+	}
+	if keys := queryKeys(); len(keys) != 1 || keys[0] != "legacy" {
+		t.Fatal("drafts should be hidden by default", keys)
+	}
+	if keys := queryKeys(AllStates); len(keys) != 2 {
+		t.Fatal("all states should be returned", keys)
+	}
+	// exports leave out the same records unless every state is asked for
+	out := &bytes.Buffer{}
+	if err = dm.Export(out, ExportOption{Format: TransferFormatNDJSON}); err != nil || strings.Count(out.String(), "\n") != 1 {
+		t.Fatal("drafts should not be exported", out.String(), err)
+	}
+	out.Reset()
+	if err = dm.Export(out, ExportOption{Format: TransferFormatNDJSON, AllStates: true}); err != nil || strings.Count(out.String(), "\n") != 2 {
+		t.Fatal("all states should be exported", out.String(), err)
+	}
+
+	err = dm.TransitionState([]string{"p1"}, StatePublished, "")
+	if err == nil {
+		t.Fatal("draft cannot be published directly")
+	}
+	err = dm.TransitionState([]string{"p1"}, StatePendingReview, "submit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dm.TransitionState([]string{"p1"}, StatePublished, "approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an update keeps the state even if the client sends another one
+	_, err = dm.InsertData([]Data{
+		{Key: "p1", Priority: 3, State: StateDraft, Value: map[string]string{"deadline": deadline}},
+	}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := queryKeys(); len(keys) != 2 || keys[0] != "p1" {
+		t.Fatal("published record should be visible", keys)
+	}
+
+	dm.applyDeadlines()
+	data, _ = dm.getTestData(t, "p1")
+	if data.State != StatePublished {
+		t.Fatal("deadline has not passed yet", data)
+	}
+
+	defer func(f func() uint64) {
+		nowMs = f
+	}(nowMs)
+	later := nowMs() + 2*3600*1000
+	nowMs = func() uint64 {
+		return later
+	}
+	dm.applyDeadlines()
+	data, _ = dm.getTestData(t, "p1")
+	if data.State != StateClosed || len(data.StateHistory) != 4 || data.StateChangedMs != later {
+		t.Fatal("deadline should close the record", data)
+	}
+	if keys := queryKeys(StateClosed); len(keys) != 1 || keys[0] != "p1" {
+		t.Fatal("unexpected closed records", keys)
+	}
+}
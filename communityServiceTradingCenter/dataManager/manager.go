@@ -2,15 +2,17 @@ package dataManager
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
-	"errors"
+	"encoding/hex"
+	"encoding/json"
 	"github.com/dgraph-io/badger/v3"
 	"go.uber.org/zap"
 	"moonlighting/common/database/badgerManager"
 	"moonlighting/common/logger"
-	"regexp"
 	"sort"
 	"sync"
+	"time"
 )
 
 type Data struct {
@@ -28,6 +30,25 @@ type Data struct {
 	Key      string            `json:"key"`
 	Value    map[string]string `json:"value"`
 	Priority uint64            `json:"priority"`
+	Location *GeoPoint         `json:"location,omitempty"`
+	// CreateTimeMs and UpdateTimeMs are maintained by the server
+	CreateTimeMs uint64 `json:"createTimeMs"`
+	UpdateTimeMs uint64 `json:"updateTimeMs"`
+	// Version counts the writes of the record, it is maintained by the server
+	Version       uint64  `json:"version"`
+	PinnedUntilMs uint64  `json:"pinnedUntilMs,omitempty"`
+	Boosts        []Boost `json:"boosts,omitempty"`
+	// State is only changed through lifecycle transitions once the record exists
+	State          string        `json:"state,omitempty"`
+	StateChangedMs uint64        `json:"stateChangedMs,omitempty"`
+	StateHistory   []StateChange `json:"stateHistory,omitempty"`
+	// DuplicateOf lists the records this one was flagged as a duplicate of when created
+	DuplicateOf []string `json:"duplicateOf,omitempty"`
+	// Moderation is maintained by the server in moderated datasets
+	Moderation *Moderation `json:"moderation,omitempty"`
+	// Owner is the principal that created the record, Editors may change it too, both are maintained by the server
+	Owner   string   `json:"owner,omitempty"`
+	Editors []string `json:"editors,omitempty"`
 }
 
 func init() {
@@ -55,13 +76,35 @@ func deSerializeData(buffer []byte) Data {
 	return data
 }
 
+// ETag identifies the stored version of data, it is computed from stored fields only so it changes on writes alone
+func (data Data) ETag() string {
+	tagged, _ := json.Marshal(struct {
+		Key      string
+		Priority uint64
+		Value    map[string]string
+		Version  uint64
+	}{data.Key, data.Priority, data.Value, data.Version})
+	sum := sha256.Sum256(tagged)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
 type Manager struct {
+	// generation changes on every write, it comes first to stay 64 bit aligned for atomic access
+	generation              uint64
 	logger                  logger.ILogger
+	name                    string
 	prefix                  string
+	directory               *Directory
 	dbManager               *badgerManager.Manager
 	updateSortKeyListSignal chan int
 	sortKeyListLock         sync.RWMutex
 	sortKeyList             []string
+	optionsLock             sync.RWMutex
+	options                 Options
+	wordFilter              *WordFilter
+	hooksLock               sync.RWMutex
+	hooks                   hooks
+	cache                   *queryCache
 	stopSignal              chan int
 	stopOnce                sync.Once
 }
@@ -74,6 +117,11 @@ func NewDataManager(l logger.ILogger, prefix string, dbManager *badgerManager.Ma
 		updateSortKeyListSignal: make(chan int, 50),
 		sortKeyListLock:         sync.RWMutex{},
 		sortKeyList:             make([]string, 0),
+		optionsLock:             sync.RWMutex{},
+		options:                 Options{},
+		hooksLock:               sync.RWMutex{},
+		hooks:                   hooks{},
+		cache:                   newQueryCache(),
 		stopSignal:              make(chan int),
 		stopOnce:                sync.Once{},
 	}
@@ -99,12 +147,18 @@ func (p *Manager) Stop() {
 }
 
 func (p *Manager) loopMain() {
+	lifecycleTicker := time.NewTicker(lifecycleCheckInterval)
+	defer lifecycleTicker.Stop()
 	for true {
 		select {
 		case <-p.stopSignal:
 			{
 				return
 			}
+		case <-lifecycleTicker.C:
+			{
+				p.applyDeadlines()
+			}
 		case <-p.updateSortKeyListSignal:
 			{
 				//clean channel before update
@@ -136,6 +190,9 @@ func (p *Manager) updateSortKeyList() {
 	err := p.dbManager.IterateData(func(key []byte, value []byte) {
 		kStr := string(key)
 		data := deSerializeData(value)
+		if !p.listed(data) {
+			return
+		}
 		kuPairList = append(kuPairList, kuPair{
 			key:      kStr,
 			priority: data.Priority,
@@ -156,6 +213,7 @@ func (p *Manager) updateSortKeyList() {
 	p.sortKeyListLock.Lock()
 	defer p.sortKeyListLock.Unlock()
 	p.sortKeyList = tmp
+	p.bumpGeneration()
 }
 
 func (p *Manager) getSortKeyList() []string {
@@ -165,128 +223,87 @@ func (p *Manager) getSortKeyList() []string {
 	return sklCopy
 }
 
-func (p *Manager) InsertData(list []Data) (err error) {
-	dataList := make([]badgerManager.DataSet, 0)
-	for _, data := range list {
-		if data.Key == "" {
-			return errors.New("contains empty key")
+func (p *Manager) dbKey(key string) []byte {
+	return []byte(p.prefix + key)
+}
+
+func (p *Manager) loadData(txn *badger.Txn, key string) (Data, bool, error) {
+	item, err := txn.Get(p.dbKey(key))
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return Data{}, false, nil
 		}
-		dataList = append(dataList, badgerManager.DataSet{
-			Key:   []byte(p.prefix + data.Key),
-			Value: serializeData(data),
-		})
+		return Data{}, false, err
 	}
-	defer func() {
-		if err == nil {
-			p.updateSortKeyListSignal <- 1
-		}
-	}()
-	return p.dbManager.InsertData(dataList)
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return Data{}, false, err
+	}
+	return deSerializeData(value), true, nil
+}
+
+// GetData loads one record by key, exists is false when there is no such record
+func (p *Manager) GetData(key string) (data Data, exists bool, err error) {
+	err = p.dbManager.ViewData(func(txn *badger.Txn) error {
+		data, exists, err = p.loadData(txn, key)
+		return err
+	})
+	return data, exists, err
 }
 
-func (p *Manager) DeleteData(k []string) (err error) {
-	keyList := make([][]byte, len(k))
-	for i := 0; i < len(keyList); i++ {
-		keyList[i] = []byte(p.prefix + k[i])
+func copyValue(value map[string]string) map[string]string {
+	if value == nil {
+		return nil
 	}
-	defer func() {
-		if err == nil {
-			p.updateSortKeyListSignal <- 1
-		}
-	}()
-	return p.dbManager.DeleteData(keyList)
+	res := make(map[string]string, len(value))
+	for k, v := range value {
+		res[k] = v
+	}
+	return res
 }
 
-func (p *Manager) QueryData(limit int, page int, matchRules []map[string]string) (res []Data, count int, totalCount int, err error) {
-	skip := 0
-	if limit > 0 && page > 0 {
-		skip = limit * (page - 1)
+// copyData shares nothing with data, callers may change the maps, slices and pointers of the result
+func copyData(data Data) Data {
+	data.Value = copyValue(data.Value)
+	if data.Location != nil {
+		location := *data.Location
+		data.Location = &location
 	}
-	count = 0
-	totalCount = 0
-	valueBuffer := make([]byte, 0)
-	res = make([]Data, 0)
-	err = p.dbManager.ViewData(func(txn *badger.Txn) error {
-		kList := p.getSortKeyList()
-		p.logger.Info("", zap.Any("kList", kList))
-		for _, key := range kList {
-			item, err := txn.Get([]byte(key))
+	data.Boosts = append([]Boost(nil), data.Boosts...)
+	data.StateHistory = append([]StateChange(nil), data.StateHistory...)
+	data.DuplicateOf = append([]string(nil), data.DuplicateOf...)
+	if data.Moderation != nil {
+		moderation := *data.Moderation
+		moderation.History = append([]ModerationEvent(nil), moderation.History...)
+		data.Moderation = &moderation
+	}
+	data.Editors = append([]string(nil), data.Editors...)
+	return data
+}
+
+func (p *Manager) DeleteData(k []string) error {
+	return p.DeleteDataAs(k, nil)
+}
+
+// DeleteDataAs deletes k when actor may change every record of it
+func (p *Manager) DeleteDataAs(k []string, actor *Actor) error {
+	return p.update(func(b *writeBatch) error {
+		for _, key := range k {
+			old, exists, err := p.loadData(b.txn, key)
 			if err != nil {
-				if err == badger.ErrKeyNotFound {
-					continue
-				} else {
-					return err
-				}
+				return err
 			}
-			if item == nil {
+			if !exists {
 				continue
 			}
-
-			valueBuffer, err = item.ValueCopy(valueBuffer)
+			if !actor.mayEdit(old) {
+				return forbidden(key)
+			}
+			err = p.removeData(b, old)
 			if err != nil {
 				return err
 			}
-			data := deSerializeData(valueBuffer)
-			alreadyMatch := false
-
-			if matchRules != nil && len(matchRules) > 0 {
-				for _, matchRule := range matchRules {
-
-					if alreadyMatch {
-						break
-					}
-
-					if matchRule == nil || len(matchRule) <= 0 {
-						continue
-					}
-
-					currentRuleMatch := true
-
-					for k, v := range matchRule {
-						fieldVal, ok := data.Value[k]
-						if !ok {
-							currentRuleMatch = false
-							break
-						}
-						matched, err := regexp.MatchString(v, fieldVal)
-						if err != nil {
-							return err
-						}
-						if !matched {
-							currentRuleMatch = false
-							break
-						}
-					}
-
-					if currentRuleMatch {
-						alreadyMatch = true
-					}
-
-				}
-			} else {
-				alreadyMatch = true
-			}
-
-			if alreadyMatch {
-				totalCount += 1
-				if limit > 0 && page > 0 {
-					if totalCount > skip && count < limit {
-						count += 1
-						res = append(res, data)
-					}
-				} else {
-					count += 1
-					res = append(res, data)
-				}
-
-			}
-
 		}
 		return nil
 	})
-	if err != nil {
-		return nil, 0, 0, err
-	}
-
-	return res, count, totalCount, nil
 }
@@ -1,6 +1,7 @@
 package dataManager
 
 import (
+	"github.com/dgraph-io/badger/v3"
 	"go.uber.org/zap/zapcore"
 	"moonlighting/common/database/badgerManager"
 	"moonlighting/common/logger/console"
@@ -21,7 +22,7 @@ func TestManager(t *testing.T) {
 
 	<-time.After(2 * time.Second)
 
-	err := testDataManager.InsertData([]Data{
+	_, err := testDataManager.InsertData([]Data{
 		{
 			Key: "key1",
 			Value: map[string]string{
@@ -50,12 +51,40 @@ func TestManager(t *testing.T) {
 			},
 			Priority: 42,
 		},
-	})
+	}, InsertOption{})
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	res, count, totalCount, err := testDataManager.QueryData(2, 1, nil)
+	res, count, totalCount, err := testDataManager.QueryData(Query{Limit: 2, Page: 1})
 	t.Log(res, count, totalCount, err)
 }
+
+func newTestDataManager(t *testing.T, prefix string) (*Manager, func()) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+
+	dm := NewDataManager(l, prefix, m)
+	go dm.Start()
+
+	return dm, func() {
+		dm.Stop()
+		m.Stop()
+	}
+}
+
+func (p *Manager) getTestData(t *testing.T, key string) (Data, bool) {
+	var data Data
+	var exists bool
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		var err error
+		data, exists, err = p.loadData(txn, key)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, exists
+}
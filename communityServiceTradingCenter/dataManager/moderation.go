@@ -0,0 +1,268 @@
+package dataManager
+
+import (
+	"sort"
+)
+
+const (
+	ModerationPending          = "pending"
+	ModerationApproved         = "approved"
+	ModerationRejected         = "rejected"
+	ModerationChangesRequested = "changes_requested"
+
+	DecisionSubmit         = "submit"
+	DecisionAssign         = "assign"
+	DecisionApprove        = "approve"
+	DecisionReject         = "reject"
+	DecisionRequestChanges = "request_changes"
+
+	moderationRoundRobinPrefix = "_moderation.rr."
+)
+
+type ModerationOptions struct {
+	// Reviewers are assigned to new submissions in turn, any reviewer may decide when empty
+	Reviewers []string `json:"reviewers"`
+	// ReviewUpdates sends approved records back to the queue when they are changed
+	ReviewUpdates bool `json:"reviewUpdates"`
+}
+
+type ModerationEvent struct {
+	Action   string `json:"action"`
+	Reviewer string `json:"reviewer,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	AtMs     uint64 `json:"atMs"`
+}
+
+// Moderation is kept on the record, records stored before moderation was enabled have none and count as approved
+type Moderation struct {
+	Status      string            `json:"status"`
+	Reviewer    string            `json:"reviewer,omitempty"`
+	Reason      string            `json:"reason,omitempty"`
+	SubmittedMs uint64            `json:"submittedMs"`
+	DecidedMs   uint64            `json:"decidedMs,omitempty"`
+	History     []ModerationEvent `json:"history"`
+}
+
+type ModerationDecision struct {
+	Key      string `json:"key"`
+	Action   string `json:"action"`
+	Reviewer string `json:"reviewer"`
+	Reason   string `json:"reason"`
+}
+
+func (m *Moderation) with(status string, event ModerationEvent) *Moderation {
+	res := *m
+	res.Status = status
+	res.History = append(append([]ModerationEvent{}, m.History...), event)
+	return &res
+}
+
+// listed tells if a record may enter the sort key list, only approved records do in moderated datasets
+func (p *Manager) listed(data Data) bool {
+	if p.GetOptions().Moderation == nil || data.Moderation == nil {
+		return true
+	}
+	return data.Moderation.Status == ModerationApproved
+}
+
+func (p *Manager) nextReviewer(options *ModerationOptions) string {
+	if len(options.Reviewers) == 0 {
+		return ""
+	}
+	n, err := p.dbManager.NextSequence([]byte(moderationRoundRobinPrefix + p.prefix))
+	if err != nil {
+		return ""
+	}
+	return options.Reviewers[n%uint64(len(options.Reviewers))]
+}
+
+// prepareModeration puts new records in the queue and keeps the moderation of stored ones,
+// rejected records and records with requested changes are submitted again when they are changed.
+// held sends a changed record back to the queue even when updates are not reviewed.
+func (p *Manager) prepareModeration(old *Data, data *Data, now uint64, held bool) {
+	options := p.GetOptions().Moderation
+	if old != nil {
+		data.Moderation = old.Moderation
+	} else {
+		data.Moderation = nil
+	}
+	if options == nil {
+		return
+	}
+	submit := ModerationEvent{Action: DecisionSubmit, AtMs: now}
+	if held {
+		submit.Reason = "content filter match"
+	}
+	if old == nil {
+		reviewer := p.nextReviewer(options)
+		data.Moderation = &Moderation{
+			Status:      ModerationPending,
+			Reviewer:    reviewer,
+			SubmittedMs: now,
+			History:     []ModerationEvent{submit},
+		}
+		return
+	}
+	current := data.Moderation
+	if current == nil {
+		if !options.ReviewUpdates && !held {
+			return
+		}
+		current = &Moderation{Status: ModerationApproved}
+	}
+	switch current.Status {
+	case ModerationRejected, ModerationChangesRequested:
+	case ModerationApproved:
+		if !options.ReviewUpdates && !held {
+			return
+		}
+	default:
+		return
+	}
+	data.Moderation = current.with(ModerationPending, submit)
+	data.Moderation.SubmittedMs = now
+	data.Moderation.DecidedMs = 0
+	data.Moderation.Reason = ""
+}
+
+func (o *ModerationOptions) isReviewer(reviewer string) bool {
+	if len(o.Reviewers) == 0 {
+		return reviewer != ""
+	}
+	for _, r := range o.Reviewers {
+		if r == reviewer {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide records the decision of a reviewer on a pending record
+func (p *Manager) Decide(decision ModerationDecision) error {
+	options := p.GetOptions().Moderation
+	if options == nil {
+		return invalid("dataset is not moderated")
+	}
+	if !options.isReviewer(decision.Reviewer) {
+		return invalid("unknown reviewer : " + decision.Reviewer)
+	}
+	var status string
+	switch decision.Action {
+	case DecisionApprove:
+		status = ModerationApproved
+	case DecisionReject:
+		status = ModerationRejected
+	case DecisionRequestChanges:
+		status = ModerationChangesRequested
+	default:
+		return invalid("unknown decision : " + decision.Action)
+	}
+	if status != ModerationApproved && decision.Reason == "" {
+		return invalid("a reason is required to " + decision.Action)
+	}
+	now := nowMs()
+	return p.update(func(b *writeBatch) error {
+		data, exists, err := p.loadData(b.txn, decision.Key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return notFound("key not found : " + decision.Key)
+		}
+		if data.Moderation == nil || data.Moderation.Status != ModerationPending {
+			return conflict("record is not pending review : " + decision.Key)
+		}
+		old := data
+		data.Moderation = data.Moderation.with(status, ModerationEvent{
+			Action:   decision.Action,
+			Reviewer: decision.Reviewer,
+			Reason:   decision.Reason,
+			AtMs:     now,
+		})
+		data.Moderation.Reason = decision.Reason
+		data.Moderation.DecidedMs = now
+		return p.putData(b, &old, data)
+	})
+}
+
+// Assign hands pending records to reviewer
+func (p *Manager) Assign(keys []string, reviewer string) error {
+	options := p.GetOptions().Moderation
+	if options == nil {
+		return invalid("dataset is not moderated")
+	}
+	if !options.isReviewer(reviewer) {
+		return invalid("unknown reviewer : " + reviewer)
+	}
+	now := nowMs()
+	return p.update(func(b *writeBatch) error {
+		for _, key := range keys {
+			data, exists, err := p.loadData(b.txn, key)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return notFound("key not found : " + key)
+			}
+			if data.Moderation == nil || data.Moderation.Status != ModerationPending {
+				return conflict("record is not pending review : " + key)
+			}
+			old := data
+			data.Moderation = data.Moderation.with(ModerationPending, ModerationEvent{
+				Action:   DecisionAssign,
+				Reviewer: reviewer,
+				AtMs:     now,
+			})
+			data.Moderation.Reviewer = reviewer
+			err = p.putData(b, &old, data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ModerationQueue lists the records in status, pending when empty, oldest submission first.
+// An empty reviewer lists the records of every reviewer.
+func (p *Manager) ModerationQueue(status string, reviewer string) ([]Data, error) {
+	if p.GetOptions().Moderation == nil {
+		return nil, invalid("dataset is not moderated")
+	}
+	if status == "" {
+		status = ModerationPending
+	}
+	res := make([]Data, 0)
+	err := p.iterateDataset(func(data Data) error {
+		if data.Moderation == nil || data.Moderation.Status != status {
+			return nil
+		}
+		if reviewer != "" && data.Moderation.Reviewer != reviewer {
+			return nil
+		}
+		res = append(res, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Moderation.SubmittedMs < res[j].Moderation.SubmittedMs
+	})
+	return res, nil
+}
+
+// ModerationHistory returns the moderation state and decision history of a record
+func (p *Manager) ModerationHistory(key string) (*Moderation, error) {
+	data, exists, err := p.GetData(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, notFound("key not found : " + key)
+	}
+	if data.Moderation == nil {
+		return nil, notFound("record has no moderation history : " + key)
+	}
+	return data.Moderation, nil
+}
@@ -0,0 +1,109 @@
+package dataManager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModeration(t *testing.T) {
+	dm, stop := newTestDataManager(t, "publisher.")
+	defer stop()
+
+	_, err := dm.InsertData([]Data{{Key: "legacy", Value: map[string]string{"a": "0"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm.SetOptions(Options{Moderation: &ModerationOptions{Reviewers: []string{"alice", "bob"}}})
+
+	// a client cannot approve its own record
+	results, err := dm.InsertData([]Data{
+		{Key: "a", Value: map[string]string{"a": "1"}, Moderation: &Moderation{Status: ModerationApproved}},
+		{Key: "b", Value: map[string]string{"a": "2"}},
+	}, InsertOption{})
+	if err != nil || results[0].Message != "pending review" {
+		t.Fatal("unexpected results", results, err)
+	}
+	dm.updateSortKeyList()
+	if list := dm.getSortKeyList(); len(list) != 1 || list[0] != dm.prefix+"legacy" {
+		t.Fatal("pending records should not be listed", list)
+	}
+	out := &strings.Builder{}
+	if err = dm.Export(out, ExportOption{Format: TransferFormatNDJSON}); err != nil || strings.Count(out.String(), "\n") != 1 {
+		t.Fatal("pending records should not be exported", out.String(), err)
+	}
+	out.Reset()
+	if err = dm.Export(out, ExportOption{Format: TransferFormatNDJSON, IncludeUnlisted: true}); err != nil || strings.Count(out.String(), "\n") != 3 {
+		t.Fatal("moderators should export every record", out.String(), err)
+	}
+
+	queue, err := dm.ModerationQueue("", "")
+	if err != nil || len(queue) != 2 || queue[0].Moderation.Reviewer == queue[1].Moderation.Reviewer {
+		t.Fatal("records should be assigned in turn", queue, err)
+	}
+	err = dm.Assign([]string{"a", "b"}, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue, _ = dm.ModerationQueue(ModerationPending, "bob"); len(queue) != 2 {
+		t.Fatal("records not assigned", queue)
+	}
+
+	if dm.Decide(ModerationDecision{Key: "a", Action: DecisionApprove, Reviewer: "mallory"}) == nil {
+		t.Fatal("unknown reviewer should be rejected")
+	}
+	if dm.Decide(ModerationDecision{Key: "b", Action: DecisionReject, Reviewer: "bob"}) == nil {
+		t.Fatal("reject without a reason should be refused")
+	}
+	err = dm.Decide(ModerationDecision{Key: "a", Action: DecisionApprove, Reviewer: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dm.Decide(ModerationDecision{Key: "b", Action: DecisionRequestChanges, Reviewer: "alice", Reason: "add a price"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dm.Decide(ModerationDecision{Key: "a", Action: DecisionReject, Reviewer: "bob", Reason: "late"}) == nil {
+		t.Fatal("decided record should not be decided again")
+	}
+	dm.updateSortKeyList()
+	if list := dm.getSortKeyList(); len(list) != 2 {
+		t.Fatal("approved record should be listed", list)
+	}
+
+	// changing a record with requested changes submits it again
+	err = dm.PatchData([]Patch{{Key: "b", Set: map[string]string{"price": "10"}}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := dm.ModerationHistory("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Status != ModerationPending || len(history.History) != 4 || history.History[2].Reason != "add a price" {
+		t.Fatal("unexpected history", history)
+	}
+
+	// approved records stay listed when updated unless updates are reviewed
+	_, err = dm.InsertData([]Data{{Key: "a", Value: map[string]string{"a": "3"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := dm.getTestData(t, "a"); data.Moderation.Status != ModerationApproved {
+		t.Fatal("update should keep the approval", data.Moderation)
+	}
+	dm.SetOptions(Options{Moderation: &ModerationOptions{Reviewers: []string{"alice", "bob"}, ReviewUpdates: true}})
+	_, err = dm.InsertData([]Data{{Key: "legacy", Value: map[string]string{"a": "4"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm.updateSortKeyList()
+	if list := dm.getSortKeyList(); len(list) != 1 || list[0] != dm.prefix+"a" {
+		t.Fatal("reviewed update should leave the list", list)
+	}
+
+	dm.SetOptions(Options{})
+	dm.updateSortKeyList()
+	if list := dm.getSortKeyList(); len(list) != 3 {
+		t.Fatal("every record should be listed without moderation", list)
+	}
+}
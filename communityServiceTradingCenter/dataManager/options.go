@@ -0,0 +1,35 @@
+package dataManager
+
+type Options struct {
+	Ranking RankingOptions `json:"ranking"`
+	// Lifecycle is nil for datasets without states
+	Lifecycle *LifecycleOptions `json:"lifecycle"`
+	// References are checked on write and followed by deletes and Query.Expand
+	References []Reference `json:"references"`
+	// Schema is checked on every insert and patch, nil accepts any fields
+	Schema *Schema      `json:"schema,omitempty"`
+	Cache  CacheOptions `json:"cache"`
+	// Keys generates the keys of records inserted without one
+	Keys KeyOptions `json:"keys"`
+	// Duplicates detects records created again under a new key, nil turns it off
+	Duplicates *DuplicateOptions `json:"duplicates,omitempty"`
+	// Moderation keeps new records out of queries until a reviewer approves them, nil turns it off
+	Moderation *ModerationOptions `json:"moderation,omitempty"`
+	// ContentFilter checks record values against the word list, nil turns it off
+	ContentFilter *ContentFilterOptions `json:"contentFilter,omitempty"`
+}
+
+func (p *Manager) SetOptions(options Options) {
+	p.optionsLock.Lock()
+	defer p.optionsLock.Unlock()
+	p.options = options
+	p.bumpGeneration()
+	// moderation and ranking decide what the sort key list holds
+	p.requestSortKeyListUpdate()
+}
+
+func (p *Manager) GetOptions() Options {
+	p.optionsLock.RLock()
+	defer p.optionsLock.RUnlock()
+	return p.options
+}
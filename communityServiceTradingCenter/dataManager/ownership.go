@@ -0,0 +1,122 @@
+package dataManager
+
+import (
+	"errors"
+)
+
+// ErrForbidden matches, through errors.Is, the errors of writes refused by the ownership checks
+var ErrForbidden = errors.New("forbidden")
+
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+
+func (e forbiddenError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+// Actor is the principal a write is made for. A nil actor is the server itself and skips the ownership checks.
+type Actor struct {
+	Principal string
+	// Admin may change records of any owner
+	Admin bool
+}
+
+// mayEdit tells if actor may change or delete data. Records without an owner, written by the server or stored
+// before owners were kept, are left to admins.
+func (a *Actor) mayEdit(data Data) bool {
+	if a.mayManage(data) {
+		return true
+	}
+	if data.Owner == "" || a.Principal == "" {
+		return false
+	}
+	for _, editor := range data.Editors {
+		if editor == a.Principal {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Actor) mayManage(data Data) bool {
+	return a == nil || a.Admin || (data.Owner != "" && data.Owner == a.Principal)
+}
+
+func forbidden(key string) error {
+	return forbiddenError("not the owner or an editor of : " + key)
+}
+
+// ownedBy tells if principal owns data or is one of its editors
+func ownedBy(data Data, principal string) bool {
+	return (&Actor{Principal: principal}).mayEdit(data)
+}
+
+// prepareOwnership keeps the owner and editors of a stored record, a new record is owned by the actor.
+// Only admins may give a new record another owner or editors, what other writers and the server send is dropped.
+func prepareOwnership(old *Data, data *Data, actor *Actor) {
+	switch {
+	case old != nil:
+		data.Owner = old.Owner
+		data.Editors = old.Editors
+	case actor == nil:
+		data.Owner = ""
+		data.Editors = nil
+	case actor.Admin:
+		if data.Owner == "" {
+			data.Owner = actor.Principal
+		}
+	default:
+		data.Owner = actor.Principal
+		data.Editors = nil
+	}
+}
+
+// TransferOwnership hands keys to owner, only the current owner or an admin may do it and the editors are kept
+func (p *Manager) TransferOwnership(keys []string, owner string, actor *Actor) error {
+	if owner == "" {
+		return invalid("empty owner")
+	}
+	return p.update(func(b *writeBatch) error {
+		for _, key := range keys {
+			data, exists, err := p.loadData(b.txn, key)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return notFound("key not found : " + key)
+			}
+			if !actor.mayManage(data) {
+				return forbiddenError("not the owner of : " + key)
+			}
+			old := data
+			data.Owner = owner
+			err = p.putData(b, &old, data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetEditors replaces the principals allowed to change key besides its owner
+func (p *Manager) SetEditors(key string, editors []string, actor *Actor) error {
+	return p.update(func(b *writeBatch) error {
+		data, exists, err := p.loadData(b.txn, key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return notFound("key not found : " + key)
+		}
+		if !actor.mayManage(data) {
+			return forbiddenError("not the owner of : " + key)
+		}
+		old := data
+		data.Editors = editors
+		return p.putData(b, &old, data)
+	})
+}
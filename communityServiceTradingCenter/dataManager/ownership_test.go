@@ -0,0 +1,110 @@
+package dataManager
+
+import (
+	"testing"
+)
+
+func TestOwnership(t *testing.T) {
+	dm, stop := newTestDataManager(t, "provider.")
+	defer stop()
+
+	alice := &Actor{Principal: "user:alice"}
+	bob := &Actor{Principal: "user:bob"}
+	admin := &Actor{Principal: "user:root", Admin: true}
+
+	// owners cannot be set by the client
+	results, err := dm.InsertData([]Data{
+		{Key: "a", Value: map[string]string{"title": "cleaning"}, Owner: "user:bob"},
+		{Key: "b", Value: map[string]string{"title": "garden"}},
+	}, InsertOption{Actor: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := dm.getTestData(t, "a"); data.Owner != "user:alice" {
+		t.Fatal("creator should own the record", data.Owner)
+	}
+
+	results, _ = dm.InsertData([]Data{{Key: "a", Value: map[string]string{"title": "taken"}}}, InsertOption{Actor: bob})
+	if results[0].Status != ItemStatusForbidden {
+		t.Fatal("update by another principal should be forbidden", results)
+	}
+	if dm.PatchDataAs([]Patch{{Key: "a", Set: map[string]string{"price": "1"}}}, false, bob) == nil {
+		t.Fatal("patch by another principal should be refused")
+	}
+	if dm.DeleteDataAs([]string{"a"}, bob) == nil {
+		t.Fatal("delete by another principal should be refused")
+	}
+	if dm.SetEditors("a", []string{"user:bob"}, bob) == nil {
+		t.Fatal("only the owner may delegate")
+	}
+
+	err = dm.SetEditors("a", []string{"user:bob"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dm.PatchDataAs([]Patch{{Key: "a", Set: map[string]string{"price": "1"}}}, false, bob)
+	if err != nil {
+		t.Fatal("editor should change the record", err)
+	}
+	if dm.TransferOwnership([]string{"a"}, "user:bob", bob) == nil {
+		t.Fatal("editors should not transfer the record")
+	}
+	if data, _ := dm.getTestData(t, "a"); data.Owner != "user:alice" || data.Value["price"] != "1" {
+		t.Fatal("unexpected record", data)
+	}
+
+	err = dm.TransferOwnership([]string{"b"}, "user:bob", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dm.DeleteDataAs([]string{"b"}, alice) == nil {
+		t.Fatal("former owner should lose the record")
+	}
+
+	dm.updateSortKeyList()
+	list, _, _, err := dm.QueryData(Query{Owner: "user:bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatal("owned and edited records should be returned", list)
+	}
+	list, _, _, _ = dm.QueryData(Query{Owner: "user:alice"})
+	if len(list) != 1 || list[0].Key != "a" {
+		t.Fatal("unexpected records of alice", list)
+	}
+
+	err = dm.DeleteDataAs([]string{"a", "b"}, admin)
+	if err != nil {
+		t.Fatal("admin should delete any record", err)
+	}
+
+	// writes without an actor cannot hand records to anyone, ownerless records are left to admins
+	_, err = dm.InsertData([]Data{{Key: "legacy", Owner: "user:bob", Editors: []string{"user:bob"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := dm.getTestData(t, "legacy"); data.Owner != "" || len(data.Editors) != 0 {
+		t.Fatal("ownership sent without an actor should be dropped", data)
+	}
+	results, _ = dm.InsertData([]Data{{Key: "legacy", Value: map[string]string{"title": "claimed"}}}, InsertOption{Actor: bob})
+	if results[0].Status != ItemStatusForbidden {
+		t.Fatal("ownerless record should be left to admins", results)
+	}
+	if dm.DeleteDataAs([]string{"legacy"}, alice) == nil || dm.TransferOwnership([]string{"legacy"}, "user:alice", alice) == nil {
+		t.Fatal("ownerless record should be left to admins")
+	}
+	err = dm.TransferOwnership([]string{"legacy"}, "user:alice", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// admins may create records for others
+	_, err = dm.InsertData([]Data{{Key: "c", Owner: "user:bob"}}, InsertOption{Actor: admin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := dm.getTestData(t, "c"); data.Owner != "user:bob" {
+		t.Fatal("admin should set the owner", data)
+	}
+}
@@ -0,0 +1,123 @@
+package dataManager
+
+import "math"
+
+type Patch struct {
+	Key               string            `json:"key"`
+	Set               map[string]string `json:"set"`
+	Unset             []string          `json:"unset"`
+	PriorityIncrement int64             `json:"priorityIncrement"`
+	Location          *GeoPoint         `json:"location"`
+	// PinnedUntilMs replaces the pin time, 0 unpins the record
+	PinnedUntilMs *uint64 `json:"pinnedUntilMs"`
+	// Boost is added to the boosts already on the record
+	Boost *Boost `json:"boost"`
+}
+
+// applyPatch works on a copy of data.Value so the original record is left untouched, the priority stays within 0 and math.MaxUint64
+func applyPatch(data Data, patch Patch) Data {
+	data.Value = copyValue(data.Value)
+	if data.Value == nil {
+		data.Value = make(map[string]string)
+	}
+	for k, v := range patch.Set {
+		data.Value[k] = v
+	}
+	for _, k := range patch.Unset {
+		delete(data.Value, k)
+	}
+	if patch.Location != nil {
+		location := *patch.Location
+		data.Location = &location
+	}
+	if patch.PinnedUntilMs != nil {
+		data.PinnedUntilMs = *patch.PinnedUntilMs
+	}
+	if patch.Boost != nil {
+		data.Boosts = append(append([]Boost{}, data.Boosts...), *patch.Boost)
+	}
+	if patch.PriorityIncrement < 0 {
+		dec := uint64(-patch.PriorityIncrement)
+		if dec > data.Priority {
+			data.Priority = 0
+		} else {
+			data.Priority -= dec
+		}
+	} else {
+		inc := uint64(patch.PriorityIncrement)
+		if inc > math.MaxUint64-data.Priority {
+			data.Priority = math.MaxUint64
+		} else {
+			data.Priority += inc
+		}
+	}
+	return data
+}
+
+// PatchData applies all patches inside one transaction, a missing key aborts the whole batch unless upsert is set
+func (p *Manager) PatchData(list []Patch, upsert bool) error {
+	return p.PatchDataAs(list, upsert, nil)
+}
+
+// PatchDataAs applies list when actor may change every patched record, records created by upsert are owned by actor
+func (p *Manager) PatchDataAs(list []Patch, upsert bool, actor *Actor) error {
+	for _, patch := range list {
+		if patch.Key == "" {
+			return invalid("contains empty key")
+		}
+		if patch.Location != nil && !patch.Location.Valid() {
+			return invalid("location out of range : " + patch.Key)
+		}
+	}
+	return p.update(func(b *writeBatch) error {
+		for _, patch := range list {
+			data, exists, err := p.loadData(b.txn, patch.Key)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				if !upsert {
+					return notFound("key not found : " + patch.Key)
+				}
+				data = Data{
+					Key:   patch.Key,
+					Value: make(map[string]string),
+				}
+			}
+			var old *Data
+			if exists {
+				if !actor.mayEdit(data) {
+					return forbidden(patch.Key)
+				}
+				stored := data
+				old = &stored
+			}
+			data = applyPatch(data, patch)
+			err = p.validateData(data)
+			if err != nil {
+				return invalid(err.Error() + " : " + patch.Key)
+			}
+			_, held, err := p.filterContent(&data)
+			if err != nil {
+				return invalid(err.Error() + " : " + patch.Key)
+			}
+			err = p.checkReferences(b.txn, data)
+			if err != nil {
+				return err
+			}
+			if lifecycle := p.GetOptions().Lifecycle; lifecycle != nil && old == nil {
+				err = lifecycle.prepareState(nil, &data, nowMs())
+				if err != nil {
+					return err
+				}
+			}
+			prepareOwnership(old, &data, actor)
+			p.prepareModeration(old, &data, nowMs(), held)
+			err = p.putData(b, old, data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
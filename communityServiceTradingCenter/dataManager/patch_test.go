@@ -0,0 +1,79 @@
+package dataManager
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPatchData(t *testing.T) {
+	dm, stop := newTestDataManager(t, "patch.")
+	defer stop()
+
+	_, err := dm.InsertData([]Data{
+		{
+			Key: "key1",
+			Value: map[string]string{
+				"theme":    "t1",
+				"deadline": "d1",
+			},
+			Priority: 5,
+		},
+	}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dm.PatchData([]Patch{
+		{
+			Key:               "key1",
+			Set:               map[string]string{"theme": "t2"},
+			Unset:             []string{"deadline"},
+			PriorityIncrement: -10,
+		},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := dm.getTestData(t, "key1")
+	if data.Value["theme"] != "t2" || len(data.Value) != 1 || data.Priority != 0 {
+		t.Fatal("unexpected patch result", data)
+	}
+
+	err = dm.PatchData([]Patch{
+		{Key: "key1", PriorityIncrement: 3},
+		{Key: "missing", Set: map[string]string{"theme": "t3"}},
+	}, false)
+	if err == nil {
+		t.Fatal("patch on missing key should fail")
+	}
+	data, _ = dm.getTestData(t, "key1")
+	if data.Priority != 0 {
+		t.Fatal("failed batch should not be applied", data)
+	}
+
+	err = dm.PatchData([]Patch{
+		{Key: "missing", Set: map[string]string{"theme": "t3"}, PriorityIncrement: 7},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, exists := dm.getTestData(t, "missing")
+	if !exists || data.Value["theme"] != "t3" || data.Priority != 7 {
+		t.Fatal("unexpected upsert result", data)
+	}
+}
+
+func TestPatchPriorityBounds(t *testing.T) {
+	data := applyPatch(Data{Priority: math.MaxUint64 - 1}, Patch{PriorityIncrement: math.MaxInt64})
+	if data.Priority != math.MaxUint64 {
+		t.Fatal("increment should saturate instead of wrapping", data.Priority)
+	}
+	data = applyPatch(Data{Priority: 1}, Patch{PriorityIncrement: math.MinInt64})
+	if data.Priority != 0 {
+		t.Fatal("decrement should stop at 0", data.Priority)
+	}
+	data = applyPatch(Data{Priority: 1}, Patch{PriorityIncrement: 2})
+	if data.Priority != 3 {
+		t.Fatal("unexpected priority", data.Priority)
+	}
+}
@@ -0,0 +1,330 @@
+package dataManager
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"go.uber.org/zap"
+	"regexp"
+	"sort"
+)
+
+type Query struct {
+	Limit      int                 `json:"limit"`
+	Page       int                 `json:"page"`
+	MatchRules []map[string]string `json:"matchRules"`
+	// Fields lists the Value fields to return, all fields are returned when empty
+	Fields []string `json:"fields"`
+	// ExcludeFields is applied after Fields
+	ExcludeFields []string `json:"excludeFields"`
+	// PreviewLength truncates long values to this many characters, 0 keeps them whole
+	PreviewLength int `json:"previewLength"`
+	// PreviewFields limits truncation to these fields, every field is truncated when empty
+	PreviewFields []string   `json:"previewFields"`
+	Near          *GeoRadius `json:"near"`
+	Within        *GeoBox    `json:"within"`
+	// SortByDistance orders results by distance to Near instead of priority
+	SortByDistance bool `json:"sortByDistance"`
+	// States filters by lifecycle state, the visible states of the dataset are used when empty and "*" returns all
+	States []string `json:"states"`
+	// Expand lists reference fields whose records are embedded in the results
+	Expand []string `json:"expand"`
+	// NoCache computes the result even when a cached one is valid
+	NoCache bool `json:"noCache"`
+	// Owner limits the results to the records this principal owns or edits
+	Owner string `json:"owner"`
+}
+
+const previewEllipsis = "…"
+
+type projection struct {
+	include       map[string]struct{}
+	exclude       map[string]struct{}
+	previewLength int
+	preview       map[string]struct{}
+}
+
+func toSet(list []string) map[string]struct{} {
+	if len(list) == 0 {
+		return nil
+	}
+	res := make(map[string]struct{}, len(list))
+	for _, v := range list {
+		res[v] = struct{}{}
+	}
+	return res
+}
+
+func newProjection(query Query) projection {
+	return projection{
+		include:       toSet(query.Fields),
+		exclude:       toSet(query.ExcludeFields),
+		previewLength: query.PreviewLength,
+		preview:       toSet(query.PreviewFields),
+	}
+}
+
+func (p projection) apply(data Data) Data {
+	if p.include == nil && p.exclude == nil && p.previewLength <= 0 {
+		return data
+	}
+	value := make(map[string]string, len(data.Value))
+	for k, v := range data.Value {
+		if p.include != nil {
+			if _, ok := p.include[k]; !ok {
+				continue
+			}
+		}
+		if _, ok := p.exclude[k]; ok {
+			continue
+		}
+		if p.previewLength > 0 {
+			_, ok := p.preview[k]
+			if p.preview == nil || ok {
+				v = truncate(v, p.previewLength)
+			}
+		}
+		value[k] = v
+	}
+	data.Value = value
+	return data
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	runes := 0
+	for i := range s {
+		if runes == length {
+			return s[:i] + previewEllipsis
+		}
+		runes += 1
+	}
+	return s
+}
+
+// Record is a query result, Data is embedded so its fields stay at the top level of the json
+type Record struct {
+	Data
+	DistanceKm *float64 `json:"distanceKm,omitempty"`
+	// Score is the effective priority at query time
+	Score  float64 `json:"score"`
+	Pinned bool    `json:"pinned"`
+	// Expanded holds the referenced records keyed by reference field
+	Expanded map[string]*Data `json:"expanded,omitempty"`
+	// ETag is the ETag of the stored record, only GetRecord sets it since the projection changes Value
+	ETag string `json:"-"`
+}
+
+type compiledRule map[string]*regexp.Regexp
+
+func compileMatchRules(matchRules []map[string]string) ([]compiledRule, error) {
+	res := make([]compiledRule, 0, len(matchRules))
+	for _, matchRule := range matchRules {
+		rule := make(compiledRule, len(matchRule))
+		for k, v := range matchRule {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return nil, err
+			}
+			rule[k] = re
+		}
+		res = append(res, rule)
+	}
+	return res, nil
+}
+
+// matchData reports whether data satisfies any rule, every field of a rule has to match and empty rules never match
+func matchData(rules []compiledRule, data Data) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	for _, rule := range rules {
+		if len(rule) == 0 {
+			continue
+		}
+		currentRuleMatch := true
+		for k, re := range rule {
+			fieldVal, ok := data.Value[k]
+			if !ok || !re.MatchString(fieldVal) {
+				currentRuleMatch = false
+				break
+			}
+		}
+		if currentRuleMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchData reports whether data satisfies matchRules the same way QueryData does
+func MatchData(matchRules []map[string]string, data Data) (bool, error) {
+	rules, err := compileMatchRules(matchRules)
+	if err != nil {
+		return false, err
+	}
+	return matchData(rules, data), nil
+}
+
+// Visible tells if a query without states would list data, that is if it is listed and in a visible state
+func (p *Manager) Visible(data Data) bool {
+	options := p.GetOptions()
+	return p.listed(data) && newStateFilter(options.Lifecycle, nil).match(options.Lifecycle, data)
+}
+
+// GetRecord returns key the way QueryData would list it, with the states, owner, projection and expansion of query.
+// exists is false when there is no such record or the query would leave it out.
+func (p *Manager) GetRecord(key string, query Query) (res Record, exists bool, err error) {
+	projection := newProjection(query)
+	for _, field := range query.Expand {
+		if _, ok := p.reference(field); !ok {
+			return Record{}, false, invalid("field is not a reference : " + field)
+		}
+	}
+
+	options := p.GetOptions()
+	states := newStateFilter(options.Lifecycle, query.States)
+	now := nowMs()
+	err = p.dbManager.ViewData(func(txn *badger.Txn) error {
+		data, found, err := p.loadData(txn, key)
+		if err != nil || !found {
+			return err
+		}
+		if !p.listed(data) || !states.match(options.Lifecycle, data) {
+			return nil
+		}
+		if query.Owner != "" && !ownedBy(data, query.Owner) {
+			return nil
+		}
+		res = Record{
+			Data:   data,
+			Score:  options.Ranking.score(data, now),
+			Pinned: data.pinned(now),
+			ETag:   data.ETag(),
+		}
+		if len(query.Expand) > 0 {
+			res.Expanded, err = p.expand(txn, data, query.Expand)
+			if err != nil {
+				return err
+			}
+		}
+		exists = true
+		return nil
+	})
+	if err != nil || !exists {
+		return Record{}, false, err
+	}
+	res.Data = projection.apply(res.Data)
+	return res, true, nil
+}
+
+func (p *Manager) queryData(query Query) (res []Record, count int, totalCount int, err error) {
+	projection := newProjection(query)
+	rules, err := compileMatchRules(query.MatchRules)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	geo, err := newGeoFilter(query)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	for _, field := range query.Expand {
+		if _, ok := p.reference(field); !ok {
+			return nil, 0, 0, invalid("field is not a reference : " + field)
+		}
+	}
+
+	options := p.GetOptions()
+	ranking := options.Ranking
+	states := newStateFilter(options.Lifecycle, query.States)
+	now := nowMs()
+	matched := make([]Record, 0)
+	err = p.dbManager.ViewData(func(txn *badger.Txn) error {
+		var candidates map[string]struct{}
+		if geo != nil {
+			candidates = geo.candidates(p, txn)
+		}
+		kList := p.getSortKeyList()
+		p.logger.Info("", zap.Any("kList", kList))
+		for _, dbKey := range kList {
+			key := dbKey[len(p.prefix):]
+			if candidates != nil {
+				if _, ok := candidates[key]; !ok {
+					continue
+				}
+			}
+			data, exists, err := p.loadData(txn, key)
+			if err != nil {
+				return err
+			}
+			if !exists || !states.match(options.Lifecycle, data) || !matchData(rules, data) {
+				continue
+			}
+			if query.Owner != "" && !ownedBy(data, query.Owner) {
+				continue
+			}
+			record := Record{
+				Data:   data,
+				Score:  ranking.score(data, now),
+				Pinned: data.pinned(now),
+			}
+			if geo != nil {
+				ok, distance := geo.match(data)
+				if !ok {
+					continue
+				}
+				record.DistanceKm = distance
+			}
+			matched = append(matched, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if query.SortByDistance {
+		sort.SliceStable(matched, func(i, j int) bool {
+			return *matched[i].DistanceKm < *matched[j].DistanceKm
+		})
+	} else {
+		sort.SliceStable(matched, func(i, j int) bool {
+			return rankBefore(matched[i], matched[j])
+		})
+	}
+
+	totalCount = len(matched)
+	if query.Limit > 0 && query.Page > 0 {
+		skip := query.Limit * (query.Page - 1)
+		if skip > len(matched) {
+			skip = len(matched)
+		}
+		end := skip + query.Limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		matched = matched[skip:end]
+	}
+	if len(query.Expand) > 0 {
+		err = p.dbManager.ViewData(func(txn *badger.Txn) error {
+			for i := range matched {
+				expanded, err := p.expand(txn, matched[i].Data, query.Expand)
+				if err != nil {
+					return err
+				}
+				matched[i].Expanded = expanded
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	res = make([]Record, 0, len(matched))
+	for _, record := range matched {
+		record.Data = projection.apply(record.Data)
+		res = append(res, record)
+	}
+
+	return res, len(res), totalCount, nil
+}
@@ -0,0 +1,88 @@
+package dataManager
+
+import (
+	"testing"
+)
+
+func TestQueryProjection(t *testing.T) {
+	dm, stop := newTestDataManager(t, "query.")
+	defer stop()
+
+	_, err := dm.InsertData([]Data{
+		{
+			Key: "key1",
+			Value: map[string]string{
+				"theme":    "社区义诊",
+				"deadline": "2026-01-01",
+				"content":  "每周六上午在社区活动中心提供免费血压测量",
+			},
+			Priority: 2,
+		},
+		{
+			Key: "key2",
+			Value: map[string]string{
+				"theme":   "short",
+				"content": "short",
+			},
+			Priority: 1,
+		},
+	}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm.updateSortKeyList()
+
+	res, _, _, err := dm.QueryData(Query{
+		Fields:        []string{"theme", "content"},
+		ExcludeFields: []string{"theme"},
+		PreviewLength: 6,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || len(res[0].Value) != 1 || res[0].Value["content"] != "每周六上午在…" || res[1].Value["content"] != "short" {
+		t.Fatal("unexpected projection", res)
+	}
+
+	res, _, _, err = dm.QueryData(Query{
+		MatchRules:    []map[string]string{{"theme": "义诊"}},
+		PreviewLength: 2,
+		PreviewFields: []string{"theme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Value["theme"] != "社区…" || res[0].Value["deadline"] != "2026-01-01" {
+		t.Fatal("unexpected projection", res)
+	}
+}
+
+func TestGetRecord(t *testing.T) {
+	dm, stop := newTestDataManager(t, "record.")
+	defer stop()
+
+	dm.SetOptions(Options{Lifecycle: DefaultLifecycle("deadline")})
+	_, err := dm.InsertData([]Data{
+		{Key: "draft", Value: map[string]string{"theme": "draft", "content": "hidden"}},
+	}, InsertOption{Actor: &Actor{Principal: "user:alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exists, err := dm.GetRecord("draft", Query{}); err != nil || exists {
+		t.Fatal("states hidden from queries should be hidden from gets", exists, err)
+	}
+	if _, exists, _ := dm.GetRecord("missing", Query{States: []string{AllStates}}); exists {
+		t.Fatal("missing record should not exist")
+	}
+	record, exists, err := dm.GetRecord("draft", Query{States: []string{AllStates}, Fields: []string{"theme"}})
+	if err != nil || !exists {
+		t.Fatal("record should be returned for every state", err)
+	}
+	if len(record.Value) != 1 || record.Value["theme"] != "draft" {
+		t.Fatal("projection should apply", record)
+	}
+	if _, exists, _ = dm.GetRecord("draft", Query{States: []string{AllStates}, Owner: "user:bob"}); exists {
+		t.Fatal("owner filter should apply")
+	}
+}
@@ -0,0 +1,64 @@
+package dataManager
+
+import (
+	"math"
+	"time"
+)
+
+type Boost struct {
+	Amount   uint64 `json:"amount"`
+	ExpireMs uint64 `json:"expireMs"`
+}
+
+type RankingOptions struct {
+	// DecayHalfLifeHours halves the effective priority every so many hours of age, 0 disables decay
+	DecayHalfLifeHours float64 `json:"decayHalfLifeHours"`
+	// DecayFromUpdate measures age from the last update instead of from creation
+	DecayFromUpdate bool `json:"decayFromUpdate"`
+}
+
+var nowMs = func() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+func (data Data) pinned(now uint64) bool {
+	return data.PinnedUntilMs > now
+}
+
+func activeBoosts(boosts []Boost, now uint64) []Boost {
+	var res []Boost
+	for _, boost := range boosts {
+		if boost.ExpireMs > now {
+			res = append(res, boost)
+		}
+	}
+	return res
+}
+
+// score is the priority plus active boosts, decayed by the age of the record
+func (o RankingOptions) score(data Data, now uint64) float64 {
+	score := float64(data.Priority)
+	for _, boost := range activeBoosts(data.Boosts, now) {
+		score += float64(boost.Amount)
+	}
+	if o.DecayHalfLifeHours <= 0 {
+		return score
+	}
+	since := data.CreateTimeMs
+	if o.DecayFromUpdate {
+		since = data.UpdateTimeMs
+	}
+	if since == 0 || since >= now {
+		return score
+	}
+	ageHours := float64(now-since) / float64(time.Hour/time.Millisecond)
+	return score * math.Pow(0.5, ageHours/o.DecayHalfLifeHours)
+}
+
+// rankBefore orders pinned records first and then by descending score
+func rankBefore(a Record, b Record) bool {
+	if a.Pinned != b.Pinned {
+		return a.Pinned
+	}
+	return a.Score > b.Score
+}
@@ -0,0 +1,70 @@
+package dataManager
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRanking(t *testing.T) {
+	dm, stop := newTestDataManager(t, "ranking.")
+	defer stop()
+	dm.SetOptions(Options{
+		Ranking: RankingOptions{
+			DecayHalfLifeHours: 24,
+		},
+	})
+
+	const hourMs = 3600 * 1000
+	now := uint64(1000 * hourMs)
+	defer func(f func() uint64) {
+		nowMs = f
+	}(nowMs)
+
+	insertAt := func(at uint64, data Data) {
+		nowMs = func() uint64 {
+			return at
+		}
+		_, err := dm.InsertData([]Data{data}, InsertOption{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	insertAt(now-48*hourMs, Data{Key: "old", Priority: 100})
+	insertAt(now, Data{Key: "fresh", Priority: 30})
+	insertAt(now, Data{Key: "boosted", Priority: 10, Boosts: []Boost{{Amount: 40, ExpireMs: now + hourMs}}})
+	insertAt(now-72*hourMs, Data{Key: "pinned", Priority: 1, PinnedUntilMs: now + hourMs})
+	insertAt(now, Data{Key: "expired", Priority: 5, Boosts: []Boost{{Amount: 1000, ExpireMs: now - 1}}})
+	dm.updateSortKeyList()
+
+	nowMs = func() uint64 {
+		return now
+	}
+	res, _, _, err := dm.QueryData(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make([]string, 0)
+	for _, r := range res {
+		order = append(order, r.Key)
+	}
+	expected := []string{"pinned", "boosted", "fresh", "old", "expired"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatal("unexpected order", order)
+		}
+	}
+	if math.Abs(res[3].Score-25) > 0.001 || !res[0].Pinned || len(res[4].Boosts) != 0 {
+		t.Fatal("unexpected scores", res)
+	}
+
+	nowMs = func() uint64 {
+		return now + 2*hourMs
+	}
+	res, _, _, err = dm.QueryData(Query{Limit: 1, Page: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Key != "fresh" {
+		t.Fatal("pin and boost should expire", res)
+	}
+}
@@ -0,0 +1,202 @@
+package dataManager
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"strings"
+)
+
+const (
+	OnDeleteRestrict = "restrict"
+	OnDeleteCascade  = "cascade"
+	OnDeleteNullify  = "nullify"
+
+	refIndexPrefix = "_ref."
+	refSeparator   = "\x00"
+)
+
+// Reference declares that Value[Field] holds the key of a record in Dataset
+type Reference struct {
+	Field   string `json:"field"`
+	Dataset string `json:"dataset"`
+	// OnDelete is what happens to this record when the referenced one is deleted, restrict when empty
+	OnDelete string `json:"onDelete"`
+}
+
+func (p *Manager) reference(field string) (Reference, bool) {
+	for _, ref := range p.GetOptions().References {
+		if ref.Field == field {
+			return ref, true
+		}
+	}
+	return Reference{}, false
+}
+
+// ReferencedDataset is the dataset the reference field points to
+func (p *Manager) ReferencedDataset(field string) (string, bool) {
+	ref, ok := p.reference(field)
+	return ref.Dataset, ok
+}
+
+func (p *Manager) referenceTarget(ref Reference) (*Manager, error) {
+	if p.directory == nil {
+		return nil, invalid("dataset has no directory for references")
+	}
+	target, ok := p.directory.Get(ref.Dataset)
+	if !ok {
+		return nil, invalid("unknown referenced dataset : " + ref.Dataset)
+	}
+	return target, nil
+}
+
+// checkReferences makes sure every reference of data points to an existing record
+func (p *Manager) checkReferences(txn *badger.Txn, data Data) error {
+	for _, ref := range p.GetOptions().References {
+		targetKey := data.Value[ref.Field]
+		if targetKey == "" {
+			continue
+		}
+		target, err := p.referenceTarget(ref)
+		if err != nil {
+			return err
+		}
+		_, exists, err := target.loadData(txn, targetKey)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return invalid("reference " + ref.Field + " not found : " + ref.Dataset + "/" + targetKey)
+		}
+	}
+	return nil
+}
+
+// refIndexKey links the referenced record to the referencing one so deletes can find who points at them
+func (p *Manager) refIndexKey(target *Manager, targetKey string, field string, key string) []byte {
+	return []byte(refIndexPrefix + target.prefix + targetKey + refSeparator + p.prefix + refSeparator + field + refSeparator + key)
+}
+
+func (p *Manager) updateRefIndex(txn *badger.Txn, data Data, remove bool) error {
+	for _, ref := range p.GetOptions().References {
+		targetKey := data.Value[ref.Field]
+		if targetKey == "" {
+			continue
+		}
+		target, err := p.referenceTarget(ref)
+		if err != nil {
+			if remove {
+				continue
+			}
+			return err
+		}
+		indexKey := p.refIndexKey(target, targetKey, ref.Field, data.Key)
+		if remove {
+			err = txn.Delete(indexKey)
+		} else {
+			err = txn.Set(indexKey, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type referrer struct {
+	manager *Manager
+	field   string
+	key     string
+}
+
+func (p *Manager) referrers(txn *badger.Txn, key string) ([]referrer, error) {
+	res := make([]referrer, 0)
+	if p.directory == nil {
+		return res, nil
+	}
+	indexPrefix := refIndexPrefix + p.prefix + key + refSeparator
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = []byte(indexPrefix)
+	iter := txn.NewIterator(opt)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		parts := strings.SplitN(string(iter.Item().Key()[len(indexPrefix):]), refSeparator, 3)
+		if len(parts) != 3 {
+			continue
+		}
+		source, ok := p.directory.byPrefix(parts[0])
+		if !ok {
+			continue
+		}
+		res = append(res, referrer{
+			manager: source,
+			field:   parts[1],
+			key:     parts[2],
+		})
+	}
+	return res, nil
+}
+
+// releaseReferrers applies the OnDelete behavior of every record pointing at the deleted key
+func (p *Manager) releaseReferrers(b *writeBatch, key string) error {
+	list, err := p.referrers(b.txn, key)
+	if err != nil {
+		return err
+	}
+	for _, r := range list {
+		data, exists, err := r.manager.loadData(b.txn, r.key)
+		if err != nil {
+			return err
+		}
+		if !exists || data.Value[r.field] != key {
+			continue
+		}
+		ref, _ := r.manager.reference(r.field)
+		switch ref.OnDelete {
+		case OnDeleteCascade:
+			err = r.manager.removeData(b, data)
+		case OnDeleteNullify:
+			old := data
+			data.Value = copyValue(data.Value)
+			delete(data.Value, r.field)
+			err = r.manager.putData(b, &old, data)
+		default:
+			err = conflict("record is referenced by " + r.manager.name + "/" + r.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expand loads the records referenced by the given fields of data, leaving out those queries of their dataset would not
+// list. Callers check that the caller may query the referenced datasets.
+func (p *Manager) expand(txn *badger.Txn, data Data, fields []string) (map[string]*Data, error) {
+	var res map[string]*Data
+	for _, field := range fields {
+		targetKey := data.Value[field]
+		if targetKey == "" {
+			continue
+		}
+		ref, ok := p.reference(field)
+		if !ok {
+			return nil, invalid("field is not a reference : " + field)
+		}
+		target, err := p.referenceTarget(ref)
+		if err != nil {
+			return nil, err
+		}
+		targetData, exists, err := target.loadData(txn, targetKey)
+		if err != nil {
+			return nil, err
+		}
+		if !exists || !target.Visible(targetData) {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]*Data)
+		}
+		res[field] = &targetData
+	}
+	return res, nil
+}
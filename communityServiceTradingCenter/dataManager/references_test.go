@@ -0,0 +1,135 @@
+package dataManager
+
+import (
+	"go.uber.org/zap/zapcore"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger/console"
+	"testing"
+)
+
+func TestReferences(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	directory := NewDirectory()
+	newManager := func(name string, options Options) *Manager {
+		dm := NewDataManager(l, name+".", m)
+		dm.SetOptions(options)
+		directory.Add(name, dm)
+		go dm.Start()
+		return dm
+	}
+	provider := newManager("provider", Options{})
+	defer provider.Stop()
+	publisher := newManager("publisher", Options{})
+	defer publisher.Stop()
+	recommender := newManager("recommender", Options{
+		References: []Reference{
+			{Field: "providerKey", Dataset: "provider", OnDelete: OnDeleteCascade},
+			{Field: "publisherKey", Dataset: "publisher", OnDelete: OnDeleteNullify},
+		},
+	})
+	defer recommender.Stop()
+	review := newManager("review", Options{
+		References: []Reference{
+			{Field: "recommendKey", Dataset: "recommender"},
+		},
+	})
+	defer review.Stop()
+
+	_, err := provider.InsertData([]Data{{Key: "pv1"}, {Key: "pv2"}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = publisher.InsertData([]Data{{Key: "pb1"}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := recommender.InsertData([]Data{
+		{Key: "r1", Value: map[string]string{"providerKey": "pv1", "publisherKey": "pb1"}},
+		{Key: "r2", Value: map[string]string{"providerKey": "pv2", "publisherKey": "pb1"}},
+		{Key: "r3", Value: map[string]string{"providerKey": "missing"}},
+	}, InsertOption{BestEffort: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[2].Status != ItemStatusInvalid {
+		t.Fatal("dangling reference should be rejected", results)
+	}
+	err = recommender.PatchData([]Patch{{Key: "r1", Set: map[string]string{"publisherKey": "missing"}}}, false)
+	if err == nil {
+		t.Fatal("patch to a dangling reference should fail")
+	}
+
+	recommender.updateSortKeyList()
+	res, _, _, err := recommender.QueryData(Query{Expand: []string{"providerKey", "publisherKey"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Expanded["providerKey"] == nil || res[0].Expanded["publisherKey"].Key != "pb1" {
+		t.Fatal("unexpected expanded result", res)
+	}
+	_, _, _, err = recommender.QueryData(Query{Expand: []string{"other"}})
+	if err == nil {
+		t.Fatal("expanding a plain field should fail")
+	}
+
+	// a referenced record the queries of its dataset leave out is not embedded either
+	publisher.SetOptions(Options{Lifecycle: DefaultLifecycle("")})
+	_, err = publisher.InsertData([]Data{{Key: "pb2"}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recommender.InsertData([]Data{{Key: "r4", Value: map[string]string{"providerKey": "pv2", "publisherKey": "pb2"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _, err := recommender.GetRecord("r4", Query{Expand: []string{"providerKey", "publisherKey"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Expanded["providerKey"] == nil || record.Expanded["publisherKey"] != nil {
+		t.Fatal("draft should not be expanded", record.Expanded)
+	}
+	err = recommender.DeleteData([]string{"r4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = review.InsertData([]Data{{Key: "rv1", Value: map[string]string{"recommendKey": "r1"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pv1 cascades to r1 which is restricted by rv1, so nothing is deleted
+	err = provider.DeleteData([]string{"pv1"})
+	if err == nil {
+		t.Fatal("restricted delete should fail")
+	}
+	if _, exists := provider.getTestData(t, "pv1"); !exists {
+		t.Fatal("failed delete should be rolled back")
+	}
+
+	err = review.DeleteData([]string{"rv1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.DeleteData([]string{"pv1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := recommender.getTestData(t, "r1"); exists {
+		t.Fatal("delete should cascade")
+	}
+
+	err = publisher.DeleteData([]string{"pb1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, exists := recommender.getTestData(t, "r2")
+	if !exists || data.Value["publisherKey"] != "" || data.Value["providerKey"] != "pv2" {
+		t.Fatal("delete should nullify", data)
+	}
+}
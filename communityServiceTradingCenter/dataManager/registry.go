@@ -0,0 +1,357 @@
+package dataManager
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/dgraph-io/badger/v3"
+	"go.uber.org/zap"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	registryPrefix = "_dataset."
+	dropBatchSize  = 1000
+)
+
+var datasetNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,63}$`)
+
+// Dataset is the stored definition of one dataset, its schema lives in Options.Schema
+type Dataset struct {
+	Name string `json:"name"`
+	// Prefix of the record keys, name followed by a dot when empty
+	Prefix  string  `json:"prefix"`
+	Options Options `json:"options"`
+	// Config is set for datasets defined in the config file, they cannot be dropped through the registry
+	Config       bool   `json:"config"`
+	CreateTimeMs uint64 `json:"createTimeMs"`
+}
+
+type DatasetInfo struct {
+	Dataset
+	Records int `json:"records"`
+}
+
+type OpenHook func(name string, m *Manager)
+
+func init() {
+	gob.Register(Dataset{})
+}
+
+// Registry keeps the dataset definitions in badger and runs one Manager per dataset
+type Registry struct {
+	logger    logger.ILogger
+	dbManager *badgerManager.Manager
+	directory *Directory
+	lock      sync.Mutex
+	datasets  map[string]Dataset
+	openHooks []OpenHook
+	filter    *WordFilter
+}
+
+func NewRegistry(l logger.ILogger, dbManager *badgerManager.Manager) *Registry {
+	return &Registry{
+		logger:    l,
+		dbManager: dbManager,
+		directory: NewDirectory(),
+		lock:      sync.Mutex{},
+		datasets:  make(map[string]Dataset),
+		openHooks: make([]OpenHook, 0),
+	}
+}
+
+func (r *Registry) Directory() *Directory {
+	return r.directory
+}
+
+// OnOpen runs f for every dataset opened from now on, call it before Load
+func (r *Registry) OnOpen(f OpenHook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.openHooks = append(r.openHooks, f)
+}
+
+// SetWordFilter hands f to every dataset opened from now on, call it before Load
+func (r *Registry) SetWordFilter(f *WordFilter) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.filter = f
+}
+
+func (r *Registry) Get(name string) (*Manager, bool) {
+	return r.directory.Get(name)
+}
+
+func serializeDataset(d Dataset) []byte {
+	tmp := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(tmp).Encode(d)
+	if err != nil {
+		panic(err)
+	}
+	return tmp.Bytes()
+}
+
+// Load opens every stored dataset
+func (r *Registry) Load() error {
+	list := make([]Dataset, 0)
+	var innerErr error
+	err := r.dbManager.IterateData(func(key []byte, value []byte) {
+		var d Dataset
+		if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&d); err != nil {
+			innerErr = err
+			return
+		}
+		list = append(list, d)
+	}, []byte(registryPrefix))
+	if err != nil {
+		return err
+	}
+	if innerErr != nil {
+		return innerErr
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, d := range list {
+		if _, ok := r.datasets[d.Name]; ok {
+			continue
+		}
+		// Ensure marks the datasets still present in the config again
+		d.Config = false
+		r.open(d)
+	}
+	return nil
+}
+
+func (r *Registry) open(d Dataset) {
+	m := NewDataManager(r.logger, d.Prefix, r.dbManager)
+	m.SetOptions(d.Options)
+	m.SetWordFilter(r.filter)
+	r.directory.Add(d.Name, m)
+	r.datasets[d.Name] = d
+	go m.Start()
+	for _, f := range r.openHooks {
+		f(d.Name, m)
+	}
+}
+
+// check validates d against the other datasets, references may also point to the names in pending.
+// The lock must be held.
+func (r *Registry) check(d Dataset, pending map[string]struct{}) error {
+	if !datasetNamePattern.MatchString(d.Name) {
+		return invalid("invalid dataset name : " + d.Name)
+	}
+	if d.Prefix == "" || strings.HasPrefix(d.Prefix, "_") {
+		return invalid("invalid prefix, it must not be empty or start with _ : " + d.Prefix)
+	}
+	for _, other := range r.datasets {
+		if other.Name == d.Name {
+			continue
+		}
+		if strings.HasPrefix(d.Prefix, other.Prefix) || strings.HasPrefix(other.Prefix, d.Prefix) {
+			return conflict("prefix overlaps dataset " + other.Name + " : " + d.Prefix)
+		}
+	}
+	for _, ref := range d.Options.References {
+		_, known := r.datasets[ref.Dataset]
+		_, isPending := pending[ref.Dataset]
+		if !known && !isPending && ref.Dataset != d.Name {
+			return invalid("unknown referenced dataset : " + ref.Dataset)
+		}
+	}
+	err := d.Options.Keys.Check()
+	if err != nil {
+		return err
+	}
+	if d.Options.Duplicates != nil {
+		err = d.Options.Duplicates.Check()
+		if err != nil {
+			return err
+		}
+	}
+	if d.Options.ContentFilter != nil {
+		err = d.Options.ContentFilter.Check(d.Options)
+		if err != nil {
+			return err
+		}
+	}
+	if d.Options.Schema != nil {
+		err := d.Options.Schema.Check()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) save(d Dataset) error {
+	return r.dbManager.UpdateData(func(txn *badger.Txn) error {
+		return txn.Set([]byte(registryPrefix+d.Name), serializeDataset(d))
+	})
+}
+
+func (r *Registry) Create(d Dataset) (Dataset, error) {
+	if d.Prefix == "" {
+		d.Prefix = d.Name + "."
+	}
+	d.Config = false
+	d.CreateTimeMs = nowMs()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.datasets[d.Name]; ok {
+		return Dataset{}, conflict("dataset already exists : " + d.Name)
+	}
+	err := r.check(d, nil)
+	if err != nil {
+		return Dataset{}, err
+	}
+	err = r.save(d)
+	if err != nil {
+		return Dataset{}, err
+	}
+	r.open(d)
+	return d, nil
+}
+
+// Ensure creates the datasets defined in the config or updates their options, config wins over stored options
+func (r *Registry) Ensure(list []Dataset) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pending := make(map[string]struct{}, len(list))
+	for _, d := range list {
+		pending[d.Name] = struct{}{}
+	}
+	for _, d := range list {
+		if d.Prefix == "" {
+			d.Prefix = d.Name + "."
+		}
+		d.Config = true
+		stored, exists := r.datasets[d.Name]
+		if exists {
+			if stored.Prefix != d.Prefix {
+				return conflict("prefix of an existing dataset cannot change : " + d.Name)
+			}
+			d.CreateTimeMs = stored.CreateTimeMs
+		} else {
+			d.CreateTimeMs = nowMs()
+		}
+		err := r.check(d, pending)
+		if err != nil {
+			return err
+		}
+		err = r.save(d)
+		if err != nil {
+			return err
+		}
+		if exists {
+			m, _ := r.directory.Get(d.Name)
+			m.SetOptions(d.Options)
+			r.datasets[d.Name] = d
+			continue
+		}
+		r.open(d)
+	}
+	return nil
+}
+
+func (r *Registry) List() []Dataset {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	res := make([]Dataset, 0, len(r.datasets))
+	for _, d := range r.datasets {
+		res = append(res, d)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+func (r *Registry) Describe(name string) (DatasetInfo, error) {
+	r.lock.Lock()
+	d, ok := r.datasets[name]
+	r.lock.Unlock()
+	if !ok {
+		return DatasetInfo{}, notFound("unknown dataset : " + name)
+	}
+	m, _ := r.directory.Get(name)
+	info := DatasetInfo{Dataset: d}
+	err := m.iterateDataset(func(data Data) error {
+		info.Records += 1
+		return nil
+	})
+	return info, err
+}
+
+// Drop deletes every record of the dataset and forgets it, datasets still referenced by others are kept
+func (r *Registry) Drop(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	d, ok := r.datasets[name]
+	if !ok {
+		return notFound("unknown dataset : " + name)
+	}
+	if d.Config {
+		return conflict("dataset is defined in the config file : " + name)
+	}
+	for _, other := range r.datasets {
+		if other.Name == name {
+			continue
+		}
+		for _, ref := range other.Options.References {
+			if ref.Dataset == name {
+				return conflict("dataset is referenced by " + other.Name)
+			}
+		}
+	}
+
+	m, _ := r.directory.Get(name)
+	// records go through DeleteData so indexes and references are cleaned like a normal delete
+	for {
+		keys := make([]string, 0, dropBatchSize)
+		err := m.iterateDataset(func(data Data) error {
+			if len(keys) < dropBatchSize {
+				keys = append(keys, data.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			break
+		}
+		err = m.DeleteData(keys)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := r.dbManager.UpdateData(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(registryPrefix + name))
+	})
+	if err != nil {
+		return err
+	}
+	m.Stop()
+	r.directory.Remove(name)
+	delete(r.datasets, name)
+	r.logger.Info("dataset dropped", zap.String("name", name), zap.String("prefix", d.Prefix))
+	return nil
+}
+
+// Close stops the managers of every dataset
+func (r *Registry) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for name := range r.datasets {
+		if m, ok := r.directory.Get(name); ok {
+			m.Stop()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package dataManager
+
+import (
+	"errors"
+	"go.uber.org/zap/zapcore"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger/console"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	r := NewRegistry(l, m)
+	opened := make([]string, 0)
+	r.OnOpen(func(name string, m *Manager) {
+		opened = append(opened, name)
+	})
+	err := r.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// order references provider which comes later in the list
+	err = r.Ensure([]Dataset{
+		{Name: "order", Options: Options{References: []Reference{{Field: "providerKey", Dataset: "provider"}}}},
+		{Name: "provider"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opened) != 2 {
+		t.Fatal("open hooks not run", opened)
+	}
+
+	for _, d := range []Dataset{
+		{Name: "_bad"},
+		{Name: "hidden", Prefix: "_hidden."},
+		{Name: "provider2", Prefix: "provider.x"},
+		{Name: "provider"},
+		{Name: "task", Options: Options{Schema: &Schema{Fields: []SchemaField{{Name: "a", Type: "complex"}}}}},
+	} {
+		if _, err = r.Create(d); err == nil {
+			t.Fatal("dataset should be rejected", d)
+		}
+	}
+
+	task, err := r.Create(Dataset{Name: "task", Options: Options{Schema: &Schema{Fields: []SchemaField{
+		{Name: "title", Type: FieldString, Required: true, MaxLength: 10},
+		{Name: "price", Type: FieldNumber},
+		{Name: "kind", Type: FieldString, Enum: []string{"a", "b"}},
+	}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Prefix != "task." {
+		t.Fatal("unexpected prefix", task.Prefix)
+	}
+	dm, ok := r.Get("task")
+	if !ok {
+		t.Fatal("dataset not opened")
+	}
+	results, err := dm.InsertData([]Data{
+		{Key: "1", Value: map[string]string{"title": "paint", "price": "12.5", "kind": "a"}},
+		{Key: "2", Value: map[string]string{"price": "12"}},
+		{Key: "3", Value: map[string]string{"title": "paint", "price": "cheap"}},
+		{Key: "4", Value: map[string]string{"title": "paint the whole house", "kind": "c"}},
+		{Key: "5", Value: map[string]string{"title": "paint", "color": "red"}},
+	}, InsertOption{BestEffort: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Status.Failed() != (i > 0) {
+			t.Fatal("unexpected schema result", result)
+		}
+	}
+	err = dm.PatchData([]Patch{{Key: "1", Set: map[string]string{"price": "free"}}}, false)
+	if !errors.Is(err, ErrValidation) {
+		t.Fatal("patch breaking the schema should fail validation", err)
+	}
+	if err = dm.PatchData([]Patch{{Key: "missing"}}, false); !errors.Is(err, ErrNotFound) {
+		t.Fatal("patch of a missing record should be not found", err)
+	}
+	_, err = dm.InsertData([]Data{{Key: "6", Value: map[string]string{"price": "12"}}}, InsertOption{})
+	var typed *badgerManager.Error
+	if !errors.As(err, &typed) || typed.Code != badgerManager.CodeValidation {
+		t.Fatal("rejected batch should fail validation", err)
+	}
+	if details, ok := typed.Details.([]InsertResult); !ok || len(details) != 1 || !details[0].Status.Failed() {
+		t.Fatal("rejected batch should carry its results", typed.Details)
+	}
+	if _, err = r.Create(Dataset{Name: "provider"}); !errors.Is(err, ErrConflict) {
+		t.Fatal("existing dataset should conflict", err)
+	}
+	if _, err = r.Describe("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("unknown dataset should be not found", err)
+	}
+
+	info, err := r.Describe("task")
+	if err != nil || info.Records != 1 {
+		t.Fatal("unexpected describe", info, err)
+	}
+
+	if err = r.Drop("provider"); !errors.Is(err, ErrConflict) {
+		t.Fatal("config dataset should not be dropped", err)
+	}
+	err = r.Drop("task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = r.Get("task"); ok {
+		t.Fatal("dropped dataset still opened")
+	}
+	left := 0
+	err = m.IterateData(func(key []byte, value []byte) {
+		left += 1
+	}, []byte("task."))
+	if err != nil || left != 0 {
+		t.Fatal("records left after drop", left, err)
+	}
+	r.Close()
+
+	reopened := NewRegistry(l, m)
+	err = reopened.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	list := reopened.List()
+	if len(list) != 2 || list[0].Name != "order" || list[1].Name != "provider" {
+		t.Fatal("unexpected datasets after reload", list)
+	}
+	if len(list[0].Options.References) != 1 {
+		t.Fatal("options not persisted", list[0])
+	}
+}
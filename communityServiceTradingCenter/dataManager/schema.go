@@ -0,0 +1,121 @@
+package dataManager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldInteger = "integer"
+	FieldBool    = "bool"
+	// FieldTime accepts the same formats as the lifecycle deadline
+	FieldTime = "time"
+)
+
+type SchemaField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	// Enum limits the field to the listed values when not empty
+	Enum      []string `json:"enum,omitempty"`
+	MaxLength int      `json:"maxLength,omitempty"`
+}
+
+// Schema describes the Value fields of a dataset, records are not checked when a dataset has none
+type Schema struct {
+	Fields []SchemaField `json:"fields"`
+	// AllowUnknown accepts fields the schema does not list
+	AllowUnknown bool `json:"allowUnknown"`
+}
+
+// Check validates the schema itself
+func (s *Schema) Check() error {
+	seen := make(map[string]struct{}, len(s.Fields))
+	for _, field := range s.Fields {
+		if field.Name == "" {
+			return invalid("schema field without name")
+		}
+		if _, ok := seen[field.Name]; ok {
+			return invalid("duplicate schema field : " + field.Name)
+		}
+		seen[field.Name] = struct{}{}
+		switch field.Type {
+		case FieldString, FieldNumber, FieldInteger, FieldBool, FieldTime:
+		default:
+			return invalid("unknown field type : " + field.Type)
+		}
+	}
+	return nil
+}
+
+func (f SchemaField) validate(v string) error {
+	if f.MaxLength > 0 && utf8.RuneCountInString(v) > f.MaxLength {
+		return invalid(fmt.Sprintf("field %s longer than %d", f.Name, f.MaxLength))
+	}
+	if len(f.Enum) > 0 {
+		found := false
+		for _, e := range f.Enum {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return invalid("field " + f.Name + " not in enum : " + v)
+		}
+	}
+	var err error
+	switch f.Type {
+	case FieldNumber:
+		_, err = strconv.ParseFloat(v, 64)
+	case FieldInteger:
+		_, err = strconv.ParseInt(v, 10, 64)
+	case FieldBool:
+		_, err = strconv.ParseBool(v)
+	case FieldTime:
+		if _, ok := parseDeadline(v); !ok {
+			err = errors.New("bad time")
+		}
+	}
+	if err != nil {
+		return invalid("field " + f.Name + " is not a " + f.Type + " : " + v)
+	}
+	return nil
+}
+
+func (s *Schema) validate(value map[string]string) error {
+	known := make(map[string]struct{}, len(s.Fields))
+	for _, field := range s.Fields {
+		known[field.Name] = struct{}{}
+		v, ok := value[field.Name]
+		if !ok || v == "" {
+			if field.Required {
+				return invalid("missing required field : " + field.Name)
+			}
+			continue
+		}
+		err := field.validate(v)
+		if err != nil {
+			return err
+		}
+	}
+	if s.AllowUnknown {
+		return nil
+	}
+	unknown := make([]string, 0)
+	for k := range value {
+		if _, ok := known[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return invalid(fmt.Sprintf("unknown fields : %v", unknown))
+	}
+	return nil
+}
@@ -0,0 +1,489 @@
+package dataManager
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type TransferFormat string
+
+const (
+	TransferFormatCSV    TransferFormat = "csv"
+	TransferFormatNDJSON TransferFormat = "ndjson"
+)
+
+const (
+	defaultKeyColumn       = "key"
+	defaultPriorityColumn  = "priority"
+	defaultImportBatchSize = 500
+	maxImportReportErrors  = 1000
+)
+
+func ParseTransferFormat(s string) (TransferFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "csv":
+		return TransferFormatCSV, nil
+	case "ndjson", "jsonl":
+		return TransferFormatNDJSON, nil
+	default:
+		return "", invalid("unknown format : " + s)
+	}
+}
+
+// ParseFieldMapping turns "column=field" pairs into a column to field map
+func ParseFieldMapping(pairs []string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, pair := range pairs {
+		idx := strings.Index(pair, "=")
+		if idx <= 0 || idx == len(pair)-1 {
+			return nil, invalid("invalid mapping, expect column=field : " + pair)
+		}
+		res[pair[:idx]] = pair[idx+1:]
+	}
+	return res, nil
+}
+
+type ImportOption struct {
+	Format         TransferFormat
+	KeyColumn      string
+	PriorityColumn string
+	// FieldMapping maps source columns to Value fields, when empty every other column is imported under its own name
+	FieldMapping map[string]string
+	DryRun       bool
+	BatchSize    int
+	Insert       InsertOption
+	// generateKeys lets rows without a key through when the dataset generates keys
+	generateKeys bool
+}
+
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportReport struct {
+	DryRun   bool               `json:"dryRun"`
+	Rows     int                `json:"rows"`
+	Accepted int                `json:"accepted"`
+	Failed   int                `json:"failed"`
+	Status   map[ItemStatus]int `json:"status"`
+	Errors   []ImportRowError   `json:"errors"`
+}
+
+func (r *ImportReport) addError(row int, key string, msg string) {
+	r.Failed += 1
+	if len(r.Errors) < maxImportReportErrors {
+		r.Errors = append(r.Errors, ImportRowError{
+			Row:     row,
+			Key:     key,
+			Message: msg,
+		})
+	}
+}
+
+// rowError marks a malformed row, the reader can go on with the next one
+type rowError struct {
+	err error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+type rowReader interface {
+	// next returns the column values of the next row, io.EOF when finished
+	next() (map[string]string, error)
+}
+
+type csvRowReader struct {
+	reader *csv.Reader
+	header []string
+}
+
+func newCsvRowReader(r io.Reader) (*csvRowReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, invalid("missing csv header")
+		}
+		return nil, err
+	}
+	if len(header) > 0 {
+		// spreadsheets often save utf-8 csv with a byte order mark
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	return &csvRowReader{
+		reader: reader,
+		header: header,
+	}, nil
+}
+
+func (p *csvRowReader) next() (map[string]string, error) {
+	record, err := p.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &rowError{err: err}
+		}
+		return nil, err
+	}
+	if len(record) > len(p.header) {
+		return nil, &rowError{err: fmt.Errorf("row has %d columns but header has %d", len(record), len(p.header))}
+	}
+	row := make(map[string]string, len(record))
+	for i, v := range record {
+		row[p.header[i]] = v
+	}
+	return row, nil
+}
+
+type ndjsonRowReader struct {
+	scanner *bufio.Scanner
+}
+
+func newNdjsonRowReader(r io.Reader) *ndjsonRowReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	return &ndjsonRowReader{
+		scanner: scanner,
+	}
+}
+
+func (p *ndjsonRowReader) next() (map[string]string, error) {
+	for p.scanner.Scan() {
+		line := strings.TrimSpace(p.scanner.Text())
+		if line == "" {
+			continue
+		}
+		var obj map[string]any
+		err := json.Unmarshal([]byte(line), &obj)
+		if err != nil {
+			return nil, &rowError{err: err}
+		}
+		row := make(map[string]string, len(obj))
+		for k, v := range obj {
+			switch val := v.(type) {
+			case nil:
+				continue
+			case string:
+				row[k] = val
+			case float64:
+				row[k] = strconv.FormatFloat(val, 'f', -1, 64)
+			case bool:
+				row[k] = strconv.FormatBool(val)
+			default:
+				return nil, &rowError{err: errors.New("column " + k + " is not a scalar value")}
+			}
+		}
+		return row, nil
+	}
+	err := p.scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (option ImportOption) rowToData(row map[string]string) (Data, error) {
+	data := Data{
+		Key:   strings.TrimSpace(row[option.KeyColumn]),
+		Value: make(map[string]string),
+	}
+	if data.Key == "" && !option.generateKeys {
+		return data, invalid("missing key column " + option.KeyColumn)
+	}
+	if option.PriorityColumn != "" {
+		if pStr := strings.TrimSpace(row[option.PriorityColumn]); pStr != "" {
+			priority, err := strconv.ParseUint(pStr, 10, 64)
+			if err != nil {
+				return data, invalid("invalid priority : " + pStr)
+			}
+			data.Priority = priority
+		}
+	}
+	for column, v := range row {
+		if column == option.KeyColumn || column == option.PriorityColumn || v == "" {
+			continue
+		}
+		field := column
+		if len(option.FieldMapping) > 0 {
+			mapped, ok := option.FieldMapping[column]
+			if !ok {
+				continue
+			}
+			field = mapped
+		}
+		data.Value[field] = v
+	}
+	return data, nil
+}
+
+// Import streams rows from r into the dataset in batches. Blank cells are skipped rather than stored as empty fields.
+// With an all-or-nothing insert option every batch is committed or rolled back on its own.
+func (p *Manager) Import(r io.Reader, option ImportOption) (*ImportReport, error) {
+	if option.KeyColumn == "" {
+		option.KeyColumn = defaultKeyColumn
+	}
+	if option.PriorityColumn == "" {
+		option.PriorityColumn = defaultPriorityColumn
+	}
+	if option.BatchSize <= 0 {
+		option.BatchSize = defaultImportBatchSize
+	}
+	option.generateKeys = p.GetOptions().Keys.Strategy != ""
+
+	var rows rowReader
+	switch option.Format {
+	case TransferFormatCSV:
+		csvReader, err := newCsvRowReader(r)
+		if err != nil {
+			return nil, err
+		}
+		rows = csvReader
+	case TransferFormatNDJSON:
+		rows = newNdjsonRowReader(r)
+	default:
+		return nil, invalid("unknown format : " + string(option.Format))
+	}
+
+	report := &ImportReport{
+		DryRun: option.DryRun,
+		Status: make(map[ItemStatus]int),
+		Errors: make([]ImportRowError, 0),
+	}
+	keySeen := make(map[string]struct{})
+
+	batch := make([]Data, 0, option.BatchSize)
+	batchRows := make([]int, 0, option.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var results []InsertResult
+		var err error
+		if option.DryRun {
+			results, err = p.CheckDataAs(batch, option.Insert.Mode, option.Insert.Actor)
+		} else {
+			results, err = p.InsertData(batch, option.Insert)
+		}
+		if results == nil && err != nil {
+			return err
+		}
+		rolledBack := err != nil
+		for i, res := range results {
+			report.Status[res.Status] += 1
+			if res.Status.Failed() {
+				report.addError(batchRows[i], res.Key, string(res.Status)+" : "+res.Message)
+			} else if rolledBack {
+				report.addError(batchRows[i], res.Key, "rolled back with its batch")
+			} else {
+				report.Accepted += 1
+			}
+		}
+		batch = batch[:0]
+		batchRows = batchRows[:0]
+		return nil
+	}
+
+	// row numbers count the csv header as row 1 so they match what spreadsheets show
+	rowNo := 0
+	if option.Format == TransferFormatCSV {
+		rowNo = 1
+	}
+	for {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		rowNo += 1
+		if err != nil {
+			if _, ok := err.(*rowError); ok {
+				report.Rows += 1
+				report.addError(rowNo, "", err.Error())
+				continue
+			}
+			return report, err
+		}
+		report.Rows += 1
+		data, err := option.rowToData(row)
+		if err != nil {
+			report.addError(rowNo, data.Key, err.Error())
+			continue
+		}
+		if data.Key != "" {
+			if _, ok := keySeen[data.Key]; ok {
+				report.addError(rowNo, data.Key, "duplicate key in file")
+				continue
+			}
+			keySeen[data.Key] = struct{}{}
+		}
+		batch = append(batch, data)
+		batchRows = append(batchRows, rowNo)
+		if len(batch) >= option.BatchSize {
+			err = flush()
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+	err := flush()
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// CheckData reports what InsertData would do with list without writing anything
+func (p *Manager) CheckData(list []Data, mode WriteMode) (results []InsertResult, err error) {
+	return p.CheckDataAs(list, mode, nil)
+}
+
+// CheckDataAs is CheckData with the ownership checks of actor
+func (p *Manager) CheckDataAs(list []Data, mode WriteMode, actor *Actor) (results []InsertResult, err error) {
+	if mode == "" {
+		mode = WriteModeUpsert
+	}
+	generate := p.GetOptions().Keys.Strategy != ""
+	err = p.dbManager.ViewData(func(txn *badger.Txn) error {
+		results = make([]InsertResult, len(list))
+		for i, data := range list {
+			itemMode := mode
+			// a dry run does not consume sequence numbers
+			if data.Key == "" && generate {
+				data.Key = generatedKeyPlaceholder
+				itemMode = generatedKeyMode(mode)
+			}
+			res, _, err := p.insertOne(txn, &data, itemMode, actor, nil)
+			if err != nil {
+				return err
+			}
+			results[i] = res
+		}
+		return nil
+	})
+	return results, err
+}
+
+type ExportOption struct {
+	Format         TransferFormat
+	KeyColumn      string
+	PriorityColumn string
+	// Columns limits and orders the exported Value fields, when empty every field found in the dataset is exported
+	Columns []string
+	// IncludeUnlisted exports the records moderation hides from queries too, only for moderators
+	IncludeUnlisted bool
+	// AllStates exports the records of every lifecycle state, not only the visible ones, only for moderators
+	AllStates bool
+}
+
+func (p *Manager) iterateDataset(f func(data Data) error) error {
+	var innerErr error
+	err := p.dbManager.IterateData(func(key []byte, value []byte) {
+		if innerErr != nil {
+			return
+		}
+		innerErr = f(deSerializeData(value))
+	}, []byte(p.prefix))
+	if err != nil {
+		return err
+	}
+	return innerErr
+}
+
+// Export writes the records queries list, those waiting for or refused by moderation only with IncludeUnlisted
+// and those in a state that is not visible only with AllStates
+func (p *Manager) Export(w io.Writer, option ExportOption) error {
+	lifecycle := p.GetOptions().Lifecycle
+	states := newStateFilter(lifecycle, nil)
+	if option.AllStates {
+		states = nil
+	}
+	iterate := func(f func(data Data) error) error {
+		return p.iterateDataset(func(data Data) error {
+			if !option.IncludeUnlisted && !p.listed(data) {
+				return nil
+			}
+			if !states.match(lifecycle, data) {
+				return nil
+			}
+			return f(data)
+		})
+	}
+	if option.KeyColumn == "" {
+		option.KeyColumn = defaultKeyColumn
+	}
+	if option.PriorityColumn == "" {
+		option.PriorityColumn = defaultPriorityColumn
+	}
+	columns := option.Columns
+	if len(columns) == 0 {
+		fieldSet := make(map[string]struct{})
+		err := iterate(func(data Data) error {
+			for k := range data.Value {
+				fieldSet[k] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		columns = make([]string, 0, len(fieldSet))
+		for k := range fieldSet {
+			if k == option.KeyColumn || k == option.PriorityColumn {
+				continue
+			}
+			columns = append(columns, k)
+		}
+		sort.Strings(columns)
+	}
+
+	switch option.Format {
+	case TransferFormatCSV:
+		writer := csv.NewWriter(w)
+		header := append([]string{option.KeyColumn, option.PriorityColumn}, columns...)
+		err := writer.Write(header)
+		if err != nil {
+			return err
+		}
+		err = iterate(func(data Data) error {
+			record := make([]string, 0, len(header))
+			record = append(record, data.Key, strconv.FormatUint(data.Priority, 10))
+			for _, column := range columns {
+				record = append(record, data.Value[column])
+			}
+			return writer.Write(record)
+		})
+		if err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+	case TransferFormatNDJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		return iterate(func(data Data) error {
+			row := make(map[string]any, len(columns)+2)
+			for _, column := range columns {
+				if v, ok := data.Value[column]; ok {
+					row[column] = v
+				}
+			}
+			row[option.KeyColumn] = data.Key
+			row[option.PriorityColumn] = data.Priority
+			return encoder.Encode(row)
+		})
+	default:
+		return invalid("unknown format : " + string(option.Format))
+	}
+}
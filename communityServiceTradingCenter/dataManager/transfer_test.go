@@ -0,0 +1,94 @@
+package dataManager
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestImportExport(t *testing.T) {
+	dm, stop := newTestDataManager(t, "transfer.")
+	defer stop()
+
+	csvData := "\ufeff编号,主题,截止,优先级,备注\n" +
+		"p1,修水管,2026-01-01,5,\n" +
+		"p2,家教,2026-02-01,x,\n" +
+		",无编号,2026-03-01,1,\n" +
+		"p1,重复,2026-04-01,1,\n" +
+		"p3,保洁,2026-05-01,7,ignored\n"
+	option := ImportOption{
+		Format:         TransferFormatCSV,
+		KeyColumn:      "编号",
+		PriorityColumn: "优先级",
+		FieldMapping:   map[string]string{"主题": "theme", "截止": "deadline"},
+		DryRun:         true,
+		Insert:         InsertOption{BestEffort: true},
+	}
+
+	report, err := dm.Import(strings.NewReader(csvData), option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows != 5 || report.Accepted != 2 || report.Failed != 3 {
+		t.Fatal("unexpected dry run report", report)
+	}
+	if report.Errors[0].Row != 3 {
+		t.Fatal("row numbers should count the header", report.Errors)
+	}
+	if _, exists := dm.getTestData(t, "p1"); exists {
+		t.Fatal("dry run should not write")
+	}
+
+	option.DryRun = false
+	report, err = dm.Import(strings.NewReader(csvData), option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 2 {
+		t.Fatal("unexpected report", report)
+	}
+	data, _ := dm.getTestData(t, "p3")
+	if data.Priority != 7 || data.Value["theme"] != "保洁" || len(data.Value) != 2 {
+		t.Fatal("unexpected imported data", data)
+	}
+
+	ndjson := `{"key":"n1","priority":3,"theme":"陪诊","open":true}` + "\n\n" +
+		`{"key":"n2","theme":` + "\n" +
+		`{"key":"n3","theme":"遛狗"}` + "\n"
+	report, err = dm.Import(strings.NewReader(ndjson), ImportOption{
+		Format: TransferFormatNDJSON,
+		Insert: InsertOption{BestEffort: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows != 3 || report.Accepted != 2 || report.Failed != 1 {
+		t.Fatal("unexpected ndjson report", report)
+	}
+	data, _ = dm.getTestData(t, "n1")
+	if data.Priority != 3 || data.Value["open"] != "true" {
+		t.Fatal("unexpected imported data", data)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = dm.Export(out, ExportOption{
+		Format:  TransferFormatCSV,
+		Columns: []string{"theme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "key,priority,theme\nn1,3,陪诊\nn3,0,遛狗\np1,5,修水管\np3,7,保洁\n"
+	if out.String() != expected {
+		t.Fatal("unexpected csv export", out.String())
+	}
+
+	out.Reset()
+	err = dm.Export(out, ExportOption{Format: TransferFormatNDJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "\n") != 4 || !strings.Contains(out.String(), `"deadline":"2026-01-01"`) {
+		t.Fatal("unexpected ndjson export", out.String())
+	}
+}
@@ -0,0 +1,336 @@
+package dataManager
+
+import (
+	"bufio"
+	"go.uber.org/zap"
+	"moonlighting/common/logger"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const wordListCheckInterval = 5 * time.Second
+
+// traditionalPairs maps common traditional characters to their simplified form, two runes per pair
+const traditionalPairs = "" +
+	"國国學学體体後后說说時时會会來来對对個个們们為为這这與与開开關关長长門门問问間间聞闻東东車车馬马鳥鸟魚鱼龍龙" +
+	"電电話话語语讀读書书買买賣卖錢钱銀银貨货貸贷價价資资賭赌槍枪彈弹藥药黃黄盜盗騙骗詐诈偽伪證证處处發发髮发現现" +
+	"氣气熱热愛爱無无樂乐聽听見见視视觀观親亲戰战軍军黨党員员歲岁壓压廣广場场殺杀傷伤幫帮嗎吗裡里裏里號号幾几機机" +
+	"飛飞鐵铁銷销點点網网絡络級级經经線线紅红約约給给結结統统總总際际陽阳陰阴隊队險险難难雙双雜杂離离頭头題题顏颜" +
+	"類类風风飯饭館馆驗验鬥斗麼么齊齐廠厂運运過过還还進进遠远邊边連连選选遊游鄉乡醫医針针錯错鏡镜陳陈陸陆隨随萬万" +
+	"葉叶藝艺蘭兰虛虚蟲虫衛卫補补製制複复規规覺觉計计記记設设許许論论識识試试詳详認认讓让變变貓猫豬猪貝贝負负財财" +
+	"責责貴贵費费賀贺質质購购趕赶躍跃軟软輕轻輸输農农辦办遲迟適适鄰邻釣钓閉闭陣阵隱隐雖虽靈灵預预領领顧顾飲饮養养" +
+	"驅驱騎骑鬧闹魯鲁鹽盐麥麦齒齿搶抢擊击據据掃扫換换擇择擔担攝摄敗败數数斷断於于舊旧權权樣样橋桥檢检歡欢歸归濟济" +
+	"燈灯爭争獎奖獨独環环產产畫画當当療疗盡尽監监礦矿確确穩稳窮穷競竞筆笔節节範范簡简紀纪純纯紙纸細细終终組组織织" +
+	"績绩縣县罷罢聯联聲声職职腦脑臨临舉举莊庄華华滅灭漢汉潔洁濕湿災灾烏乌煙烟獲获嬰婴婦妇孫孙寶宝實实審审寫写導导" +
+	"屬属歷历廳厅彎弯強强從从復复徵征憂忧應应懷怀戲戏戶户掛挂揚扬擁拥擴扩賄贿賂赂詞词僞伪麗丽腳脚樓楼"
+
+var simplifiedRunes = func() map[rune]rune {
+	pairs := []rune(traditionalPairs)
+	res := make(map[rune]rune, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] != pairs[i+1] {
+			res[pairs[i]] = pairs[i+1]
+		}
+	}
+	return res
+}()
+
+// normalizeRune folds full-width forms to half-width, traditional characters to simplified and letters to lower case
+func normalizeRune(r rune) rune {
+	switch {
+	case r == 0x3000:
+		r = ' '
+	case r >= 0xFF01 && r <= 0xFF5E:
+		r -= 0xFEE0
+	}
+	if s, ok := simplifiedRunes[r]; ok {
+		r = s
+	}
+	return unicode.ToLower(r)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+func isLatinRune(r rune) bool {
+	return r < unicode.MaxASCII && isWordRune(r)
+}
+
+// filterRune is one letter of the text being matched, spaces and punctuation are dropped
+// so "毒 品" and "f.u.c.k" are still found
+type filterRune struct {
+	r rune
+	// pos is the index of the rune in the original text
+	pos int
+	// boundary is set when a dropped rune comes right before this one
+	boundary bool
+}
+
+func normalizeFilterText(text string) []filterRune {
+	res := make([]filterRune, 0, len(text))
+	boundary := true
+	pos := 0
+	for _, r := range text {
+		r = normalizeRune(r)
+		if isWordRune(r) {
+			res = append(res, filterRune{r: r, pos: pos, boundary: boundary})
+			boundary = false
+		} else {
+			boundary = true
+		}
+		pos += 1
+	}
+	return res
+}
+
+type acNode struct {
+	next map[rune]int
+	fail int
+	// out holds the words ending at this node, including those reached through fail links
+	out []int
+}
+
+type acWord struct {
+	term   string
+	length int
+	// latin words only match whole words, so "ass" is not found in "class"
+	latin bool
+}
+
+// acMatcher is an Aho-Corasick automaton over normalized runes
+type acMatcher struct {
+	nodes []acNode
+	words []acWord
+}
+
+type acMatch struct {
+	start int
+	end   int
+	word  int
+}
+
+func newACMatcher(terms []string) *acMatcher {
+	m := &acMatcher{
+		nodes: []acNode{{next: make(map[rune]int)}},
+		words: make([]acWord, 0, len(terms)),
+	}
+	for _, term := range terms {
+		runes := normalizeFilterText(term)
+		if len(runes) == 0 {
+			continue
+		}
+		latin := true
+		node := 0
+		for _, fr := range runes {
+			latin = latin && isLatinRune(fr.r)
+			child, ok := m.nodes[node].next[fr.r]
+			if !ok {
+				child = len(m.nodes)
+				m.nodes = append(m.nodes, acNode{next: make(map[rune]int)})
+				m.nodes[node].next[fr.r] = child
+			}
+			node = child
+		}
+		if len(m.nodes[node].out) > 0 {
+			continue
+		}
+		m.nodes[node].out = []int{len(m.words)}
+		m.words = append(m.words, acWord{term: term, length: len(runes), latin: latin})
+	}
+
+	queue := make([]int, 0, len(m.nodes))
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for r, child := range m.nodes[node].next {
+			fail := m.nodes[node].fail
+			for fail != 0 {
+				if _, ok := m.nodes[fail].next[r]; ok {
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+			if target, ok := m.nodes[fail].next[r]; ok && target != child {
+				fail = target
+			} else {
+				fail = 0
+			}
+			m.nodes[child].fail = fail
+			m.nodes[child].out = append(m.nodes[child].out, m.nodes[fail].out...)
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+func (m *acMatcher) find(text []filterRune) []acMatch {
+	res := make([]acMatch, 0)
+	node := 0
+	for i, fr := range text {
+		for node != 0 {
+			if _, ok := m.nodes[node].next[fr.r]; ok {
+				break
+			}
+			node = m.nodes[node].fail
+		}
+		node = m.nodes[node].next[fr.r]
+		for _, w := range m.nodes[node].out {
+			word := m.words[w]
+			start := i - word.length + 1
+			if word.latin {
+				if !text[start].boundary && isLatinRune(text[start-1].r) {
+					continue
+				}
+				if i+1 < len(text) && !text[i+1].boundary && isLatinRune(text[i+1].r) {
+					continue
+				}
+			}
+			res = append(res, acMatch{start: start, end: i, word: w})
+		}
+	}
+	return res
+}
+
+// WordFilter finds prohibited terms in record values, the word list file is reloaded when it changes
+type WordFilter struct {
+	logger     logger.ILogger
+	path       string
+	lock       sync.RWMutex
+	matcher    *acMatcher
+	modTime    time.Time
+	stopSignal chan int
+	stopOnce   sync.Once
+}
+
+// NewWordFilter reads one term per line from path, blank lines and lines starting with # are skipped.
+// An empty path leaves the list to SetWords.
+func NewWordFilter(l logger.ILogger, path string) *WordFilter {
+	p := &WordFilter{
+		logger:     l,
+		path:       path,
+		lock:       sync.RWMutex{},
+		matcher:    newACMatcher(nil),
+		stopSignal: make(chan int),
+		stopOnce:   sync.Once{},
+	}
+	p.reload()
+	return p
+}
+
+func (p *WordFilter) Start() {
+	go p.loopMain()
+	<-p.stopSignal
+}
+
+func (p *WordFilter) Stop() {
+	p.stopOnce.Do(func() {
+		select {
+		case <-p.stopSignal:
+			return
+		default:
+
+		}
+		close(p.stopSignal)
+	})
+}
+
+func (p *WordFilter) loopMain() {
+	ticker := time.NewTicker(wordListCheckInterval)
+	defer ticker.Stop()
+	for true {
+		select {
+		case <-p.stopSignal:
+			return
+		case <-ticker.C:
+			p.reload()
+		}
+	}
+}
+
+// reload reads the word list again when its modification time changed, the old list stays on errors
+func (p *WordFilter) reload() {
+	if p.path == "" {
+		return
+	}
+	info, err := os.Stat(p.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			p.logger.Warn("stat word list failed", zap.String("path", p.path), zap.Error(err))
+		}
+		return
+	}
+	p.lock.RLock()
+	unchanged := info.ModTime().Equal(p.modTime)
+	p.lock.RUnlock()
+	if unchanged {
+		return
+	}
+
+	f, err := os.Open(p.path)
+	if err != nil {
+		p.logger.Warn("open word list failed", zap.String("path", p.path), zap.Error(err))
+		return
+	}
+	defer f.Close()
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		p.logger.Warn("read word list failed", zap.String("path", p.path), zap.Error(err))
+		return
+	}
+	p.SetWords(words)
+	p.lock.Lock()
+	p.modTime = info.ModTime()
+	p.lock.Unlock()
+	p.logger.Info("word list loaded", zap.String("path", p.path), zap.Int("words", len(words)))
+}
+
+// SetWords replaces the word list
+func (p *WordFilter) SetWords(words []string) {
+	matcher := newACMatcher(words)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.matcher = matcher
+}
+
+func (p *WordFilter) getMatcher() *acMatcher {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.matcher
+}
+
+// Check returns text with every prohibited term masked by * and the terms found, as written in the word list
+func (p *WordFilter) Check(text string) (masked string, terms []string) {
+	matcher := p.getMatcher()
+	normalized := normalizeFilterText(text)
+	matches := matcher.find(normalized)
+	if len(matches) == 0 {
+		return text, nil
+	}
+	runes := []rune(text)
+	seen := make(map[int]struct{})
+	for _, match := range matches {
+		for i := match.start; i <= match.end; i++ {
+			runes[normalized[i].pos] = '*'
+		}
+		if _, ok := seen[match.word]; !ok {
+			seen[match.word] = struct{}{}
+			terms = append(terms, matcher.words[match.word].term)
+		}
+	}
+	return string(runes), terms
+}
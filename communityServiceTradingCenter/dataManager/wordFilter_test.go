@@ -0,0 +1,116 @@
+package dataManager
+
+import (
+	"go.uber.org/zap/zapcore"
+	"moonlighting/common/logger/console"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWordFilter(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	path := filepath.Join(t.TempDir(), "words.txt")
+	err := os.WriteFile(path, []byte("# prohibited\n毒品\nass\n\n發票\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewWordFilter(l, path)
+
+	cases := []struct {
+		text   string
+		masked string
+		terms  []string
+	}{
+		{"出售毒品", "出售**", []string{"毒品"}},
+		{"代开发票，毒 品", "代开**，* *", []string{"發票", "毒品"}},
+		{"代開發票", "代開**", []string{"發票"}},
+		{"kick ＡＳＳ now", "kick *** now", []string{"ass"}},
+		{"a.s.s", "*.*.*", []string{"ass"}},
+		{"first class pass", "first class pass", nil},
+	}
+	for _, c := range cases {
+		masked, terms := f.Check(c.text)
+		if masked != c.masked || !reflect.DeepEqual(terms, c.terms) {
+			t.Fatal("unexpected check of", c.text, masked, terms)
+		}
+	}
+
+	err = os.WriteFile(path, []byte("gun\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.reload()
+	if _, terms := f.Check("毒品 gun"); len(terms) != 1 || terms[0] != "gun" {
+		t.Fatal("word list not reloaded", terms)
+	}
+}
+
+func TestContentFilter(t *testing.T) {
+	dm, stop := newTestDataManager(t, "publisher.")
+	defer stop()
+
+	f := NewWordFilter(console.NewConsoleLogger(zapcore.InfoLevel), "")
+	f.SetWords([]string{"毒品", "scam"})
+	dm.SetWordFilter(f)
+
+	if (&ContentFilterOptions{Action: ContentModerate}).Check(Options{}) == nil {
+		t.Fatal("moderate without moderation should be rejected")
+	}
+	dm.SetOptions(Options{ContentFilter: &ContentFilterOptions{Fields: []string{"title"}, Action: ContentReject}})
+	results, err := dm.InsertData([]Data{
+		{Key: "a", Value: map[string]string{"title": "Not a SCAM"}},
+		{Key: "b", Value: map[string]string{"title": "ok", "note": "scam"}},
+	}, InsertOption{BestEffort: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != ItemStatusFiltered || !reflect.DeepEqual(results[0].Matches, []string{"scam"}) {
+		t.Fatal("prohibited term should be rejected", results[0])
+	}
+	if results[1].Status != ItemStatusCreated {
+		t.Fatal("unchecked field should be accepted", results[1])
+	}
+
+	dm.SetOptions(Options{ContentFilter: &ContentFilterOptions{Action: ContentMask}})
+	value := map[string]string{"title": "便宜毒品"}
+	results, _ = dm.InsertData([]Data{{Key: "c", Value: value}}, InsertOption{})
+	if results[0].Status != ItemStatusCreated || len(results[0].Matches) != 1 {
+		t.Fatal("masked record should be created", results)
+	}
+	if data, _ := dm.getTestData(t, "c"); data.Value["title"] != "便宜**" || value["title"] != "便宜毒品" {
+		t.Fatal("unexpected masking", data.Value, value)
+	}
+
+	dm.SetOptions(Options{
+		Moderation:    &ModerationOptions{},
+		ContentFilter: &ContentFilterOptions{Action: ContentModerate},
+	})
+	// an approved record goes back to the queue when a change matches
+	err = dm.Decide(ModerationDecision{Key: "b", Action: DecisionApprove, Reviewer: "alice"})
+	if err == nil {
+		t.Fatal("record stored without moderation is not pending")
+	}
+	err = dm.PatchData([]Patch{{Key: "b", Set: map[string]string{"title": "scam"}}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := dm.ModerationQueue("", "")
+	if err != nil || len(queue) != 1 || queue[0].Key != "b" || queue[0].Moderation.History[0].Reason == "" {
+		t.Fatal("matching record should be held", queue, err)
+	}
+	results, _ = dm.InsertData([]Data{{Key: "c", Value: map[string]string{"title": "clean"}}}, InsertOption{})
+	if results[0].held {
+		t.Fatal("clean update should not be held", results)
+	}
+	if data, _ := dm.getTestData(t, "c"); data.Moderation != nil {
+		t.Fatal("unreviewed update should stay listed", data.Moderation)
+	}
+}
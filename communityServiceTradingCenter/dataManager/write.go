@@ -0,0 +1,217 @@
+package dataManager
+
+import (
+	"github.com/dgraph-io/badger/v3"
+)
+
+type InsertHook func(data Data)
+
+type UpdateHook func(old Data, data Data)
+
+type DeleteHook func(old Data)
+
+// WriteHook runs in the transaction of a change, old is nil for a created record and data is nil for a deleted one
+type WriteHook func(txn *badger.Txn, old *Data, data *Data) error
+
+type hooks struct {
+	insert []InsertHook
+	update []UpdateHook
+	delete []DeleteHook
+	write  []WriteHook
+}
+
+// change is one record written in a batch, old is nil for a created record and data is nil for a deleted one
+type change struct {
+	manager *Manager
+	old     *Data
+	data    *Data
+}
+
+// writeBatch carries a transaction together with the changes made in it, so hooks run only after commit
+type writeBatch struct {
+	txn     *badger.Txn
+	changes []change
+}
+
+// OnInsert registers h to run after a record has been created
+func (p *Manager) OnInsert(h InsertHook) {
+	p.hooksLock.Lock()
+	defer p.hooksLock.Unlock()
+	p.hooks.insert = append(p.hooks.insert, h)
+}
+
+// OnUpdate registers h to run after a stored record has been replaced
+func (p *Manager) OnUpdate(h UpdateHook) {
+	p.hooksLock.Lock()
+	defer p.hooksLock.Unlock()
+	p.hooks.update = append(p.hooks.update, h)
+}
+
+// OnDelete registers h to run after a record has been deleted
+func (p *Manager) OnDelete(h DeleteHook) {
+	p.hooksLock.Lock()
+	defer p.hooksLock.Unlock()
+	p.hooks.delete = append(p.hooks.delete, h)
+}
+
+// OnWrite registers h to run in the transaction of every change, so what it stores commits or rolls back
+// together with the record. An error of h rolls the whole batch back.
+func (p *Manager) OnWrite(h WriteHook) {
+	p.hooksLock.Lock()
+	defer p.hooksLock.Unlock()
+	p.hooks.write = append(p.hooks.write, h)
+}
+
+func (p *Manager) getHooks() hooks {
+	p.hooksLock.RLock()
+	defer p.hooksLock.RUnlock()
+	return p.hooks
+}
+
+// update runs f in one transaction, then refreshes the sort key lists and runs the hooks of every touched manager
+func (p *Manager) update(f func(b *writeBatch) error) error {
+	var batch *writeBatch
+	err := p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		batch = &writeBatch{
+			txn:     txn,
+			changes: make([]change, 0),
+		}
+		return f(batch)
+	})
+	if err != nil {
+		return err
+	}
+
+	touched := make(map[*Manager]struct{})
+	for _, c := range batch.changes {
+		if _, ok := touched[c.manager]; !ok {
+			touched[c.manager] = struct{}{}
+			c.manager.bumpGeneration()
+			c.manager.requestSortKeyListUpdate()
+		}
+	}
+	for _, c := range batch.changes {
+		c.manager.runHooks(c)
+	}
+	return nil
+}
+
+// requestSortKeyListUpdate never blocks, a full channel already holds a pending update
+func (p *Manager) requestSortKeyListUpdate() {
+	select {
+	case p.updateSortKeyListSignal <- 1:
+	default:
+	}
+}
+
+func (p *Manager) runHooks(c change) {
+	h := p.getHooks()
+	switch {
+	case c.old == nil:
+		for _, f := range h.insert {
+			f(*c.data)
+		}
+	case c.data == nil:
+		for _, f := range h.delete {
+			f(*c.old)
+		}
+	default:
+		for _, f := range h.update {
+			f(*c.old, *c.data)
+		}
+	}
+}
+
+func (p *Manager) runWriteHooks(txn *badger.Txn, old *Data, data *Data) error {
+	for _, f := range p.getHooks().write {
+		err := f(txn, old, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putData stores data and keeps the secondary indexes in step, old is nil for a new record
+func (p *Manager) putData(b *writeBatch, old *Data, data Data) error {
+	now := nowMs()
+	data.CreateTimeMs = now
+	if old != nil {
+		data.CreateTimeMs = old.CreateTimeMs
+	}
+	data.UpdateTimeMs = now
+	data.Version = 1
+	if old != nil {
+		data.Version = old.Version + 1
+	}
+	data.Boosts = activeBoosts(data.Boosts, now)
+	if old != nil {
+		err := p.removeIndexes(b.txn, *old)
+		if err != nil {
+			return err
+		}
+	}
+	err := b.txn.Set(p.dbKey(data.Key), serializeData(data))
+	if err != nil {
+		return err
+	}
+	err = p.addIndexes(b.txn, data)
+	if err != nil {
+		return err
+	}
+	err = p.runWriteHooks(b.txn, old, &data)
+	if err != nil {
+		return err
+	}
+	b.changes = append(b.changes, change{
+		manager: p,
+		old:     old,
+		data:    &data,
+	})
+	return nil
+}
+
+func (p *Manager) removeData(b *writeBatch, old Data) error {
+	err := p.removeIndexes(b.txn, old)
+	if err != nil {
+		return err
+	}
+	err = b.txn.Delete(p.dbKey(old.Key))
+	if err != nil {
+		return err
+	}
+	err = p.runWriteHooks(b.txn, &old, nil)
+	if err != nil {
+		return err
+	}
+	b.changes = append(b.changes, change{
+		manager: p,
+		old:     &old,
+	})
+	// the record is already gone here, so a cascade that comes back to it stops
+	return p.releaseReferrers(b, old.Key)
+}
+
+func (p *Manager) addIndexes(txn *badger.Txn, data Data) error {
+	err := p.addGeoIndex(txn, data)
+	if err != nil {
+		return err
+	}
+	err = p.updateDuplicateIndex(txn, data, false)
+	if err != nil {
+		return err
+	}
+	return p.updateRefIndex(txn, data, false)
+}
+
+func (p *Manager) removeIndexes(txn *badger.Txn, old Data) error {
+	err := p.removeGeoIndex(txn, old)
+	if err != nil {
+		return err
+	}
+	err = p.updateDuplicateIndex(txn, old, true)
+	if err != nil {
+		return err
+	}
+	return p.updateRefIndex(txn, old, true)
+}
@@ -0,0 +1,48 @@
+package dataManager
+
+import (
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	dm, stop := newTestDataManager(t, "hooks.")
+	defer stop()
+
+	events := make([]string, 0)
+	dm.OnInsert(func(data Data) {
+		events = append(events, "insert "+data.Key)
+	})
+	dm.OnUpdate(func(old Data, data Data) {
+		events = append(events, "update "+data.Key+" "+old.Value["v"]+" -> "+data.Value["v"])
+	})
+	dm.OnDelete(func(old Data) {
+		events = append(events, "delete "+old.Key+" "+old.Value["v"])
+	})
+
+	_, err := dm.InsertData([]Data{{Key: "k1", Value: map[string]string{"v": "1"}}}, InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dm.PatchData([]Patch{{Key: "k1", Set: map[string]string{"v": "2"}}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dm.InsertData([]Data{{Key: "k1"}, {Key: ""}}, InsertOption{})
+	if err == nil {
+		t.Fatal("batch should be rejected")
+	}
+	err = dm.DeleteData([]string{"k1", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"insert k1", "update k1 1 -> 2", "delete k1 2"}
+	if len(events) != len(expected) {
+		t.Fatal("unexpected events", events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatal("unexpected events", events)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/userManager"
+)
+
+type principalReq struct {
+	Principal string `json:"principal"`
+}
+
+func (p *Server) routeV1Access(r *gin.RouterGroup) {
+
+	accessRoute := r.Group("/access", p.requirePermission(userManager.AnyDataset, userManager.ActionAdmin))
+
+	roleRoute := accessRoute.Group("/role")
+	roleRoute.POST("/list", func(context *gin.Context) {
+		roles, err := p.userManager.ListRoles()
+		if err != nil {
+			sendError(context, "list roles failed", err)
+			return
+		}
+
+		sendResponse(context, true, roles)
+	})
+
+	roleRoute.POST("/set", func(context *gin.Context) {
+		var req userManager.Role
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		err = p.userManager.SetRole(req)
+		if err != nil {
+			sendError(context, "set role failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	roleRoute.POST("/delete", func(context *gin.Context) {
+		var req nameReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		err = p.userManager.DeleteRole(req.Name)
+		if err != nil {
+			sendError(context, "delete role failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	bindingRoute := accessRoute.Group("/binding")
+	bindingRoute.POST("/list", func(context *gin.Context) {
+		bindings, err := p.userManager.ListBindings()
+		if err != nil {
+			sendError(context, "list bindings failed", err)
+			return
+		}
+
+		sendResponse(context, true, bindings)
+	})
+
+	bindingRoute.POST("/get", func(context *gin.Context) {
+		var req principalReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		binding, err := p.userManager.GetBinding(req.Principal)
+		if err != nil {
+			sendError(context, "get binding failed", err)
+			return
+		}
+
+		sendResponse(context, true, binding)
+	})
+
+	// set replaces the roles of a principal, an empty role list removes the binding
+	bindingRoute.POST("/set", func(context *gin.Context) {
+		var req userManager.Binding
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		err = p.userManager.SetBinding(req.Principal, req.Roles)
+		if err != nil {
+			sendError(context, "set binding failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+}
@@ -0,0 +1,131 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"time"
+)
+
+// managedApiKey loads the key named in the request, only its owner and admins may change it.
+// It answers the request itself and returns false when the check fails.
+func (p *Server) managedApiKey(context *gin.Context, id string) bool {
+	k, err := p.userManager.GetApiKey(id)
+	if err != nil {
+		sendError(context, "get api key failed", err)
+		return false
+	}
+	if k.Owner == contextUser(context).Username {
+		return true
+	}
+	admin, err := p.userManager.Allowed(contextPrincipal(context), userManager.AnyDataset, userManager.ActionAdmin)
+	if err != nil {
+		sendError(context, "check permission failed", err)
+		return false
+	}
+	if !admin {
+		sendStatus(context, 403, "permission denied : not the owner of api key "+id)
+		return false
+	}
+	return true
+}
+
+type apiKeyCreateReq struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// TTLHours is the lifetime of the key, 0 never expires
+	TTLHours int `json:"ttlHours"`
+}
+
+type apiKeyListReq struct {
+	// All lists the keys of every user, admins only
+	All bool `json:"all"`
+}
+
+// routeV1ApiKey manages the keys partner scripts sign in with, keys are handled by signed in users and never by other keys
+func (p *Server) routeV1ApiKey(r *gin.RouterGroup) {
+
+	apiKeyRoute := r.Group("/apikey", p.requireUser())
+	apiKeyRoute.POST("/create", func(context *gin.Context) {
+		var req apiKeyCreateReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		created, err := p.userManager.CreateApiKey(contextUser(context).Username, req.Name, req.Scopes, time.Duration(req.TTLHours)*time.Hour)
+		if err != nil {
+			sendError(context, "create api key failed", err)
+			return
+		}
+
+		sendResponse(context, true, created)
+	})
+
+	apiKeyRoute.POST("/list", func(context *gin.Context) {
+		var req apiKeyListReq
+		_ = context.ShouldBindJSON(&req)
+
+		owner := contextUser(context).Username
+		if req.All {
+			admin, err := p.userManager.Allowed(contextPrincipal(context), userManager.AnyDataset, userManager.ActionAdmin)
+			if err != nil {
+				sendError(context, "check permission failed", err)
+				return
+			}
+			if !admin {
+				sendStatus(context, 403, "permission denied : missing "+userManager.Permission(userManager.AnyDataset, userManager.ActionAdmin))
+				return
+			}
+			owner = ""
+		}
+
+		list, err := p.userManager.ListApiKeys(owner)
+		if err != nil {
+			sendError(context, "list api keys failed", err)
+			return
+		}
+
+		sendResponse(context, true, list)
+	})
+
+	apiKeyRoute.POST("/revoke", func(context *gin.Context) {
+		var req idReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+		if !p.managedApiKey(context, req.Id) {
+			return
+		}
+
+		err = p.userManager.RevokeApiKey(req.Id)
+		if err != nil {
+			sendError(context, "revoke api key failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	apiKeyRoute.POST("/rotate", func(context *gin.Context) {
+		var req idReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+		if !p.managedApiKey(context, req.Id) {
+			return
+		}
+
+		rotated, err := p.userManager.RotateApiKey(req.Id)
+		if err != nil {
+			sendError(context, "rotate api key failed", err)
+			return
+		}
+
+		sendResponse(context, true, rotated)
+	})
+}
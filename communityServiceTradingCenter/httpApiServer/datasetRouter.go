@@ -0,0 +1,120 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"net/http"
+)
+
+const (
+	datasetContextKey     = "dataset"
+	datasetNameContextKey = "datasetName"
+)
+
+// datasetAliases keeps the paths used before the registry existed working
+var datasetAliases = map[string]string{
+	"publish":   "publisher",
+	"recommend": "recommender",
+}
+
+// reservedDatasetNames are the static groups under /api, a dataset with one of these names could not be routed
+var reservedDatasetNames = map[string]struct{}{
+	"dataset":        {},
+	"webhook":        {},
+	"recommendation": {},
+	"match":          {},
+	"user":           {},
+	"access":         {},
+	"apikey":         {},
+}
+
+func (p *Server) resolveDataset() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		name := context.Param("dataset")
+		if alias, ok := datasetAliases[name]; ok {
+			name = alias
+		}
+		dm, ok := p.registry.Get(name)
+		if !ok {
+			sendStatus(context, http.StatusNotFound, "unknown dataset : "+name)
+			return
+		}
+		context.Set(datasetContextKey, dm)
+		context.Set(datasetNameContextKey, name)
+		context.Next()
+	}
+}
+
+func contextDataset(context *gin.Context) *dataManager.Manager {
+	return context.MustGet(datasetContextKey).(*dataManager.Manager)
+}
+
+// contextDatasetName is the registry name, aliases already resolved
+func contextDatasetName(context *gin.Context) string {
+	return context.GetString(datasetNameContextKey)
+}
+
+func (p *Server) routeV1Dataset(r *gin.RouterGroup) {
+
+	datasetRoute := r.Group("/dataset")
+	datasetRoute.POST("/create", p.requirePermission(userManager.AnyDataset, userManager.ActionAdmin), func(context *gin.Context) {
+		var req dataManager.Dataset
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+		_, reserved := reservedDatasetNames[req.Name]
+		if _, alias := datasetAliases[req.Name]; reserved || alias {
+			sendStatus(context, http.StatusConflict, "reserved dataset name : "+req.Name)
+			return
+		}
+
+		dataset, err := p.registry.Create(req)
+		if err != nil {
+			sendError(context, "create failed", err)
+			return
+		}
+
+		sendResponse(context, true, dataset)
+	})
+
+	datasetRoute.POST("/list", p.requirePermission(userManager.AnyDataset, userManager.ActionQuery), func(context *gin.Context) {
+		sendResponse(context, true, p.registry.List())
+	})
+
+	datasetRoute.POST("/describe", p.requirePermission(userManager.AnyDataset, userManager.ActionQuery), func(context *gin.Context) {
+		var req nameReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		info, err := p.registry.Describe(req.Name)
+		if err != nil {
+			sendError(context, "describe failed", err)
+			return
+		}
+
+		sendResponse(context, true, info)
+	})
+
+	datasetRoute.POST("/drop", p.requirePermission(userManager.AnyDataset, userManager.ActionAdmin), func(context *gin.Context) {
+		var req nameReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		err = p.registry.Drop(req.Name)
+		if err != nil {
+			sendError(context, "drop failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+}
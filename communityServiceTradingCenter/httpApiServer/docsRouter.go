@@ -0,0 +1,23 @@
+package httpApiServer
+
+import (
+	_ "embed"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// routeV1Docs serves the openapi document of /v1/api and a page browsing it, neither needs credentials
+func (p *Server) routeV1Docs(r *gin.RouterGroup) {
+
+	docsRoute := r.Group("/docs")
+	docsRoute.GET("/", func(context *gin.Context) {
+		context.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+	})
+
+	docsRoute.GET("/openapi.json", func(context *gin.Context) {
+		context.Data(http.StatusOK, "application/json", p.openApiJson())
+	})
+}
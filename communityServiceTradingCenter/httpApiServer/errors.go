@@ -0,0 +1,136 @@
+package httpApiServer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/limitManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"net/http"
+)
+
+// The codes of the error envelope, the first ones are the kinds of the data layer and the others come from the api itself.
+// Codes are stable, clients branch on them rather than on messages.
+const (
+	codeNotFound           = string(badgerManager.CodeNotFound)
+	codeConflict           = string(badgerManager.CodeConflict)
+	codeValidation         = string(badgerManager.CodeValidation)
+	codeTooLarge           = string(badgerManager.CodeTooLarge)
+	codeUnavailable        = string(badgerManager.CodeUnavailable)
+	codeBadRequest         = "bad_request"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codePreconditionFailed = "precondition_failed"
+	codeRateLimited        = "rate_limited"
+	// codeInternal is an error without a kind, refused input always has one so this is a failure of the server
+	codeInternal = "internal"
+)
+
+var codeStatus = map[string]int{
+	codeNotFound:           http.StatusNotFound,
+	codeConflict:           http.StatusConflict,
+	codeValidation:         http.StatusUnprocessableEntity,
+	codeTooLarge:           http.StatusRequestEntityTooLarge,
+	codeUnavailable:        http.StatusServiceUnavailable,
+	codeBadRequest:         http.StatusBadRequest,
+	codeUnauthorized:       http.StatusUnauthorized,
+	codeForbidden:          http.StatusForbidden,
+	codePreconditionFailed: http.StatusPreconditionFailed,
+	codeRateLimited:        http.StatusTooManyRequests,
+	codeInternal:           http.StatusInternalServerError,
+}
+
+// apiError is the error of a failed response, data keeps the message for clients written before it
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// badRequest marks a request that could not be read, like a body that is not json
+type badRequest struct {
+	err error
+}
+
+func (e badRequest) Error() string {
+	return e.err.Error()
+}
+
+func (e badRequest) Unwrap() error {
+	return e.err
+}
+
+// errorCode is the code of err and the details the data layer attached to it
+func errorCode(err error) (string, any) {
+	var typed *badgerManager.Error
+	var request badRequest
+	switch {
+	case errors.As(err, &typed):
+		return string(typed.Code), typed.Details
+	case errors.As(err, &request):
+		return codeBadRequest, nil
+	case errors.Is(err, dataManager.ErrForbidden), errors.Is(err, userManager.ErrRegistrationClosed):
+		return codeForbidden, nil
+	case errors.Is(err, userManager.ErrInvalidCredentials), errors.Is(err, userManager.ErrInvalidSession),
+		errors.Is(err, userManager.ErrInvalidApiKey), errors.Is(err, userManager.ErrAccountLocked):
+		return codeUnauthorized, nil
+	case errors.Is(err, limitManager.ErrQuotaExceeded):
+		return codeRateLimited, nil
+	}
+	return codeInternal, nil
+}
+
+// statusCode is the code of an error status sent without an error value
+func statusCode(status int) string {
+	for code, s := range codeStatus {
+		if s == status {
+			return code
+		}
+	}
+	if status >= http.StatusInternalServerError {
+		return codeInternal
+	}
+	return codeBadRequest
+}
+
+func sendFailure(context *gin.Context, status int, e apiError, data any) {
+	resData, _ := json.Marshal(response{
+		Succeed: false,
+		Data:    data,
+		Error:   &e,
+	})
+	context.Data(status, "application/json", resData)
+	context.Abort()
+}
+
+// sendError answers a failed request with the status of the kind of err, data is "prefix : err" as it always was
+func sendError(context *gin.Context, prefix string, err error) {
+	message := err.Error()
+	if prefix != "" {
+		message = prefix + " : " + message
+	}
+	sendErrorData(context, message, err, message)
+}
+
+// sendErrorData is sendError for the handlers whose failures carry more than a message in data
+func sendErrorData(context *gin.Context, message string, err error, data any) {
+	code, details := errorCode(err)
+	sendFailure(context, codeStatus[code], apiError{
+		Code:    code,
+		Message: message,
+		Details: details,
+	}, data)
+}
+
+// sendStatus answers with an http error status, the body keeps the usual shape for clients reading succeed
+func sendStatus(context *gin.Context, status int, data any) {
+	e := apiError{Code: statusCode(status)}
+	if message, ok := data.(string); ok {
+		e.Message = message
+	} else {
+		e.Details = data
+	}
+	sendFailure(context, status, e, data)
+}
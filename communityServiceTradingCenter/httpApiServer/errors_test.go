@@ -0,0 +1,74 @@
+package httpApiServer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	send := func(f func(context *gin.Context)) (int, map[string]any) {
+		recorder := httptest.NewRecorder()
+		context, _ := gin.CreateTestContext(recorder)
+		f(context)
+		var body map[string]any
+		err := json.Unmarshal(recorder.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recorder.Code, body
+	}
+
+	for _, c := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{badgerManager.NewError(badgerManager.CodeNotFound, "key not found : a"), http.StatusNotFound, codeNotFound},
+		{badgerManager.NewError(badgerManager.CodeConflict, "dataset already exists : a"), http.StatusConflict, codeConflict},
+		{badgerManager.NewError(badgerManager.CodeValidation, "empty key"), http.StatusUnprocessableEntity, codeValidation},
+		{badgerManager.NewError(badgerManager.CodeTooLarge, "too big"), http.StatusRequestEntityTooLarge, codeTooLarge},
+		{badgerManager.NewError(badgerManager.CodeUnavailable, "closed"), http.StatusServiceUnavailable, codeUnavailable},
+		{badRequest{errors.New("invalid character")}, http.StatusBadRequest, codeBadRequest},
+		{dataManager.ErrForbidden, http.StatusForbidden, codeForbidden},
+		{errors.New("something else"), http.StatusInternalServerError, codeInternal},
+		{fmt.Errorf("register failed : %w", userManager.ErrRegistrationClosed), http.StatusForbidden, codeForbidden},
+		{badgerManager.Conflict("username already taken : a"), http.StatusConflict, codeConflict},
+	} {
+		status, body := send(func(context *gin.Context) {
+			sendError(context, "insert failed", c.err)
+		})
+		e, _ := body["error"].(map[string]any)
+		if status != c.status || e["code"] != c.code {
+			t.Fatal("unexpected status or code", c.err, status, body)
+		}
+		// clients written before the error object still read succeed and the message in data
+		if body["succeed"] != false || body["data"] != "insert failed : "+c.err.Error() || e["message"] != body["data"] {
+			t.Fatal("unexpected envelope", body)
+		}
+	}
+
+	status, body := send(func(context *gin.Context) {
+		err := badgerManager.NewError(badgerManager.CodeValidation, "batch rejected").WithDetails([]string{"a"})
+		sendErrorData(context, "insert failed : batch rejected", err, map[string]any{"committed": false})
+	})
+	e := body["error"].(map[string]any)
+	if status != http.StatusUnprocessableEntity || e["details"] == nil || body["data"].(map[string]any)["committed"] != false {
+		t.Fatal("details and data should both be sent", body)
+	}
+
+	status, body = send(func(context *gin.Context) {
+		sendStatus(context, http.StatusTooManyRequests, "too many requests")
+	})
+	if status != http.StatusTooManyRequests || body["error"].(map[string]any)["code"] != codeRateLimited {
+		t.Fatal("statuses should get their code", body)
+	}
+}
@@ -0,0 +1,75 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"math"
+	"moonlighting/communityServiceTradingCenter/limitManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"strconv"
+	"time"
+)
+
+const (
+	retryAfterHeader     = "Retry-After"
+	quotaRemainingHeader = "X-Quota-Remaining"
+)
+
+// sendRetryAfter answers 429, Retry-After is whole seconds rounded up
+func sendRetryAfter(context *gin.Context, wait time.Duration, data any) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	context.Header(retryAfterHeader, strconv.Itoa(seconds))
+	sendStatus(context, 429, data)
+}
+
+// rateLimit takes a token of the bucket the route rule gives the caller, it runs after authenticate to know the principal
+func (p *Server) rateLimit() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		ok, wait := p.limitManager.Allow(context.FullPath(), context.ClientIP(), contextPrincipal(context))
+		if !ok {
+			sendRetryAfter(context, wait, "too many requests : retry after "+wait.Round(time.Millisecond).String())
+			return
+		}
+		context.Next()
+	}
+}
+
+// chargeFailedAuth takes a token of the ip bucket for a request refused for bad credentials, as if it were anonymous,
+// so guessing tokens or keys is rate limited too. It answers 429 itself and returns false when the bucket is empty.
+func (p *Server) chargeFailedAuth(context *gin.Context) bool {
+	ok, wait := p.limitManager.Allow(context.FullPath(), context.ClientIP(), userManager.PrincipalAnonymous)
+	if !ok {
+		sendRetryAfter(context, wait, "too many requests : retry after "+wait.Round(time.Millisecond).String())
+	}
+	return ok
+}
+
+// quotaWait is the time until the quota resets, 0 once the reset time has passed but the quota was read before it
+func quotaWait(quota limitManager.Quota, nowMs uint64) time.Duration {
+	if quota.ResetMs <= nowMs {
+		return 0
+	}
+	return time.Duration(quota.ResetMs-nowMs) * time.Millisecond
+}
+
+// writeQuota counts a write request of the principal for today, requests refused later by the handler still count
+func (p *Server) writeQuota() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		quota, err := p.limitManager.ConsumeWrite(contextPrincipal(context))
+		if err == limitManager.ErrQuotaExceeded {
+			wait := quotaWait(quota, uint64(time.Now().UnixMilli()))
+			sendRetryAfter(context, wait, "too many requests : "+err.Error()+", "+strconv.FormatUint(quota.Limit, 10)+" writes per day")
+			return
+		}
+		if err != nil {
+			sendError(context, "check quota failed", err)
+			return
+		}
+		if quota.Limit > 0 {
+			context.Header(quotaRemainingHeader, strconv.FormatUint(quota.Limit-quota.Used, 10))
+		}
+		context.Next()
+	}
+}
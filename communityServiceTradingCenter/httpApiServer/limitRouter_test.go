@@ -0,0 +1,50 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/limitManager"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIPBehindProxies(t *testing.T) {
+	clientIP := func(p *Server) string {
+		r := p.route()
+		r.GET("/ip", func(context *gin.Context) {
+			context.String(http.StatusOK, context.ClientIP())
+		})
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = "10.0.0.1:4321"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		return recorder.Body.String()
+	}
+
+	p := &Server{}
+	if ip := clientIP(p); ip != "10.0.0.1" {
+		t.Fatal("forwarded ip should be ignored without trusted proxies", ip)
+	}
+	if p.SetOptions(Options{TrustedProxies: []string{"proxy"}}) == nil {
+		t.Fatal("invalid proxy should be rejected")
+	}
+	err := p.SetOptions(Options{TrustedProxies: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := clientIP(p); ip != "203.0.113.7" {
+		t.Fatal("forwarded ip of a trusted proxy should be used", ip)
+	}
+}
+
+func TestQuotaWait(t *testing.T) {
+	quota := limitManager.Quota{ResetMs: 10_000}
+	if wait := quotaWait(quota, 7_500); wait != 2500*time.Millisecond {
+		t.Fatal("unexpected wait", wait)
+	}
+	if wait := quotaWait(quota, 12_000); wait != 0 {
+		t.Fatal("passed reset time should not wait", wait)
+	}
+}
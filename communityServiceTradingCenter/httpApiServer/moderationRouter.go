@@ -0,0 +1,101 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+)
+
+type moderationQueueReq struct {
+	Status   string `json:"status"`
+	Reviewer string `json:"reviewer"`
+}
+
+type moderationAssignReq struct {
+	KeyList  []string `json:"keyList"`
+	Reviewer string   `json:"reviewer"`
+}
+
+type keyReq struct {
+	Key string `json:"key"`
+}
+
+// routeV1Moderation is nested in the dataset group, so every path starts with /:dataset/moderation
+func (p *Server) routeV1Moderation(datasetRoute *gin.RouterGroup) {
+
+	moderationRoute := datasetRoute.Group("/moderation")
+	moderationRoute.POST("/queue", p.requirePermission("", userManager.ActionModerate), func(context *gin.Context) {
+		var req moderationQueueReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		list, err := contextDataset(context).ModerationQueue(req.Status, req.Reviewer)
+		if err != nil {
+			sendError(context, "list queue failed", err)
+			return
+		}
+
+		resMap := make(map[string]any)
+
+		resMap["count"] = len(list)
+		resMap["queueList"] = list
+
+		sendResponse(context, true, resMap)
+	})
+
+	moderationRoute.POST("/assign", p.requirePermission("", userManager.ActionModerate), func(context *gin.Context) {
+		var req moderationAssignReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		err = contextDataset(context).Assign(req.KeyList, req.Reviewer)
+		if err != nil {
+			sendError(context, "assign failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	moderationRoute.POST("/decide", p.requirePermission("", userManager.ActionModerate), func(context *gin.Context) {
+		var req dataManager.ModerationDecision
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+		// decisions are recorded under the caller, whatever the body says
+		req.Reviewer = contextActor(context)
+
+		err = contextDataset(context).Decide(req)
+		if err != nil {
+			sendError(context, "decide failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	moderationRoute.POST("/history", p.requirePermission("", userManager.ActionModerate), func(context *gin.Context) {
+		var req keyReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		moderation, err := contextDataset(context).ModerationHistory(req.Key)
+		if err != nil {
+			sendError(context, "get history failed", err)
+			return
+		}
+
+		sendResponse(context, true, moderation)
+	})
+}
@@ -0,0 +1,559 @@
+package httpApiServer
+
+import (
+	"encoding/json"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/matchManager"
+	"moonlighting/communityServiceTradingCenter/recommendManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"moonlighting/communityServiceTradingCenter/webhookManager"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	openApiVersion = "3.0.3"
+	apiVersion     = "1.0.0"
+
+	tagUser           = "user"
+	tagAccess         = "access"
+	tagApiKey         = "apikey"
+	tagWebhook        = "webhook"
+	tagRecommendation = "recommendation"
+	tagMatch          = "match"
+	tagDatasetAdmin   = "dataset"
+	tagRecord         = "record"
+	tagModeration     = "moderation"
+	tagOwnership      = "ownership"
+
+	// datasetPermission is followed by the action, the dataset is the one in the path
+	datasetPermission = "<dataset>:"
+)
+
+// apiParam is a query string parameter
+type apiParam struct {
+	Name        string
+	Description string
+	Type        string
+}
+
+// apiOperation documents one route. Request and Response are zero values of the body and of the data field
+// of the response envelope, nil when there is none. Request is the type the handler binds, never a copy of its shape.
+type apiOperation struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	// Permission is what requirePermission checks, <dataset> is the dataset of the path
+	Permission string
+	// SignedIn routes need a session token, api keys are not enough
+	SignedIn bool
+	Query    []apiParam
+	Headers  []apiParam
+	Request  any
+	// RequestType replaces the json body, the body is read as a stream of that type
+	RequestType string
+	Response    any
+	// ResponseType replaces the json envelope of a successful response
+	ResponseType string
+	// Statuses are answered besides 200, 401, 403 and 429. 201 carries the response envelope, 204 and 304 no body.
+	Statuses []int
+	// NoContent replaces the 200 response with 204
+	NoContent bool
+}
+
+type nameReq struct {
+	Name string `json:"name"`
+}
+
+type keyListReq struct {
+	KeyList []string `json:"keyList"`
+}
+
+type idReq struct {
+	Id string `json:"id"`
+}
+
+type committedRes struct {
+	Committed bool                       `json:"committed"`
+	Message   string                     `json:"message,omitempty"`
+	Results   []dataManager.InsertResult `json:"results"`
+}
+
+type queryRes struct {
+	Count      int                  `json:"count"`
+	TotalCount int                  `json:"totalCount"`
+	QueryList  []dataManager.Record `json:"queryList"`
+}
+
+var recordQueryParams = []apiParam{
+	{Name: "limit", Description: "records per page", Type: "integer"},
+	{Name: "page", Description: "page number starting at 1", Type: "integer"},
+	{Name: "fields", Description: "comma separated value fields to return", Type: "string"},
+	{Name: "excludeFields", Description: "comma separated value fields to leave out", Type: "string"},
+	{Name: "previewLength", Description: "truncate long values to this many characters", Type: "integer"},
+	{Name: "previewFields", Description: "comma separated fields to truncate", Type: "string"},
+	{Name: "states", Description: "comma separated lifecycle states, * for all", Type: "string"},
+	{Name: "expand", Description: "comma separated reference fields to embed, needs query permission on the referenced datasets", Type: "string"},
+	{Name: "near", Description: "lat,lng,radiusKm", Type: "string"},
+	{Name: "within", Description: "minLat,minLng,maxLat,maxLng", Type: "string"},
+	{Name: "sortByDistance", Description: "order by distance to near", Type: "boolean"},
+	{Name: "noCache", Description: "skip the query cache", Type: "boolean"},
+	{Name: "owner", Description: "records this principal owns or edits", Type: "string"},
+	{Name: "mine", Description: "records of the caller", Type: "boolean"},
+}
+
+var transferParams = []apiParam{
+	{Name: "format", Description: "csv or ndjson", Type: "string"},
+	{Name: "keyColumn", Description: "column holding the record key", Type: "string"},
+	{Name: "priorityColumn", Description: "column holding the record priority", Type: "string"},
+}
+
+// apiOperations lists every route under /v1/api, TestOpenApiCoversRoutes fails when one is missing
+var apiOperations = []apiOperation{
+	{Method: http.MethodPost, Path: "/v1/api/user/register", Tag: tagUser, Summary: "Create an account when registration is open",
+		Request: credentials{}, Response: userManager.User{}},
+	{Method: http.MethodPost, Path: "/v1/api/user/login", Tag: tagUser, Summary: "Open a session, the token goes in the Authorization header as Bearer <token>",
+		Request: credentials{}, Response: userManager.Session{}},
+	{Method: http.MethodPost, Path: "/v1/api/user/logout", Tag: tagUser, Summary: "Close this session or, with everywhere, every session of the user", SignedIn: true,
+		Request: logoutReq{}},
+	{Method: http.MethodPost, Path: "/v1/api/user/me", Tag: tagUser, Summary: "The signed in user", SignedIn: true,
+		Response: userManager.User{}},
+
+	{Method: http.MethodPost, Path: "/v1/api/access/role/list", Tag: tagAccess, Summary: "List roles", Permission: "*:admin",
+		Response: []userManager.Role{}},
+	{Method: http.MethodPost, Path: "/v1/api/access/role/set", Tag: tagAccess, Summary: "Create or replace a role", Permission: "*:admin",
+		Request: userManager.Role{}},
+	{Method: http.MethodPost, Path: "/v1/api/access/role/delete", Tag: tagAccess, Summary: "Delete a role no principal is bound to", Permission: "*:admin",
+		Request: nameReq{}},
+	{Method: http.MethodPost, Path: "/v1/api/access/binding/list", Tag: tagAccess, Summary: "List role bindings", Permission: "*:admin",
+		Response: []userManager.Binding{}},
+	{Method: http.MethodPost, Path: "/v1/api/access/binding/get", Tag: tagAccess, Summary: "Roles of a principal", Permission: "*:admin",
+		Request: principalReq{}, Response: userManager.Binding{}},
+	{Method: http.MethodPost, Path: "/v1/api/access/binding/set", Tag: tagAccess, Summary: "Replace the roles of a principal, an empty list removes the binding", Permission: "*:admin",
+		Request: userManager.Binding{}},
+
+	{Method: http.MethodPost, Path: "/v1/api/apikey/create", Tag: tagApiKey, Summary: "Issue an api key, the key is only returned here", SignedIn: true,
+		Request: apiKeyCreateReq{}, Response: userManager.CreatedApiKey{}},
+	{Method: http.MethodPost, Path: "/v1/api/apikey/list", Tag: tagApiKey, Summary: "List own api keys or, for admins with all, every key", SignedIn: true,
+		Request: apiKeyListReq{}, Response: []userManager.ApiKey{}},
+	{Method: http.MethodPost, Path: "/v1/api/apikey/revoke", Tag: tagApiKey, Summary: "Disable an api key for good", SignedIn: true,
+		Request: idReq{}},
+	{Method: http.MethodPost, Path: "/v1/api/apikey/rotate", Tag: tagApiKey, Summary: "Replace the secret of an api key", SignedIn: true,
+		Request: idReq{}, Response: userManager.CreatedApiKey{}},
+
+	{Method: http.MethodPost, Path: "/v1/api/webhook/register", Tag: tagWebhook, Summary: "Register a webhook, events follow what queries list so a record that becomes visible is an insert and one that stops being visible is a delete", Permission: "*:admin",
+		Request: webhookManager.Webhook{}, Response: webhookManager.Webhook{}},
+	{Method: http.MethodPost, Path: "/v1/api/webhook/list", Tag: tagWebhook, Summary: "List webhooks, of one dataset when given", Permission: "*:admin",
+		Request: webhookListReq{}, Response: []webhookManager.Webhook{}},
+	{Method: http.MethodPost, Path: "/v1/api/webhook/delete", Tag: tagWebhook, Summary: "Remove a webhook", Permission: "*:admin",
+		Request: idReq{}},
+	{Method: http.MethodPost, Path: "/v1/api/webhook/deliveries", Tag: tagWebhook, Summary: "Latest deliveries of a webhook", Permission: "*:admin",
+		Request: deliveriesReq{}, Response: []webhookManager.Delivery{}},
+
+	{Method: http.MethodPost, Path: "/v1/api/recommendation/signal", Tag: tagRecommendation, Summary: "Record an interaction with an item",
+		Request: recommendManager.Signal{}},
+	{Method: http.MethodPost, Path: "/v1/api/recommendation/preview", Tag: tagRecommendation, Summary: "Compute the recommendations without storing them", Permission: "*:admin",
+		Response: recommendManager.Plan{}},
+	{Method: http.MethodPost, Path: "/v1/api/recommendation/run", Tag: tagRecommendation, Summary: "Compute and store the recommendations now", Permission: "*:admin",
+		Response: recommendManager.Plan{}},
+	{Method: http.MethodPost, Path: "/v1/api/recommendation/override/set", Tag: tagRecommendation, Summary: "Pin or exclude an item in a scope", Permission: "*:admin",
+		Request: recommendManager.Override{}},
+	{Method: http.MethodPost, Path: "/v1/api/recommendation/override/list", Tag: tagRecommendation, Summary: "List overrides, of one scope when given", Permission: "*:admin",
+		Request: overrideListReq{}, Response: []recommendManager.Override{}},
+	{Method: http.MethodPost, Path: "/v1/api/recommendation/override/delete", Tag: tagRecommendation, Summary: "Remove an override", Permission: "*:admin",
+		Request: overrideDeleteReq{}},
+
+	{Method: http.MethodPost, Path: "/v1/api/match", Tag: tagMatch, Summary: "Rank providers for a publisher record", Permission: "provider:query, publisher:query",
+		Request: matchManager.MatchRequest{}, Response: struct {
+			Count      int                      `json:"count"`
+			Candidates []matchManager.Candidate `json:"candidates"`
+		}{}},
+
+	{Method: http.MethodPost, Path: "/v1/api/dataset/create", Tag: tagDatasetAdmin, Summary: "Create a dataset", Permission: "*:admin",
+		Request: dataManager.Dataset{}, Response: dataManager.Dataset{}},
+	{Method: http.MethodPost, Path: "/v1/api/dataset/list", Tag: tagDatasetAdmin, Summary: "List datasets", Permission: "*:query",
+		Response: []dataManager.Dataset{}},
+	{Method: http.MethodPost, Path: "/v1/api/dataset/describe", Tag: tagDatasetAdmin, Summary: "Options and size of a dataset", Permission: "*:query",
+		Request: nameReq{}, Response: dataManager.DatasetInfo{}},
+	{Method: http.MethodPost, Path: "/v1/api/dataset/drop", Tag: tagDatasetAdmin, Summary: "Drop a dataset and its records", Permission: "*:admin",
+		Request: nameReq{}},
+
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/query", Tag: tagRecord, Summary: "Query records, mine limits them to the records of the caller", Permission: datasetPermission + userManager.ActionQuery,
+		Request: queryReq{}, Response: queryRes{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/insert", Tag: tagRecord, Summary: "Insert, update or merge records, counted in the daily write quota", Permission: datasetPermission + userManager.ActionInsert,
+		Request: insertReq{}, Response: committedRes{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/delete", Tag: tagRecord, Summary: "Delete records", Permission: datasetPermission + userManager.ActionDelete,
+		Request: keyListReq{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/patch", Tag: tagRecord, Summary: "Change some fields of records", Permission: datasetPermission + userManager.ActionInsert,
+		Request: patchReq{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/transition", Tag: tagRecord, Summary: "Move records to another lifecycle state", Permission: datasetPermission + userManager.ActionInsert,
+		Request: transitionReq{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/import", Tag: tagRecord, Summary: "Import a csv or ndjson body", Permission: datasetPermission + userManager.ActionInsert,
+		Query: append(append([]apiParam{}, transferParams...),
+			apiParam{Name: "map", Description: "column=field, repeated", Type: "string"},
+			apiParam{Name: "mode", Description: "write mode of the records", Type: "string"},
+			apiParam{Name: "dryRun", Description: "validate only", Type: "boolean"},
+			apiParam{Name: "bestEffort", Description: "keep the valid records when others fail", Type: "boolean"},
+		),
+		RequestType: "text/csv", Response: dataManager.ImportReport{}},
+	{Method: http.MethodGet, Path: "/v1/api/:dataset/export", Tag: tagRecord, Summary: "Stream the records as csv or ndjson", Permission: datasetPermission + userManager.ActionQuery,
+		Query: append(append([]apiParam{}, transferParams...),
+			apiParam{Name: "columns", Description: "comma separated value fields", Type: "string"},
+			apiParam{Name: "includeUnlisted", Description: "export records waiting for or refused by moderation too, needs <dataset>:moderate", Type: "boolean"},
+			apiParam{Name: "allStates", Description: "export records of every lifecycle state, not only the visible ones, needs <dataset>:moderate", Type: "boolean"},
+		),
+		ResponseType: "text/csv"},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/cacheStats", Tag: tagRecord, Summary: "Query cache counters", Permission: datasetPermission + userManager.ActionAdmin,
+		Response: dataManager.CacheStats{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/duplicates", Tag: tagModeration, Summary: "Clusters of records that look alike", Permission: datasetPermission + userManager.ActionModerate,
+		Response: []dataManager.DuplicateCluster{}},
+
+	{Method: http.MethodGet, Path: "/v1/api/:dataset/records", Tag: tagRecord, Summary: "Query records, match.<field>=<regex> parameters filter like one match rule", Permission: datasetPermission + userManager.ActionQuery,
+		Query: recordQueryParams, Headers: []apiParam{{Name: "If-None-Match", Description: "ETag of a previous response", Type: "string"}},
+		Response: queryRes{}, Statuses: []int{http.StatusNotModified, http.StatusBadRequest}},
+	{Method: http.MethodGet, Path: "/v1/api/:dataset/records/:key", Tag: tagRecord, Summary: "A record the query parameters would list", Permission: datasetPermission + userManager.ActionQuery,
+		Query: recordQueryParams, Headers: []apiParam{{Name: "If-None-Match", Description: "ETag of a previous response", Type: "string"}},
+		Response: dataManager.Record{}, Statuses: []int{http.StatusNotModified, http.StatusBadRequest, http.StatusNotFound}},
+	{Method: http.MethodPut, Path: "/v1/api/:dataset/records/:key", Tag: tagRecord, Summary: "Store a record, 201 when it is created", Permission: datasetPermission + userManager.ActionInsert,
+		Headers: []apiParam{
+			{Name: "If-None-Match", Description: "* only creates the record", Type: "string"},
+			{Name: "If-Match", Description: "* only replaces an existing record, ETags of GET /records/{key} replace it only while unchanged, weak ETags of responses with expand never match", Type: "string"},
+		},
+		Request: dataManager.Data{}, Response: dataManager.InsertResult{},
+		Statuses: []int{http.StatusCreated, http.StatusBadRequest, http.StatusConflict, http.StatusPreconditionFailed, http.StatusUnprocessableEntity}},
+	{Method: http.MethodPatch, Path: "/v1/api/:dataset/records/:key", Tag: tagRecord, Summary: "Change some fields of a record, the key comes from the path", Permission: datasetPermission + userManager.ActionInsert,
+		Request: dataManager.Patch{}, Response: dataManager.Data{},
+		Statuses: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity}},
+	{Method: http.MethodDelete, Path: "/v1/api/:dataset/records/:key", Tag: tagRecord, Summary: "Delete a record", Permission: datasetPermission + userManager.ActionDelete,
+		NoContent: true, Statuses: []int{http.StatusNotFound}},
+
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/moderation/queue", Tag: tagModeration, Summary: "Records waiting for a decision", Permission: datasetPermission + userManager.ActionModerate,
+		Request: moderationQueueReq{}, Response: struct {
+			Count     int                `json:"count"`
+			QueueList []dataManager.Data `json:"queueList"`
+		}{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/moderation/assign", Tag: tagModeration, Summary: "Hand records to a reviewer", Permission: datasetPermission + userManager.ActionModerate,
+		Request: moderationAssignReq{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/moderation/decide", Tag: tagModeration, Summary: "Approve, reject or send back a record, the reviewer is the caller", Permission: datasetPermission + userManager.ActionModerate,
+		Request: dataManager.ModerationDecision{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/moderation/history", Tag: tagModeration, Summary: "Moderation state and history of a record", Permission: datasetPermission + userManager.ActionModerate,
+		Request: keyReq{}, Response: dataManager.Moderation{}},
+
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/ownership/transfer", Tag: tagOwnership, Summary: "Hand records to another owner", Permission: datasetPermission + userManager.ActionInsert,
+		Request: ownershipTransferReq{}},
+	{Method: http.MethodPost, Path: "/v1/api/:dataset/ownership/editors", Tag: tagOwnership, Summary: "Replace the editors of a record", Permission: datasetPermission + userManager.ActionInsert,
+		Request: editorsReq{}},
+}
+
+// openApiPath turns the gin path params into openapi ones, /:dataset/query is /{dataset}/query
+func openApiPath(ginPath string) string {
+	parts := strings.Split(ginPath, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+var localPkgPath = reflect.TypeOf(response{}).PkgPath()
+
+// schemaBuilder turns go types into json schemas, named structs go to the components once
+type schemaBuilder struct {
+	components map[string]any
+}
+
+func refTo(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func schemaName(t reflect.Type) string {
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
+
+func (b *schemaBuilder) schemaOf(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		res := b.schemaOf(t.Elem())
+		if _, ok := res["$ref"]; ok {
+			// siblings of $ref are ignored, a nullable reference needs the allOf wrapper
+			return map[string]any{"allOf": []any{res}, "nullable": true}
+		}
+		res["nullable"] = true
+		return res
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schemaOf(t.Elem())}
+	case reflect.Struct:
+		// the request helpers of this package only share a shape, they are not api types
+		if t.Name() == "" || t.PkgPath() == localPkgPath {
+			return b.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := b.components[name]; !ok {
+			// the placeholder stops recursive types from looping
+			b.components[name] = map[string]any{}
+			b.components[name] = b.structSchema(t)
+		}
+		return refTo(name)
+	default:
+		return map[string]any{}
+	}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	b.addFields(t, properties)
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// addFields follows encoding/json, fields of untagged embedded structs belong to the outer object
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				b.addFields(embedded, properties)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schemaOf(field.Type)
+	}
+}
+
+func envelope(data map[string]any) map[string]any {
+	return map[string]any{
+		"allOf": []any{
+			refTo("Response"),
+			map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"data": data},
+			},
+		},
+	}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+func errorResponse(description string) map[string]any {
+	return map[string]any{"description": description, "content": jsonContent(refTo("Response"))}
+}
+
+// operationId is the method and the path in camel case, POST /v1/api/:dataset/query is postDatasetQuery
+func (o apiOperation) operationId() string {
+	res := strings.ToLower(o.Method)
+	for _, part := range strings.Split(strings.TrimPrefix(o.Path, "/v1/api/"), "/") {
+		part = strings.TrimPrefix(part, ":")
+		if part != "" {
+			res += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return res
+}
+
+func (o apiOperation) spec(b *schemaBuilder) map[string]any {
+	res := map[string]any{
+		"tags":        []string{o.Tag},
+		"summary":     o.Summary,
+		"operationId": o.operationId(),
+	}
+	description := "Failures answer 200 with succeed false unless a status is listed."
+	if o.Permission != "" {
+		description = "Requires " + o.Permission + ". " + description
+	}
+	if o.SignedIn {
+		description = "Requires a session token. " + description
+	}
+	res["description"] = description
+
+	params := make([]any, 0)
+	for _, part := range strings.Split(o.Path, "/") {
+		if strings.HasPrefix(part, ":") {
+			params = append(params, map[string]any{
+				"name": part[1:], "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+			})
+		}
+	}
+	for _, q := range o.Query {
+		params = append(params, map[string]any{
+			"name": q.Name, "in": "query", "description": q.Description, "schema": map[string]any{"type": q.Type},
+		})
+	}
+	for _, h := range o.Headers {
+		params = append(params, map[string]any{
+			"name": h.Name, "in": "header", "description": h.Description, "schema": map[string]any{"type": h.Type},
+		})
+	}
+	if len(params) > 0 {
+		res["parameters"] = params
+	}
+
+	if o.RequestType != "" {
+		res["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				o.RequestType:          map[string]any{"schema": map[string]any{"type": "string"}},
+				"application/x-ndjson": map[string]any{"schema": map[string]any{"type": "string"}},
+			},
+		}
+	} else if o.Request != nil {
+		res["requestBody"] = map[string]any{
+			"required": true,
+			"content":  jsonContent(b.schemaOf(reflect.TypeOf(o.Request))),
+		}
+	}
+
+	responses := map[string]any{}
+	if o.ResponseType != "" {
+		responses["200"] = map[string]any{
+			"description": "the records as a file",
+			"content": map[string]any{
+				o.ResponseType:         map[string]any{"schema": map[string]any{"type": "string"}},
+				"application/x-ndjson": map[string]any{"schema": map[string]any{"type": "string"}},
+			},
+		}
+	} else if o.NoContent {
+		responses["204"] = map[string]any{"description": http.StatusText(http.StatusNoContent)}
+	} else {
+		data := map[string]any{"nullable": true}
+		if o.Response != nil {
+			data = b.schemaOf(reflect.TypeOf(o.Response))
+		}
+		responses["200"] = map[string]any{"description": "the response envelope", "content": jsonContent(envelope(data))}
+	}
+	for _, status := range o.Statuses {
+		code := strconv.Itoa(status)
+		switch status {
+		case http.StatusCreated:
+			responses[code] = map[string]any{"description": http.StatusText(status), "content": responses["200"].(map[string]any)["content"]}
+		case http.StatusNoContent, http.StatusNotModified:
+			responses[code] = map[string]any{"description": http.StatusText(status)}
+		default:
+			responses[code] = errorResponse(http.StatusText(status))
+		}
+	}
+	responses["401"] = map[string]any{"$ref": "#/components/responses/Unauthorized"}
+	responses["default"] = map[string]any{"$ref": "#/components/responses/Error"}
+	responses["429"] = map[string]any{"$ref": "#/components/responses/TooManyRequests"}
+	if o.Permission != "" || o.SignedIn {
+		responses["403"] = map[string]any{"$ref": "#/components/responses/Forbidden"}
+	}
+	res["responses"] = responses
+	return res
+}
+
+// errorCodes lists the codes of the error envelope for the document
+func errorCodes() []string {
+	res := make([]string, 0, len(codeStatus))
+	for code := range codeStatus {
+		res = append(res, code)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// buildOpenApi describes operations as an openapi 3 document
+func buildOpenApi(operations []apiOperation) map[string]any {
+	b := &schemaBuilder{components: map[string]any{
+		"Response": map[string]any{
+			"type":     "object",
+			"required": []string{"succeed", "data"},
+			"properties": map[string]any{
+				"succeed": map[string]any{"type": "boolean"},
+				"data":    map[string]any{"description": "the result, or the error message when succeed is false"},
+				"error":   refTo("Error"),
+			},
+		},
+		"Error": map[string]any{
+			"type":        "object",
+			"description": "set when succeed is false",
+			"required":    []string{"code", "message"},
+			"properties": map[string]any{
+				"code":    map[string]any{"type": "string", "enum": errorCodes()},
+				"message": map[string]any{"type": "string"},
+				"details": map[string]any{"description": "more about the failure, like the results of a rejected batch"},
+			},
+		},
+	}}
+	paths := make(map[string]any)
+	for _, o := range operations {
+		p := openApiPath(o.Path)
+		item, ok := paths[p].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[p] = item
+		}
+		item[strings.ToLower(o.Method)] = o.spec(b)
+	}
+
+	retryAfter := map[string]any{
+		"Retry-After": map[string]any{"description": "seconds to wait", "schema": map[string]any{"type": "integer"}},
+	}
+	return map[string]any{
+		"openapi": openApiVersion,
+		"info": map[string]any{
+			"title":       "Community service trading center",
+			"version":     apiVersion,
+			"description": "Every json response is the envelope {succeed, data}, failures add error {code, message, details} and answer the http status of the code. Send a session token as Authorization: Bearer <token> or an api key as " + apiKeyHeader + ", requests without either run as anonymous.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"responses": map[string]any{
+				"Error":           errorResponse("the request failed, error.code tells why"),
+				"Unauthorized":    errorResponse("the credentials are invalid or missing"),
+				"Forbidden":       errorResponse("the caller misses the permission"),
+				"TooManyRequests": map[string]any{"description": "rate limit or daily write quota reached", "headers": retryAfter, "content": jsonContent(refTo("Response"))},
+			},
+			"securitySchemes": map[string]any{
+				"session": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey":  map[string]any{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+		"security": []any{map[string]any{}, map[string]any{"session": []string{}}, map[string]any{"apiKey": []string{}}},
+	}
+}
+
+// openApiJson is built on first use, the operations never change while running
+func (p *Server) openApiJson() []byte {
+	p.openApiOnce.Do(func() {
+		p.openApi, _ = json.MarshalIndent(buildOpenApi(apiOperations), "", "  ")
+	})
+	return p.openApi
+}
@@ -0,0 +1,167 @@
+package httpApiServer
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// undocumentedPrefixes serve files rather than the api
+var undocumentedPrefixes = []string{"/v1/static/", "/v1/docs/"}
+
+func TestOpenApiCoversRoutes(t *testing.T) {
+	p := &Server{}
+	var spec map[string]any
+	err := json.Unmarshal(p.openApiJson(), &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := spec["paths"].(map[string]any)
+
+	registered := make(map[string]struct{})
+	for _, route := range p.route().Routes() {
+		skip := false
+		for _, prefix := range undocumentedPrefixes {
+			skip = skip || strings.HasPrefix(route.Path, prefix)
+		}
+		if skip {
+			continue
+		}
+		method := strings.ToLower(route.Method)
+		registered[method+" "+route.Path] = struct{}{}
+		item, ok := paths[openApiPath(route.Path)].(map[string]any)
+		if !ok || item[method] == nil {
+			t.Error("route missing from the openapi document, add it to apiOperations :", route.Method, route.Path)
+		}
+	}
+	for _, o := range apiOperations {
+		if _, ok := registered[strings.ToLower(o.Method)+" "+o.Path]; !ok {
+			t.Error("documented route is not registered :", o.Method, o.Path)
+		}
+	}
+
+	// every reference points at a component
+	schemas := spec["components"].(map[string]any)["schemas"].(map[string]any)
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok && strings.HasPrefix(ref, "#/components/schemas/") {
+				if _, ok := schemas[strings.TrimPrefix(ref, "#/components/schemas/")]; !ok {
+					t.Error("dangling reference :", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(spec)
+
+	// the query body keeps the fields of dataManager.Query next to mine
+	query := paths["/v1/api/{dataset}/query"].(map[string]any)["post"].(map[string]any)
+	body := query["requestBody"].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+	properties := body["properties"].(map[string]any)
+	if properties["mine"] == nil || properties["owner"] == nil {
+		t.Fatal("embedded fields should be flattened", properties)
+	}
+	insert := schemas["dataManager.InsertOption"]
+	if insert != nil {
+		t.Fatal("embedded structs should not become components")
+	}
+}
+
+// TestOpenApiRequestsAreBound parses the handlers, every json body they bind has to be the Request of an operation,
+// so the documented schema is reflected from the very type the handler reads
+func TestOpenApiRequestsAreBound(t *testing.T) {
+	documented := make(map[string]struct{})
+	for _, o := range apiOperations {
+		if o.Request == nil {
+			continue
+		}
+		rt := reflect.TypeOf(o.Request)
+		name := rt.Name()
+		if rt.PkgPath() != localPkgPath {
+			name = path.Base(rt.PkgPath()) + "." + name
+		}
+		if rt.Name() == "" {
+			t.Error("request of", o.Method, o.Path, "should be the named type its handler binds")
+		}
+		documented[name] = struct{}{}
+	}
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound := 0
+	for _, pkg := range pkgs {
+		ast.Inspect(pkg, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 1 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "BindJSON" && sel.Sel.Name != "ShouldBindJSON") {
+				return true
+			}
+			position := fset.Position(call.Pos())
+			var ident *ast.Ident
+			if arg, ok := call.Args[0].(*ast.UnaryExpr); ok {
+				ident, _ = arg.X.(*ast.Ident)
+			}
+			if ident == nil || ident.Obj == nil {
+				t.Error(position, "binds something else than a local variable")
+				return true
+			}
+			spec, ok := ident.Obj.Decl.(*ast.ValueSpec)
+			if !ok || spec.Type == nil {
+				t.Error(position, "binds a variable without a declared type")
+				return true
+			}
+			bound += 1
+			name := types.ExprString(spec.Type)
+			if _, ok := documented[name]; !ok {
+				t.Error(position, "binds", name, "which no operation documents as its Request")
+			}
+			return true
+		})
+	}
+	if bound == 0 {
+		t.Fatal("no handler found")
+	}
+}
+
+// TestRecordQueryParamsCoverQuery keeps the query string of GET /records in step with the query body
+func TestRecordQueryParamsCoverQuery(t *testing.T) {
+	params := make(map[string]struct{})
+	for _, param := range recordQueryParams {
+		params[param.Name] = struct{}{}
+	}
+	rt := reflect.TypeOf(dataManager.Query{})
+	for i := 0; i < rt.NumField(); i++ {
+		name := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+		// match rules are the match.<field> parameters
+		if name == "matchRules" {
+			continue
+		}
+		if _, ok := params[name]; !ok {
+			t.Error("query field", name, "is missing from recordQueryParams and queryFromValues")
+		}
+	}
+}
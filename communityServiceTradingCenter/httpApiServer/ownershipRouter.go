@@ -0,0 +1,83 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+)
+
+// contextWriter is the actor of a write on the dataset in the path, admins of the dataset may change any record.
+// Anonymous writers are nobody in particular, so their records get no owner and are left to admins.
+// It answers the request itself and returns false when the check fails.
+func (p *Server) contextWriter(context *gin.Context) (*dataManager.Actor, bool) {
+	principal := contextPrincipal(context)
+	admin, err := p.userManager.Allowed(principal, contextDatasetName(context), userManager.ActionAdmin)
+	if err != nil {
+		sendError(context, "check permission failed", err)
+		return nil, false
+	}
+	if principal == userManager.PrincipalAnonymous {
+		principal = ""
+	}
+	return &dataManager.Actor{
+		Principal: principal,
+		Admin:     admin,
+	}, true
+}
+
+type ownershipTransferReq struct {
+	KeyList []string `json:"keyList"`
+	Owner   string   `json:"owner"`
+}
+
+type editorsReq struct {
+	Key     string   `json:"key"`
+	Editors []string `json:"editors"`
+}
+
+// routeV1Ownership is nested in the dataset group, so every path starts with /:dataset/ownership
+func (p *Server) routeV1Ownership(datasetRoute *gin.RouterGroup) {
+
+	ownershipRoute := datasetRoute.Group("/ownership", p.requirePermission("", userManager.ActionInsert), p.writeQuota())
+	ownershipRoute.POST("/transfer", func(context *gin.Context) {
+		var req ownershipTransferReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+		actor, ok := p.contextWriter(context)
+		if !ok {
+			return
+		}
+
+		err = contextDataset(context).TransferOwnership(req.KeyList, req.Owner, actor)
+		if err != nil {
+			sendError(context, "transfer failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	ownershipRoute.POST("/editors", func(context *gin.Context) {
+		var req editorsReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+		actor, ok := p.contextWriter(context)
+		if !ok {
+			return
+		}
+
+		err = contextDataset(context).SetEditors(req.Key, req.Editors, actor)
+		if err != nil {
+			sendError(context, "set editors failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+}
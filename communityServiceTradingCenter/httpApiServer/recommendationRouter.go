@@ -0,0 +1,110 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/recommendManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+)
+
+type overrideListReq struct {
+	Scope string `json:"scope"`
+}
+
+type overrideDeleteReq struct {
+	Scope   string `json:"scope"`
+	Dataset string `json:"dataset"`
+	Key     string `json:"key"`
+}
+
+func (p *Server) routeV1Recommendation(r *gin.RouterGroup) {
+
+	recommendationRoute := r.Group("/recommendation")
+	recommendationRoute.POST("/signal", func(context *gin.Context) {
+		var req recommendManager.Signal
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		err = p.recommendManager.RecordSignal(req)
+		if err != nil {
+			sendError(context, "record signal failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	recommendationRoute.POST("/preview", p.requirePermission(userManager.AnyDataset, userManager.ActionAdmin), func(context *gin.Context) {
+		plan, err := p.recommendManager.Preview()
+		if err != nil {
+			sendError(context, "preview failed", err)
+			return
+		}
+
+		sendResponse(context, true, plan)
+	})
+
+	recommendationRoute.POST("/run", p.requirePermission(userManager.AnyDataset, userManager.ActionAdmin), func(context *gin.Context) {
+		plan, err := p.recommendManager.Run()
+		if err != nil {
+			sendError(context, "run failed", err)
+			return
+		}
+
+		sendResponse(context, true, plan)
+	})
+
+	overrideRoute := recommendationRoute.Group("/override")
+	overrideRoute.POST("/set", p.requirePermission(userManager.AnyDataset, userManager.ActionAdmin), func(context *gin.Context) {
+		var req recommendManager.Override
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		err = p.recommendManager.SetOverride(req)
+		if err != nil {
+			sendError(context, "set override failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	overrideRoute.POST("/list", p.requirePermission(userManager.AnyDataset, userManager.ActionAdmin), func(context *gin.Context) {
+		var req overrideListReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		list, err := p.recommendManager.ListOverrides(req.Scope)
+		if err != nil {
+			sendError(context, "list overrides failed", err)
+			return
+		}
+
+		sendResponse(context, true, list)
+	})
+
+	overrideRoute.POST("/delete", p.requirePermission(userManager.AnyDataset, userManager.ActionAdmin), func(context *gin.Context) {
+		var req overrideDeleteReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		err = p.recommendManager.RemoveOverride(req.Scope, req.Dataset, req.Key)
+		if err != nil {
+			sendError(context, "delete override failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+}
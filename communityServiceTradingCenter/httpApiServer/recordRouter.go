@@ -0,0 +1,408 @@
+package httpApiServer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// matchParamPrefix starts the query string filters of GET /records, match.category=^clean matches like a match rule
+const matchParamPrefix = "match."
+
+// etagOf is the ETag of the response carrying data, with the body it was computed from
+func etagOf(data any) (string, []byte) {
+	resData, _ := json.Marshal(response{
+		Succeed: true,
+		Data:    data,
+	})
+	sum := sha256.Sum256(resData)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, resData
+}
+
+// recordEtag is the ETag of GET /records/:key, the one of the stored record so that If-Match can compare it.
+// Embedded records change the response without changing the record, their ETags are then folded into a weak
+// ETag that revalidates the response but never satisfies If-Match.
+func recordEtag(record dataManager.Record) string {
+	if len(record.Expanded) == 0 {
+		return record.ETag
+	}
+	fields := make([]string, 0, len(record.Expanded))
+	for field := range record.Expanded {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	tags := record.ETag
+	for _, field := range fields {
+		tags += "," + field + "=" + record.Expanded[field].ETag()
+	}
+	sum := sha256.Sum256([]byte(tags))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagList splits a comma separated If-Match or If-None-Match header
+func etagList(header string) []string {
+	var res []string
+	for _, candidate := range strings.Split(header, ",") {
+		if candidate = strings.TrimSpace(candidate); candidate != "" {
+			res = append(res, candidate)
+		}
+	}
+	return res
+}
+
+// etagMatches tells if etag is one of the comma separated ETags of header
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range etagList(header) {
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// sendCacheable answers a GET with an ETag of the body and 304 when the client already has it.
+// Responses depend on the caller, so caches must revalidate and keep them private.
+func sendCacheable(context *gin.Context, data any) {
+	etag, resData := etagOf(data)
+	sendTagged(context, etag, resData)
+}
+
+// sendTagged is sendCacheable with an ETag the caller computed
+func sendTagged(context *gin.Context, etag string, resData []byte) {
+	context.Header("ETag", etag)
+	context.Header("Cache-Control", "private, no-cache")
+	if etagMatches(context.GetHeader("If-None-Match"), etag) {
+		context.Status(http.StatusNotModified)
+		context.Abort()
+		return
+	}
+	context.Data(http.StatusOK, "application/json", resData)
+	context.Abort()
+}
+
+// listParam reads a comma separated or repeated parameter
+func listParam(values url.Values, name string) []string {
+	var res []string
+	for _, v := range values[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				res = append(res, item)
+			}
+		}
+	}
+	return res
+}
+
+func floatsParam(values url.Values, name string, n int) ([]float64, error) {
+	list := listParam(values, name)
+	if len(list) != n {
+		return nil, errors.New(name + " needs " + strconv.Itoa(n) + " comma separated numbers")
+	}
+	res := make([]float64, n)
+	for i, v := range list {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.New("invalid " + name + " : " + v)
+		}
+		res[i] = f
+	}
+	return res, nil
+}
+
+func intParam(values url.Values, name string) (int, error) {
+	v := values.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	res, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.New("invalid " + name + " : " + v)
+	}
+	return res, nil
+}
+
+func boolParam(values url.Values, name string) (bool, error) {
+	v := values.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	res, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("invalid " + name + " : " + v)
+	}
+	return res, nil
+}
+
+// queryFromValues builds the query of GET /records, the match.<field> parameters form a single match rule
+func queryFromValues(values url.Values) (dataManager.Query, bool, error) {
+	query := dataManager.Query{
+		Fields:        listParam(values, "fields"),
+		ExcludeFields: listParam(values, "excludeFields"),
+		PreviewFields: listParam(values, "previewFields"),
+		States:        listParam(values, "states"),
+		Expand:        listParam(values, "expand"),
+		Owner:         values.Get("owner"),
+	}
+	var err error
+	for name, target := range map[string]*int{"limit": &query.Limit, "page": &query.Page, "previewLength": &query.PreviewLength} {
+		*target, err = intParam(values, name)
+		if err != nil {
+			return query, false, err
+		}
+	}
+	for name, target := range map[string]*bool{"sortByDistance": &query.SortByDistance, "noCache": &query.NoCache} {
+		*target, err = boolParam(values, name)
+		if err != nil {
+			return query, false, err
+		}
+	}
+	if values.Has("near") {
+		near, err := floatsParam(values, "near", 3)
+		if err != nil {
+			return query, false, err
+		}
+		query.Near = &dataManager.GeoRadius{Lat: near[0], Lng: near[1], RadiusKm: near[2]}
+	}
+	if values.Has("within") {
+		within, err := floatsParam(values, "within", 4)
+		if err != nil {
+			return query, false, err
+		}
+		query.Within = &dataManager.GeoBox{MinLat: within[0], MinLng: within[1], MaxLat: within[2], MaxLng: within[3]}
+	}
+	rule := make(map[string]string)
+	for name := range values {
+		if strings.HasPrefix(name, matchParamPrefix) && len(name) > len(matchParamPrefix) {
+			rule[name[len(matchParamPrefix):]] = values.Get(name)
+		}
+	}
+	if len(rule) > 0 {
+		query.MatchRules = []map[string]string{rule}
+	}
+	mine, err := boolParam(values, "mine")
+	return query, mine, err
+}
+
+// contextQuery parses the query string and applies mine, it answers the request itself and returns false on failure
+func contextQuery(context *gin.Context) (dataManager.Query, bool) {
+	query, mine, err := queryFromValues(context.Request.URL.Query())
+	if err != nil {
+		sendError(context, "parse query failed", badRequest{err})
+		return query, false
+	}
+	if mine {
+		principal := contextPrincipal(context)
+		if principal == userManager.PrincipalAnonymous {
+			sendStatus(context, http.StatusUnauthorized, "unauthorized : sign in required for mine")
+			return query, false
+		}
+		query.Owner = principal
+	}
+	return query, true
+}
+
+// insertStatus is the http status of the outcome of a single record write
+func insertStatus(status dataManager.ItemStatus, conditional bool) int {
+	switch status {
+	case dataManager.ItemStatusCreated:
+		return http.StatusCreated
+	case dataManager.ItemStatusUpdated, dataManager.ItemStatusMerged:
+		return http.StatusOK
+	case dataManager.ItemStatusForbidden:
+		return http.StatusForbidden
+	case dataManager.ItemStatusConflict:
+		if conditional {
+			return http.StatusPreconditionFailed
+		}
+		return http.StatusConflict
+	case dataManager.ItemStatusDuplicate:
+		return http.StatusConflict
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+// requireRecord answers 404 unless the record in the path exists, whatever its state
+func requireRecord() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		key := context.Param("key")
+		_, exists, err := contextDataset(context).GetData(key)
+		if err != nil {
+			sendError(context, "load failed", err)
+			return
+		}
+		if !exists {
+			sendStatus(context, http.StatusNotFound, "key not found : "+key)
+			return
+		}
+		context.Next()
+	}
+}
+
+// routeV1Records is nested in the dataset group, every path starts with /:dataset/records.
+// The routes mirror /query, /insert, /patch and /delete for one record, with http statuses instead of 200 everywhere.
+func (p *Server) routeV1Records(datasetRoute *gin.RouterGroup) {
+
+	recordsRoute := datasetRoute.Group("/records")
+	recordsRoute.GET("", p.requirePermission("", userManager.ActionQuery), func(context *gin.Context) {
+		query, ok := contextQuery(context)
+		if !ok || !p.checkExpand(context, query.Expand) {
+			return
+		}
+
+		list, count, totalCount, err := contextDataset(context).QueryData(query)
+		if err != nil {
+			sendError(context, "query failed", err)
+			return
+		}
+
+		resMap := make(map[string]any)
+
+		resMap["count"] = count
+		resMap["totalCount"] = totalCount
+		resMap["queryList"] = list
+
+		sendCacheable(context, resMap)
+	})
+
+	recordsRoute.GET("/:key", p.requirePermission("", userManager.ActionQuery), func(context *gin.Context) {
+		query, ok := contextQuery(context)
+		if !ok || !p.checkExpand(context, query.Expand) {
+			return
+		}
+
+		key := context.Param("key")
+		record, exists, err := contextDataset(context).GetRecord(key, query)
+		if err != nil {
+			sendError(context, "get failed", err)
+			return
+		}
+		if !exists {
+			sendStatus(context, http.StatusNotFound, "key not found : "+key)
+			return
+		}
+
+		resData, _ := json.Marshal(response{
+			Succeed: true,
+			Data:    record,
+		})
+		sendTagged(context, recordEtag(record), resData)
+	})
+
+	// PUT stores the body as the record, If-None-Match: * only creates it and If-Match: * only replaces it.
+	// If-Match with ETags of GET /records/:key replaces the record only while it is unchanged, the data layer
+	// compares them in the write transaction so concurrent writers cannot both pass.
+	recordsRoute.PUT("/:key", p.requirePermission("", userManager.ActionInsert), p.writeQuota(), func(context *gin.Context) {
+		var req dataManager.Data
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+		key := context.Param("key")
+		if req.Key != "" && req.Key != key {
+			sendStatus(context, http.StatusBadRequest, "key in body differs from path : "+req.Key)
+			return
+		}
+		req.Key = key
+
+		option := dataManager.InsertOption{Mode: dataManager.WriteModeUpsert}
+		if context.GetHeader("If-None-Match") == "*" {
+			option.Mode = dataManager.WriteModeCreate
+		} else if ifMatch := context.GetHeader("If-Match"); ifMatch == "*" {
+			option.Mode = dataManager.WriteModeUpdate
+		} else if ifMatch != "" {
+			option.Mode = dataManager.WriteModeUpdate
+			option.IfMatch = etagList(ifMatch)
+		}
+		actor, ok := p.contextWriter(context)
+		if !ok {
+			return
+		}
+		option.Actor = actor
+
+		conditional := option.Mode != dataManager.WriteModeUpsert
+		results, err := contextDataset(context).InsertData([]dataManager.Data{req}, option)
+		if len(results) == 0 || (err != nil && !results[0].Status.Failed()) {
+			// a conflict at commit is a concurrent write of the record, which is what a precondition guards against
+			if conditional && errors.Is(err, badgerManager.ErrConflict) {
+				sendStatus(context, http.StatusPreconditionFailed, "precondition failed : record changed : "+key)
+				return
+			}
+			if err == nil {
+				err = errors.New("no result")
+			}
+			sendError(context, "put failed", err)
+			return
+		}
+		result := results[0]
+		status := insertStatus(result.Status, conditional)
+		if err != nil || result.Status.Failed() {
+			sendFailure(context, status, apiError{Code: statusCode(status), Message: result.Message, Details: result}, result)
+			return
+		}
+		if status == http.StatusCreated {
+			context.Header("Location", context.Request.URL.Path)
+		}
+
+		resData, _ := json.Marshal(response{
+			Succeed: true,
+			Data:    result,
+		})
+		context.Data(status, "application/json", resData)
+	})
+
+	recordsRoute.PATCH("/:key", p.requirePermission("", userManager.ActionInsert), requireRecord(), p.writeQuota(), func(context *gin.Context) {
+		var req dataManager.Patch
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+		req.Key = context.Param("key")
+		actor, ok := p.contextWriter(context)
+		if !ok {
+			return
+		}
+
+		err = contextDataset(context).PatchDataAs([]dataManager.Patch{req}, false, actor)
+		if err != nil {
+			sendError(context, "patch failed", err)
+			return
+		}
+
+		data, _, err := contextDataset(context).GetData(req.Key)
+		if err != nil {
+			sendError(context, "load failed", err)
+			return
+		}
+
+		sendResponse(context, true, data)
+	})
+
+	recordsRoute.DELETE("/:key", p.requirePermission("", userManager.ActionDelete), requireRecord(), p.writeQuota(), func(context *gin.Context) {
+		actor, ok := p.contextWriter(context)
+		if !ok {
+			return
+		}
+
+		err := contextDataset(context).DeleteDataAs([]string{context.Param("key")}, actor)
+		if err != nil {
+			sendError(context, "delete failed", err)
+			return
+		}
+
+		context.Status(http.StatusNoContent)
+		context.Abort()
+	})
+}
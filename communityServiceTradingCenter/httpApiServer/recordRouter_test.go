@@ -0,0 +1,40 @@
+package httpApiServer
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestQueryFromValues(t *testing.T) {
+	values, _ := url.ParseQuery("limit=10&page=2&fields=theme,content&fields=price&states=*&near=31.2,121.5,3&match.category=^clean&match.city=Shanghai&mine=true")
+	query, mine, err := queryFromValues(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Limit != 10 || query.Page != 2 || len(query.Fields) != 3 || query.States[0] != "*" || !mine {
+		t.Fatal("unexpected query", query)
+	}
+	if query.Near == nil || query.Near.RadiusKm != 3 {
+		t.Fatal("unexpected near", query.Near)
+	}
+	if len(query.MatchRules) != 1 || query.MatchRules[0]["category"] != "^clean" || query.MatchRules[0]["city"] != "Shanghai" {
+		t.Fatal("match parameters should form one rule", query.MatchRules)
+	}
+
+	for _, raw := range []string{"limit=ten", "near=1,2", "within=1,2,3,x", "noCache=maybe"} {
+		values, _ = url.ParseQuery(raw)
+		if _, _, err = queryFromValues(values); err == nil {
+			t.Fatal("invalid parameter should be refused", raw)
+		}
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	etag, _ := etagOf(map[string]string{"theme": "a"})
+	if other, _ := etagOf(map[string]string{"theme": "b"}); other == etag {
+		t.Fatal("different data should have different etags")
+	}
+	if !etagMatches(`"x", `+etag, etag) || etagMatches(`"x"`, etag) {
+		t.Fatal("unexpected match of", etag)
+	}
+}
@@ -5,12 +5,17 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/matchManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
 	"net/http"
+	"time"
 )
 
 type response struct {
 	Succeed bool        `json:"succeed"`
 	Data    interface{} `json:"data"`
+	// Error describes a failure, data still holds its message for clients that only read succeed
+	Error *apiError `json:"error,omitempty"`
 }
 
 func sendResponse(context *gin.Context, succeed bool, data any) {
@@ -25,12 +30,31 @@ func sendResponse(context *gin.Context, succeed bool, data any) {
 func (p *Server) route() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
-
-	r.Use(cors.Default())
+	// SetOptions checked the addresses
+	_ = r.SetTrustedProxies(p.options.TrustedProxies)
+
+	if len(p.options.AllowOrigins) > 0 {
+		config := cors.Config{
+			AllowMethods:  []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+			AllowHeaders:  []string{"Origin", "Content-Length", "Content-Type", "Authorization", apiKeyHeader, "If-Match", "If-None-Match"},
+			ExposeHeaders: []string{"Content-Disposition", retryAfterHeader, quotaRemainingHeader, "ETag", "Location"},
+			MaxAge:        12 * time.Hour,
+		}
+		for _, origin := range p.options.AllowOrigins {
+			if origin == "*" {
+				config.AllowAllOrigins = true
+			}
+		}
+		if !config.AllowAllOrigins {
+			config.AllowOrigins = p.options.AllowOrigins
+		}
+		r.Use(cors.New(config))
+	}
 
 	v1router := r.Group("v1")
 
 	p.routeV1Static(v1router)
+	p.routeV1Docs(v1router)
 	p.routeV1Api(v1router)
 
 	return r
@@ -43,27 +67,52 @@ func (p *Server) routeV1Static(r *gin.RouterGroup) {
 
 }
 
-func (p *Server) routeV1Api(r *gin.RouterGroup) {
+type queryReq struct {
+	dataManager.Query
+	// Mine limits the results to the records of the caller
+	Mine bool `json:"mine"`
+}
 
-	apiRoute := r.Group("/api")
+type insertReq struct {
+	DataList []dataManager.Data `json:"dataList"`
+	dataManager.InsertOption
+}
 
-	providerRoute := apiRoute.Group("/provider")
-	providerRoute.POST("/query", func(context *gin.Context) {
-		type localReq struct {
-			Limit      int                 `json:"limit"`
-			Page       int                 `json:"page"`
-			MatchRules []map[string]string `json:"matchRules"`
-		}
-		var req localReq
+type patchReq struct {
+	PatchList []dataManager.Patch `json:"patchList"`
+	Upsert    bool                `json:"upsert"`
+}
+
+type transitionReq struct {
+	KeyList []string `json:"keyList"`
+	To      string   `json:"to"`
+	Reason  string   `json:"reason"`
+}
+
+func (p *Server) queryHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		dm := contextDataset(context)
+		var req queryReq
 		err := context.BindJSON(&req)
 		if err != nil {
-			sendResponse(context, false, "parse json failed"+err.Error())
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+		if req.Mine {
+			principal := contextPrincipal(context)
+			if principal == userManager.PrincipalAnonymous {
+				sendStatus(context, 401, "unauthorized : sign in required for mine")
+				return
+			}
+			req.Owner = principal
+		}
+		if !p.checkExpand(context, req.Expand) {
 			return
 		}
 
-		list, count, totalCount, err := p.providerDataManager.QueryData(req.Limit, req.Page, req.MatchRules)
+		list, count, totalCount, err := dm.QueryData(req.Query)
 		if err != nil {
-			sendResponse(context, false, "query failed : "+err.Error())
+			sendError(context, "query failed", err)
 			return
 		}
 
@@ -74,192 +123,177 @@ func (p *Server) routeV1Api(r *gin.RouterGroup) {
 		resMap["queryList"] = list
 
 		sendResponse(context, true, resMap)
+	}
+}
 
-	})
-
-	providerRoute.POST("/insert", func(context *gin.Context) {
-		type localReq struct {
-			DataList []dataManager.Data `json:"dataList"`
-		}
-
-		var req localReq
+func (p *Server) insertHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		dm := contextDataset(context)
+		var req insertReq
 		err := context.BindJSON(&req)
 		if err != nil {
-			sendResponse(context, false, "parse json failed : "+err.Error())
+			sendError(context, "parse json failed", badRequest{err})
 			return
 		}
 
-		err = p.providerDataManager.InsertData(req.DataList)
-		if err != nil {
-			sendResponse(context, false, "insert data failed : "+err.Error())
+		actor, ok := p.contextWriter(context)
+		if !ok {
 			return
 		}
+		req.Actor = actor
 
-		sendResponse(context, true, nil)
-	})
-
-	providerRoute.POST("/delete", func(context *gin.Context) {
-		type localReq struct {
-			KeyList []string `json:"keyList"`
-		}
-
-		var req localReq
-		err := context.BindJSON(&req)
+		results, err := dm.InsertData(req.DataList, req.InsertOption)
 		if err != nil {
-			sendResponse(context, false, "parse json failed : "+err.Error())
+			if results == nil {
+				sendError(context, "insert failed", err)
+				return
+			}
+			sendErrorData(context, "insert failed : "+err.Error(), err, map[string]any{
+				"committed": false,
+				"message":   "insert failed : " + err.Error(),
+				"results":   results,
+			})
 			return
 		}
 
-		err = p.providerDataManager.DeleteData(req.KeyList)
-		if err != nil {
-			sendResponse(context, false, "delete data failed : "+err.Error())
-			return
-		}
-
-		sendResponse(context, true, nil)
-	})
+		sendResponse(context, true, map[string]any{
+			"committed": true,
+			"results":   results,
+		})
+	}
+}
 
-	publishRouter := apiRoute.Group("/publish")
-	publishRouter.POST("/query", func(context *gin.Context) {
-		type localReq struct {
-			Limit      int                 `json:"limit"`
-			Page       int                 `json:"page"`
-			MatchRules []map[string]string `json:"matchRules"`
-		}
-		var req localReq
+func (p *Server) patchHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		dm := contextDataset(context)
+		var req patchReq
 		err := context.BindJSON(&req)
 		if err != nil {
-			sendResponse(context, false, "parse json failed"+err.Error())
+			sendError(context, "parse json failed", badRequest{err})
 			return
 		}
 
-		list, count, totalCount, err := p.publishDataManager.QueryData(req.Limit, req.Page, req.MatchRules)
-		if err != nil {
-			sendResponse(context, false, "query failed : "+err.Error())
+		actor, ok := p.contextWriter(context)
+		if !ok {
 			return
 		}
 
-		resMap := make(map[string]any)
-
-		resMap["count"] = count
-		resMap["totalCount"] = totalCount
-		resMap["queryList"] = list
-
-		sendResponse(context, true, resMap)
-
-	})
-
-	publishRouter.POST("/insert", func(context *gin.Context) {
-		type localReq struct {
-			DataList []dataManager.Data `json:"dataList"`
-		}
-
-		var req localReq
-		err := context.BindJSON(&req)
+		err = dm.PatchDataAs(req.PatchList, req.Upsert, actor)
 		if err != nil {
-			sendResponse(context, false, "parse json failed"+err.Error())
-			return
-		}
-
-		err = p.publishDataManager.InsertData(req.DataList)
-		if err != nil {
-			sendResponse(context, false, "insert failed : "+err.Error())
+			sendError(context, "patch failed", err)
 			return
 		}
 
 		sendResponse(context, true, nil)
-	})
-
-	publishRouter.POST("/delete", func(context *gin.Context) {
-		type localReq struct {
-			KeyList []string `json:"keyList"`
-		}
+	}
+}
 
-		var req localReq
+func (p *Server) transitionHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		dm := contextDataset(context)
+		var req transitionReq
 		err := context.BindJSON(&req)
 		if err != nil {
-			sendResponse(context, false, "parse json failed"+err.Error())
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		actor, ok := p.contextWriter(context)
+		if !ok {
 			return
 		}
 
-		err = p.publishDataManager.DeleteData(req.KeyList)
+		err = dm.TransitionStateAs(req.KeyList, req.To, req.Reason, actor)
 		if err != nil {
-			sendResponse(context, false, "delete failed : "+err.Error())
+			sendError(context, "transition failed", err)
 			return
 		}
 
 		sendResponse(context, true, nil)
-	})
+	}
+}
 
-	recommendRoute := apiRoute.Group("/recommend")
-	recommendRoute.POST("/query", func(context *gin.Context) {
-		type localReq struct {
-			Limit      int                 `json:"limit"`
-			Page       int                 `json:"page"`
-			MatchRules []map[string]string `json:"matchRules"`
-		}
-		var req localReq
+func (p *Server) deleteHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		dm := contextDataset(context)
+		var req keyListReq
 		err := context.BindJSON(&req)
 		if err != nil {
-			sendResponse(context, false, "parse json failed"+err.Error())
+			sendError(context, "parse json failed", badRequest{err})
 			return
 		}
 
-		list, count, totalCount, err := p.recommendDataManager.QueryData(req.Limit, req.Page, req.MatchRules)
-		if err != nil {
-			sendResponse(context, false, "query failed : "+err.Error())
+		actor, ok := p.contextWriter(context)
+		if !ok {
 			return
 		}
 
-		resMap := make(map[string]any)
+		err = dm.DeleteDataAs(req.KeyList, actor)
+		if err != nil {
+			sendError(context, "delete failed", err)
+			return
+		}
 
-		resMap["count"] = count
-		resMap["totalCount"] = totalCount
-		resMap["queryList"] = list
+		sendResponse(context, true, nil)
+	}
+}
 
-		sendResponse(context, true, resMap)
+func (p *Server) routeV1Api(r *gin.RouterGroup) {
 
-	})
+	apiRoute := r.Group("/api", p.authenticate(), p.rateLimit())
 
-	recommendRoute.POST("/insert", func(context *gin.Context) {
-		type localReq struct {
-			DataList []dataManager.Data `json:"dataList"`
-		}
+	p.routeV1User(apiRoute)
+	p.routeV1Access(apiRoute)
+	p.routeV1ApiKey(apiRoute)
+	p.routeV1Webhook(apiRoute)
+	p.routeV1Recommendation(apiRoute)
 
-		var req localReq
+	apiRoute.POST("/match", p.requirePermission("provider", userManager.ActionQuery), p.requirePermission("publisher", userManager.ActionQuery), func(context *gin.Context) {
+		var req matchManager.MatchRequest
 		err := context.BindJSON(&req)
 		if err != nil {
-			sendResponse(context, false, "parse json failed"+err.Error())
+			sendError(context, "parse json failed", badRequest{err})
 			return
 		}
 
-		err = p.recommendDataManager.InsertData(req.DataList)
+		candidates, err := p.matchManager.Match(req)
 		if err != nil {
-			sendResponse(context, false, "insert failed : "+err.Error())
+			sendError(context, "match failed", err)
 			return
 		}
 
-		sendResponse(context, true, nil)
-	})
+		resMap := make(map[string]any)
 
-	recommendRoute.POST("/delete", func(context *gin.Context) {
-		type localReq struct {
-			KeyList []string `json:"keyList"`
-		}
+		resMap["count"] = len(candidates)
+		resMap["candidates"] = candidates
 
-		var req localReq
-		err := context.BindJSON(&req)
-		if err != nil {
-			sendResponse(context, false, "parse json failed"+err.Error())
-			return
-		}
+		sendResponse(context, true, resMap)
+	})
 
-		err = p.recommendDataManager.DeleteData(req.KeyList)
+	p.routeV1Dataset(apiRoute)
+
+	// the old /publish and /recommend groups resolve through datasetAliases
+	datasetRoute := apiRoute.Group("/:dataset", p.resolveDataset())
+	datasetRoute.POST("/query", p.requirePermission("", userManager.ActionQuery), p.queryHandler())
+	datasetRoute.POST("/insert", p.requirePermission("", userManager.ActionInsert), p.writeQuota(), p.insertHandler())
+	datasetRoute.POST("/delete", p.requirePermission("", userManager.ActionDelete), p.writeQuota(), p.deleteHandler())
+	datasetRoute.POST("/patch", p.requirePermission("", userManager.ActionInsert), p.writeQuota(), p.patchHandler())
+	datasetRoute.POST("/transition", p.requirePermission("", userManager.ActionInsert), p.writeQuota(), p.transitionHandler())
+	datasetRoute.POST("/import", p.requirePermission("", userManager.ActionInsert), p.writeQuota(), p.importHandler())
+	datasetRoute.GET("/export", p.requirePermission("", userManager.ActionQuery), p.exportHandler())
+	datasetRoute.POST("/cacheStats", p.requirePermission("", userManager.ActionAdmin), func(context *gin.Context) {
+		sendResponse(context, true, contextDataset(context).CacheStats())
+	})
+	datasetRoute.POST("/duplicates", p.requirePermission("", userManager.ActionModerate), func(context *gin.Context) {
+		clusters, err := contextDataset(context).DuplicateClusters()
 		if err != nil {
-			sendResponse(context, false, "delete failed : "+err.Error())
+			sendError(context, "list duplicates failed", err)
 			return
 		}
 
-		sendResponse(context, true, nil)
+		sendResponse(context, true, clusters)
 	})
+	p.routeV1Moderation(datasetRoute)
+	p.routeV1Ownership(datasetRoute)
+	p.routeV1Records(datasetRoute)
 }
@@ -1,43 +1,79 @@
 package httpApiServer
 
 import (
+	"errors"
 	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/limitManager"
+	"moonlighting/communityServiceTradingCenter/matchManager"
+	"moonlighting/communityServiceTradingCenter/recommendManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"moonlighting/communityServiceTradingCenter/webhookManager"
 	"net"
 	"net/http"
 	"sync"
 )
 
+type Options struct {
+	// AllowOrigins are the origins allowed to call the api from a browser, "*" allows any,
+	// only the origin serving the static pages may when empty
+	AllowOrigins []string `json:"allowOrigins"`
+	// TrustedProxies are the addresses or cidrs of the reverse proxies whose X-Forwarded-For is believed,
+	// the client ip is the peer address when empty so callers cannot pick the ip they are rate limited by
+	TrustedProxies []string `json:"trustedProxies"`
+}
+
 type Server struct {
-	listenAddress        string
-	netListener          net.Listener
-	providerDataManager  *dataManager.Manager
-	publishDataManager   *dataManager.Manager
-	recommendDataManager *dataManager.Manager
-	staticServePath      string
-	stopSignal           chan int
-	stopOnce             sync.Once
+	listenAddress    string
+	netListener      net.Listener
+	registry         *dataManager.Registry
+	webhookManager   *webhookManager.Manager
+	matchManager     *matchManager.Manager
+	recommendManager *recommendManager.Manager
+	userManager      *userManager.Manager
+	limitManager     *limitManager.Manager
+	options          Options
+	staticServePath  string
+	openApiOnce      sync.Once
+	openApi          []byte
+	stopSignal       chan int
+	stopOnce         sync.Once
 }
 
-func NewHttpApiServer(listenAddress string, htmlServePath string, proDm *dataManager.Manager, pubDm *dataManager.Manager, recDm *dataManager.Manager) *Server {
+func NewHttpApiServer(listenAddress string, htmlServePath string, registry *dataManager.Registry, wm *webhookManager.Manager, mm *matchManager.Manager, rm *recommendManager.Manager, um *userManager.Manager, lm *limitManager.Manager) *Server {
 	netListener, err := net.Listen("tcp", listenAddress)
 	if err != nil {
 		panic(err)
 	}
 	res := &Server{
-		listenAddress:        listenAddress,
-		netListener:          netListener,
-		providerDataManager:  proDm,
-		publishDataManager:   pubDm,
-		recommendDataManager: recDm,
-		staticServePath:      htmlServePath,
-		stopSignal:           make(chan int),
-		stopOnce:             sync.Once{},
+		listenAddress:    listenAddress,
+		netListener:      netListener,
+		registry:         registry,
+		webhookManager:   wm,
+		matchManager:     mm,
+		recommendManager: rm,
+		userManager:      um,
+		limitManager:     lm,
+		staticServePath:  htmlServePath,
+		stopSignal:       make(chan int),
+		stopOnce:         sync.Once{},
 	}
 
 	return res
 
 }
 
+// SetOptions must be called before Start, the router is built once
+func (p *Server) SetOptions(options Options) error {
+	for _, proxy := range options.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		if err != nil && net.ParseIP(proxy) == nil {
+			return errors.New("invalid trusted proxy : " + proxy)
+		}
+	}
+	p.options = options
+	return nil
+}
+
 func (p *Server) Start() {
 	err := http.Serve(p.netListener, p.route())
 	if err != nil {
@@ -0,0 +1,102 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// importHandler reads the request body as a csv or ndjson stream, options come from the query string
+func (p *Server) importHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		dm := contextDataset(context)
+		format, err := dataManager.ParseTransferFormat(context.Query("format"))
+		if err != nil {
+			sendError(context, "", err)
+			return
+		}
+		mapping, err := dataManager.ParseFieldMapping(context.QueryArray("map"))
+		if err != nil {
+			sendError(context, "", err)
+			return
+		}
+		actor, ok := p.contextWriter(context)
+		if !ok {
+			return
+		}
+		dryRun, _ := strconv.ParseBool(context.Query("dryRun"))
+		bestEffort, _ := strconv.ParseBool(context.Query("bestEffort"))
+
+		report, err := dm.Import(context.Request.Body, dataManager.ImportOption{
+			Format:         format,
+			KeyColumn:      context.Query("keyColumn"),
+			PriorityColumn: context.Query("priorityColumn"),
+			FieldMapping:   mapping,
+			DryRun:         dryRun,
+			Insert: dataManager.InsertOption{
+				Mode:       dataManager.WriteMode(context.Query("mode")),
+				BestEffort: bestEffort,
+				Actor:      actor,
+			},
+		})
+		if err != nil {
+			if report == nil {
+				sendError(context, "import failed", err)
+				return
+			}
+			sendErrorData(context, "import failed : "+err.Error(), err, map[string]any{
+				"message": "import failed : " + err.Error(),
+				"report":  report,
+			})
+			return
+		}
+
+		sendResponse(context, true, report)
+	}
+}
+
+func (p *Server) exportHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		dm := contextDataset(context)
+		format, err := dataManager.ParseTransferFormat(context.Query("format"))
+		if err != nil {
+			sendError(context, "", err)
+			return
+		}
+		var columns []string
+		if c := context.Query("columns"); c != "" {
+			columns = strings.Split(c, ",")
+		}
+		// records queries leave out, hidden by moderation or in a state that is not visible, are exported to moderators only
+		includeUnlisted, _ := strconv.ParseBool(context.Query("includeUnlisted"))
+		allStates, _ := strconv.ParseBool(context.Query("allStates"))
+		if (includeUnlisted || allStates) && !p.checkPermission(context, contextDatasetName(context), userManager.ActionModerate) {
+			return
+		}
+
+		contentType := "text/csv; charset=utf-8"
+		if format == dataManager.TransferFormatNDJSON {
+			contentType = "application/x-ndjson"
+		}
+		context.Header("Content-Type", contentType)
+		context.Header("Content-Disposition", "attachment; filename=export."+string(format))
+		context.Status(http.StatusOK)
+
+		// the status line is already sent, a failure can only cut the stream short
+		err = dm.Export(context.Writer, dataManager.ExportOption{
+			Format:          format,
+			KeyColumn:       context.Query("keyColumn"),
+			PriorityColumn:  context.Query("priorityColumn"),
+			Columns:         columns,
+			IncludeUnlisted: includeUnlisted,
+			AllStates:       allStates,
+		})
+		if err != nil {
+			_ = context.Error(err)
+		}
+		context.Abort()
+	}
+}
@@ -0,0 +1,207 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"strings"
+)
+
+const (
+	userContextKey      = "user"
+	apiKeyContextKey    = "apiKey"
+	principalContextKey = "principal"
+	bearerPrefix        = "Bearer "
+	apiKeyHeader        = "X-Api-Key"
+)
+
+func bearerToken(context *gin.Context) string {
+	header := context.GetHeader("Authorization")
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(bearerPrefix):])
+}
+
+// authenticate finds out who sends the request, requests without credentials run as the anonymous principal
+// and a token or api key that is not valid is refused rather than treated as anonymous, the refusal is charged to
+// the ip bucket since the rate limit of the route never sees the request. Scripts send a key
+// in the X-Api-Key header and run as its apikey principal.
+func (p *Server) authenticate() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		principal := userManager.PrincipalAnonymous
+		if key := context.GetHeader(apiKeyHeader); key != "" {
+			apiKey, err := p.userManager.AuthenticateApiKey(key)
+			if err != nil {
+				if code, _ := errorCode(err); code != codeUnauthorized {
+					sendError(context, "authenticate failed", err)
+					return
+				}
+				if p.chargeFailedAuth(context) {
+					sendStatus(context, 401, "unauthorized : "+err.Error())
+				}
+				return
+			}
+			context.Set(apiKeyContextKey, apiKey)
+			principal = userManager.ApiKeyPrincipal(apiKey.Id)
+		} else if token := bearerToken(context); token != "" {
+			user, err := p.userManager.Authenticate(token)
+			if err != nil {
+				if code, _ := errorCode(err); code != codeUnauthorized {
+					sendError(context, "authenticate failed", err)
+					return
+				}
+				if p.chargeFailedAuth(context) {
+					sendStatus(context, 401, "unauthorized : "+err.Error())
+				}
+				return
+			}
+			context.Set(userContextKey, user)
+			principal = userManager.UserPrincipal(user.Username)
+		}
+		context.Set(principalContextKey, principal)
+		context.Next()
+	}
+}
+
+// requireUser lets the request through only with the token of a live session in the Authorization header
+func (p *Server) requireUser() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if _, ok := context.Get(userContextKey); !ok {
+			sendStatus(context, 401, "unauthorized : sign in required")
+			return
+		}
+		context.Next()
+	}
+}
+
+// requirePermission checks action on dataset, an empty dataset is the one named in the path
+func (p *Server) requirePermission(dataset string, action string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		target := dataset
+		if target == "" {
+			target = contextDatasetName(context)
+		}
+		if p.checkPermission(context, target, action) {
+			context.Next()
+		}
+	}
+}
+
+// checkPermission tells if the caller may do action on dataset, it answers the request itself when not
+func (p *Server) checkPermission(context *gin.Context, dataset string, action string) bool {
+	principal := contextPrincipal(context)
+	allowed, err := p.userManager.Allowed(principal, dataset, action)
+	if err != nil {
+		sendError(context, "check permission failed", err)
+		return false
+	}
+	if !allowed {
+		missing := userManager.Permission(dataset, action)
+		if principal == userManager.PrincipalAnonymous {
+			sendStatus(context, 401, "unauthorized : sign in required for "+missing)
+			return false
+		}
+		sendStatus(context, 403, "permission denied : missing "+missing)
+		return false
+	}
+	return true
+}
+
+// checkExpand makes sure the caller may query the datasets the expand fields point to, embedding a record must not
+// show more than querying its dataset would. Unknown fields are left to the data layer, which refuses them.
+func (p *Server) checkExpand(context *gin.Context, expand []string) bool {
+	dm := contextDataset(context)
+	for _, field := range expand {
+		target, ok := dm.ReferencedDataset(field)
+		if ok && !p.checkPermission(context, target, userManager.ActionQuery) {
+			return false
+		}
+	}
+	return true
+}
+
+func contextUser(context *gin.Context) userManager.User {
+	return context.MustGet(userContextKey).(userManager.User)
+}
+
+func contextPrincipal(context *gin.Context) string {
+	return context.GetString(principalContextKey)
+}
+
+// contextActor names the caller in records and histories, the username for signed in users
+func contextActor(context *gin.Context) string {
+	if user, ok := context.Get(userContextKey); ok {
+		return user.(userManager.User).Username
+	}
+	return contextPrincipal(context)
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type logoutReq struct {
+	// Everywhere revokes every session of the user, not only this one
+	Everywhere bool `json:"everywhere"`
+}
+
+func (p *Server) routeV1User(r *gin.RouterGroup) {
+
+	userRoute := r.Group("/user")
+	userRoute.POST("/register", func(context *gin.Context) {
+		var req credentials
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		user, err := p.userManager.Register(req.Username, req.Password)
+		if err != nil {
+			sendError(context, "register failed", err)
+			return
+		}
+
+		sendResponse(context, true, user)
+	})
+
+	userRoute.POST("/login", func(context *gin.Context) {
+		var req credentials
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		session, err := p.userManager.Login(req.Username, req.Password)
+		if err != nil {
+			sendError(context, "login failed", err)
+			return
+		}
+
+		sendResponse(context, true, session)
+	})
+
+	userRoute.POST("/logout", p.requireUser(), func(context *gin.Context) {
+		var req logoutReq
+		_ = context.ShouldBindJSON(&req)
+
+		var err error
+		if req.Everywhere {
+			err = p.userManager.RevokeSessions(contextUser(context).Username)
+		} else {
+			err = p.userManager.Logout(bearerToken(context))
+		}
+		if err != nil {
+			sendError(context, "logout failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	userRoute.POST("/me", p.requireUser(), func(context *gin.Context) {
+		sendResponse(context, true, contextUser(context))
+	})
+}
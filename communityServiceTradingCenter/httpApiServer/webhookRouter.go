@@ -0,0 +1,88 @@
+package httpApiServer
+
+import (
+	"github.com/gin-gonic/gin"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"moonlighting/communityServiceTradingCenter/webhookManager"
+)
+
+type webhookListReq struct {
+	Dataset string `json:"dataset"`
+}
+
+type deliveriesReq struct {
+	Id    string `json:"id"`
+	Limit int    `json:"limit"`
+}
+
+func (p *Server) routeV1Webhook(r *gin.RouterGroup) {
+
+	webhookRoute := r.Group("/webhook", p.requirePermission(userManager.AnyDataset, userManager.ActionAdmin))
+	webhookRoute.POST("/register", func(context *gin.Context) {
+		var req webhookManager.Webhook
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		hook, err := p.webhookManager.Register(req)
+		if err != nil {
+			sendError(context, "register failed", err)
+			return
+		}
+
+		sendResponse(context, true, hook)
+	})
+
+	webhookRoute.POST("/list", func(context *gin.Context) {
+		var req webhookListReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		list, err := p.webhookManager.List(req.Dataset)
+		if err != nil {
+			sendError(context, "list failed", err)
+			return
+		}
+
+		sendResponse(context, true, list)
+	})
+
+	webhookRoute.POST("/delete", func(context *gin.Context) {
+		var req idReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		err = p.webhookManager.Remove(req.Id)
+		if err != nil {
+			sendError(context, "delete failed", err)
+			return
+		}
+
+		sendResponse(context, true, nil)
+	})
+
+	webhookRoute.POST("/deliveries", func(context *gin.Context) {
+		var req deliveriesReq
+		err := context.BindJSON(&req)
+		if err != nil {
+			sendError(context, "parse json failed", badRequest{err})
+			return
+		}
+
+		list, err := p.webhookManager.Deliveries(req.Id, req.Limit)
+		if err != nil {
+			sendError(context, "query deliveries failed", err)
+			return
+		}
+
+		sendResponse(context, true, list)
+	})
+}
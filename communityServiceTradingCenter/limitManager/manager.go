@@ -0,0 +1,334 @@
+package limitManager
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"math"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger"
+	"moonlighting/communityServiceTradingCenter/userManager"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ByPrincipal limits each user or api key on its own, anonymous requests fall back to the client ip
+	ByPrincipal = "principal"
+	ByIp        = "ip"
+
+	// AnyRoute matches every route not matched by an earlier rule
+	AnyRoute = "*"
+
+	quotaPrefix = "_quota."
+	// quotaTTL keeps the counter of a day a little longer than the day itself
+	quotaTTL = 48 * time.Hour
+
+	bucketCleanInterval = time.Minute
+)
+
+var ErrQuotaExceeded = errors.New("daily write quota exceeded")
+
+// Rule is a token bucket, Burst requests at once and PerSecond more every second
+type Rule struct {
+	// Route is a route path as registered, like /v1/api/:dataset/query, a trailing * matches the prefix
+	Route     string  `json:"route"`
+	By        string  `json:"by"`
+	PerSecond float64 `json:"perSecond"`
+	Burst     int     `json:"burst"`
+}
+
+type QuotaOptions struct {
+	// PerDay is the number of write requests a principal may send per utc day, 0 is unlimited
+	PerDay uint64 `json:"perDay"`
+	// Overrides replaces PerDay for the principals listed, 0 is unlimited
+	Overrides map[string]uint64 `json:"overrides"`
+}
+
+type Options struct {
+	// Rules are tried in order and the first matching one applies, routes matched by none are not limited
+	Rules      []Rule       `json:"rules"`
+	WriteQuota QuotaOptions `json:"writeQuota"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Rules: []Rule{
+			{Route: "/v1/api/:dataset/query", By: ByPrincipal, PerSecond: 5, Burst: 20},
+			{Route: "/v1/api/:dataset/export", By: ByPrincipal, PerSecond: 0.2, Burst: 3},
+			{Route: "/v1/api/user/login", By: ByIp, PerSecond: 0.5, Burst: 10},
+			{Route: AnyRoute, By: ByPrincipal, PerSecond: 20, Burst: 60},
+		},
+		WriteQuota: QuotaOptions{
+			PerDay: 5000,
+		},
+	}
+}
+
+func (r Rule) Check() error {
+	if r.Route == "" {
+		return badgerManager.Invalid("empty rule route")
+	}
+	if r.By != ByPrincipal && r.By != ByIp {
+		return badgerManager.Invalid("unknown rule subject, expected principal or ip : " + r.By)
+	}
+	if r.PerSecond <= 0 || r.Burst <= 0 {
+		return badgerManager.Invalid("rule needs a positive rate and burst : " + r.Route)
+	}
+	return nil
+}
+
+func (r Rule) matches(route string) bool {
+	if strings.HasSuffix(r.Route, "*") {
+		return strings.HasPrefix(route, strings.TrimSuffix(r.Route, "*"))
+	}
+	return r.Route == route
+}
+
+func (o Options) Check() error {
+	for _, r := range o.Rules {
+		err := r.Check()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Quota is the write usage of a principal for the current utc day
+type Quota struct {
+	Principal string `json:"principal"`
+	Day       string `json:"day"`
+	Used      uint64 `json:"used"`
+	// Limit is 0 when the principal is not limited
+	Limit uint64 `json:"limit"`
+	// ResetMs is when the next day starts
+	ResetMs uint64 `json:"resetMs"`
+}
+
+type bucket struct {
+	tokens   float64
+	updateMs int64
+}
+
+func serialize(v any) []byte {
+	tmp := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(tmp).Encode(v)
+	if err != nil {
+		panic(err)
+	}
+	return tmp.Bytes()
+}
+
+func deSerialize(buffer []byte, v any) error {
+	return gob.NewDecoder(bytes.NewBuffer(buffer)).Decode(v)
+}
+
+// now is replaced by the tests
+var now = time.Now
+
+type Manager struct {
+	logger      logger.ILogger
+	dbManager   *badgerManager.Manager
+	optionsLock sync.RWMutex
+	options     Options
+	bucketsLock sync.Mutex
+	buckets     map[string]*bucket
+	// quotaLock serializes the read and increment of the daily counters
+	quotaLock  sync.Mutex
+	stopSignal chan int
+	stopOnce   sync.Once
+}
+
+func NewLimitManager(l logger.ILogger, dbManager *badgerManager.Manager) *Manager {
+	return &Manager{
+		logger:      l,
+		dbManager:   dbManager,
+		optionsLock: sync.RWMutex{},
+		options:     DefaultOptions(),
+		bucketsLock: sync.Mutex{},
+		buckets:     make(map[string]*bucket),
+		quotaLock:   sync.Mutex{},
+		stopSignal:  make(chan int),
+		stopOnce:    sync.Once{},
+	}
+}
+
+// SetOptions replaces the rules, the buckets start over since they belong to the old rules
+func (p *Manager) SetOptions(options Options) error {
+	err := options.Check()
+	if err != nil {
+		return err
+	}
+	p.optionsLock.Lock()
+	p.options = options
+	p.optionsLock.Unlock()
+
+	p.bucketsLock.Lock()
+	p.buckets = make(map[string]*bucket)
+	p.bucketsLock.Unlock()
+	return nil
+}
+
+func (p *Manager) GetOptions() Options {
+	p.optionsLock.RLock()
+	defer p.optionsLock.RUnlock()
+	return p.options
+}
+
+func (p *Manager) Start() {
+	go p.loopMain()
+	<-p.stopSignal
+}
+
+func (p *Manager) Stop() {
+	p.stopOnce.Do(func() {
+		select {
+		case <-p.stopSignal:
+			return
+		default:
+
+		}
+		close(p.stopSignal)
+	})
+}
+
+func (p *Manager) loopMain() {
+	ticker := time.NewTicker(bucketCleanInterval)
+	defer ticker.Stop()
+	for true {
+		select {
+		case <-p.stopSignal:
+			return
+		case <-ticker.C:
+			p.cleanBuckets()
+		}
+	}
+}
+
+// Allow takes a token for a request to route, it returns how long to wait when the bucket is empty
+func (p *Manager) Allow(route string, ip string, principal string) (bool, time.Duration) {
+	rules := p.GetOptions().Rules
+	index := -1
+	for i, r := range rules {
+		if r.matches(route) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return true, 0
+	}
+	rule := rules[index]
+	subject := principal
+	if rule.By == ByIp || principal == "" || principal == userManager.PrincipalAnonymous {
+		subject = ByIp + ":" + ip
+	}
+	id := strconv.Itoa(index) + "|" + subject
+
+	nowMs := now().UnixMilli()
+	p.bucketsLock.Lock()
+	defer p.bucketsLock.Unlock()
+	b, ok := p.buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), updateMs: nowMs}
+		p.buckets[id] = b
+	}
+	b.tokens = math.Min(float64(rule.Burst), b.tokens+float64(nowMs-b.updateMs)/1000*rule.PerSecond)
+	b.updateMs = nowMs
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rule.PerSecond * float64(time.Second))
+	return false, wait
+}
+
+// cleanBuckets drops the buckets that refilled, a new one starts full anyway
+func (p *Manager) cleanBuckets() {
+	rules := p.GetOptions().Rules
+	nowMs := now().UnixMilli()
+	p.bucketsLock.Lock()
+	defer p.bucketsLock.Unlock()
+	for id, b := range p.buckets {
+		index, _ := strconv.Atoi(id[:strings.Index(id, "|")])
+		if index >= len(rules) {
+			delete(p.buckets, id)
+			continue
+		}
+		rule := rules[index]
+		if b.tokens+float64(nowMs-b.updateMs)/1000*rule.PerSecond >= float64(rule.Burst) {
+			delete(p.buckets, id)
+		}
+	}
+}
+
+func (p *Manager) quotaLimit(principal string) uint64 {
+	quota := p.GetOptions().WriteQuota
+	if limit, ok := quota.Overrides[principal]; ok {
+		return limit
+	}
+	return quota.PerDay
+}
+
+func currentDay() (string, uint64) {
+	t := now().UTC()
+	next := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+	return t.Format("20060102"), uint64(next.UnixMilli())
+}
+
+func (p *Manager) loadQuota(txn *badger.Txn, principal string) (Quota, error) {
+	day, resetMs := currentDay()
+	res := Quota{
+		Principal: principal,
+		Day:       day,
+		Limit:     p.quotaLimit(principal),
+		ResetMs:   resetMs,
+	}
+	item, err := txn.Get([]byte(quotaPrefix + day + "." + principal))
+	if err == badger.ErrKeyNotFound {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	err = item.Value(func(val []byte) error {
+		return deSerialize(val, &res.Used)
+	})
+	return res, err
+}
+
+// GetQuota returns the writes of principal today
+func (p *Manager) GetQuota(principal string) (Quota, error) {
+	var res Quota
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		var err error
+		res, err = p.loadQuota(txn, principal)
+		return err
+	})
+	return res, err
+}
+
+// ConsumeWrite counts a write of principal, it returns ErrQuotaExceeded with the unchanged quota once the limit is reached
+func (p *Manager) ConsumeWrite(principal string) (Quota, error) {
+	p.quotaLock.Lock()
+	defer p.quotaLock.Unlock()
+	var res Quota
+	err := p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		var err error
+		res, err = p.loadQuota(txn, principal)
+		if err != nil {
+			return err
+		}
+		if res.Limit > 0 && res.Used >= res.Limit {
+			return ErrQuotaExceeded
+		}
+		res.Used += 1
+		entry := badger.NewEntry([]byte(quotaPrefix+res.Day+"."+principal), serialize(res.Used)).WithTTL(quotaTTL)
+		return txn.SetEntry(entry)
+	})
+	return res, err
+}
@@ -0,0 +1,108 @@
+package limitManager
+
+import (
+	"go.uber.org/zap/zapcore"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger/console"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	clock := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	lm := NewLimitManager(console.NewConsoleLogger(zapcore.InfoLevel), nil)
+	err := lm.SetOptions(Options{Rules: []Rule{
+		{Route: "/v1/api/:dataset/query", By: ByPrincipal, PerSecond: 2, Burst: 3},
+		{Route: "/v1/api/user/*", By: ByIp, PerSecond: 1, Burst: 1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lm.SetOptions(Options{Rules: []Rule{{Route: "*", By: "host", PerSecond: 1, Burst: 1}}}) == nil {
+		t.Fatal("unknown subject should be refused")
+	}
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := lm.Allow("/v1/api/:dataset/query", "1.1.1.1", "user:alice"); !ok {
+			t.Fatal("burst should pass", i)
+		}
+	}
+	ok, wait := lm.Allow("/v1/api/:dataset/query", "1.1.1.1", "user:alice")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatal("empty bucket should wait for the next token", ok, wait)
+	}
+	if ok, _ = lm.Allow("/v1/api/:dataset/query", "1.1.1.1", "user:bob"); !ok {
+		t.Fatal("principals should have their own bucket")
+	}
+	clock = clock.Add(500 * time.Millisecond)
+	if ok, _ = lm.Allow("/v1/api/:dataset/query", "1.1.1.1", "user:alice"); !ok {
+		t.Fatal("bucket should refill")
+	}
+
+	// ip rules share the bucket between principals
+	if ok, _ = lm.Allow("/v1/api/user/login", "2.2.2.2", "anonymous"); !ok {
+		t.Fatal("first login should pass")
+	}
+	if ok, _ = lm.Allow("/v1/api/user/me", "2.2.2.2", "user:alice"); ok {
+		t.Fatal("same ip should be limited")
+	}
+	if ok, _ = lm.Allow("/v1/api/:dataset/insert", "2.2.2.2", "user:alice"); !ok {
+		t.Fatal("unmatched route should not be limited")
+	}
+
+	clock = clock.Add(time.Minute)
+	lm.cleanBuckets()
+	if len(lm.buckets) != 0 {
+		t.Fatal("refilled buckets should be dropped", len(lm.buckets))
+	}
+}
+
+func TestWriteQuota(t *testing.T) {
+	clock := time.Date(2022, 6, 1, 23, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	lm := NewLimitManager(l, m)
+	err := lm.SetOptions(Options{WriteQuota: QuotaOptions{PerDay: 2, Overrides: map[string]uint64{"user:root": 0}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = lm.ConsumeWrite("user:alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	quota, err := lm.ConsumeWrite("user:alice")
+	if err != ErrQuotaExceeded || quota.Used != 2 {
+		t.Fatal("third write should be refused", quota, err)
+	}
+	if quota.ResetMs != uint64(time.Date(2022, 6, 2, 0, 0, 0, 0, time.UTC).UnixMilli()) {
+		t.Fatal("quota should reset at midnight", quota.ResetMs)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = lm.ConsumeWrite("user:root"); err != nil {
+			t.Fatal("override should lift the limit", err)
+		}
+	}
+
+	// the counters are kept in the db, not in the manager
+	lm = NewLimitManager(l, m)
+	_ = lm.SetOptions(Options{WriteQuota: QuotaOptions{PerDay: 2}})
+	if _, err = lm.ConsumeWrite("user:alice"); err != ErrQuotaExceeded {
+		t.Fatal("quota should be kept after a restart", err)
+	}
+
+	clock = clock.Add(2 * time.Hour)
+	quota, err = lm.ConsumeWrite("user:alice")
+	if err != nil || quota.Used != 1 || quota.Day != "20220602" {
+		t.Fatal("quota should start over the next day", quota, err)
+	}
+}
@@ -0,0 +1,320 @@
+package matchManager
+
+import (
+	"fmt"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// RuleEqual scores 1 when both fields hold the same value, ignoring case and surrounding spaces
+	RuleEqual = "equal"
+	// RuleContains scores the share of the publisher's comma separated items found in the provider field
+	RuleContains = "contains"
+	// RuleDistance scores 1 at the same place down to 0 at MaxDistanceKm, using the record locations
+	RuleDistance = "distance"
+	// RuleRange scores 1 when the provider's number lies within the publisher's min and max fields
+	// and falls linearly to 0 at twice the max
+	RuleRange = "range"
+
+	defaultLimit = 20
+)
+
+type Rule struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	ProviderField  string `json:"providerField"`
+	PublisherField string `json:"publisherField"`
+	// PublisherMaxField is the upper bound of a range rule, PublisherField is the lower one
+	PublisherMaxField string  `json:"publisherMaxField"`
+	MaxDistanceKm     float64 `json:"maxDistanceKm"`
+	Weight            float64 `json:"weight"`
+	// Required drops candidates scoring 0 on this rule
+	Required bool `json:"required"`
+}
+
+type Options struct {
+	Rules        []Rule `json:"rules"`
+	DefaultLimit int    `json:"defaultLimit"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Rules: []Rule{
+			{Name: "category", Type: RuleEqual, ProviderField: "category", PublisherField: "category", Weight: 3, Required: true},
+			{Name: "qualification", Type: RuleContains, ProviderField: "qualification", PublisherField: "qualification", Weight: 2},
+			{Name: "location", Type: RuleDistance, MaxDistanceKm: 5, Weight: 2},
+			{Name: "budget", Type: RuleRange, ProviderField: "price", PublisherField: "budgetMin", PublisherMaxField: "budgetMax", Weight: 1},
+		},
+		DefaultLimit: defaultLimit,
+	}
+}
+
+type RuleScore struct {
+	Rule     string  `json:"rule"`
+	Type     string  `json:"type"`
+	Score    float64 `json:"score"`
+	Weight   float64 `json:"weight"`
+	Weighted float64 `json:"weighted"`
+	Detail   string  `json:"detail,omitempty"`
+}
+
+type Candidate struct {
+	Key       string           `json:"key"`
+	Score     float64          `json:"score"`
+	Breakdown []RuleScore      `json:"breakdown"`
+	Provider  dataManager.Data `json:"provider"`
+}
+
+type MatchRequest struct {
+	PublisherKey string `json:"publisherKey"`
+	Limit        int    `json:"limit"`
+	// Rules replace the configured rules for this request when given
+	Rules []Rule `json:"rules"`
+	// MatchRules prefilter the providers like Query.MatchRules
+	MatchRules []map[string]string `json:"matchRules"`
+}
+
+type Manager struct {
+	logger              logger.ILogger
+	providerDataManager *dataManager.Manager
+	publishDataManager  *dataManager.Manager
+	optionsLock         sync.RWMutex
+	options             Options
+}
+
+func NewMatchManager(l logger.ILogger, proDm *dataManager.Manager, pubDm *dataManager.Manager) *Manager {
+	return &Manager{
+		logger:              l,
+		providerDataManager: proDm,
+		publishDataManager:  pubDm,
+		optionsLock:         sync.RWMutex{},
+		options:             DefaultOptions(),
+	}
+}
+
+func (p *Manager) SetOptions(options Options) {
+	p.optionsLock.Lock()
+	defer p.optionsLock.Unlock()
+	p.options = options
+}
+
+func (p *Manager) GetOptions() Options {
+	p.optionsLock.RLock()
+	defer p.optionsLock.RUnlock()
+	return p.options
+}
+
+func validateRules(rules []Rule) error {
+	if len(rules) == 0 {
+		return badgerManager.Invalid("no match rules")
+	}
+	for _, rule := range rules {
+		if rule.Weight < 0 {
+			return badgerManager.Invalid("negative weight : " + rule.Name)
+		}
+		switch rule.Type {
+		case RuleEqual, RuleContains:
+			if rule.ProviderField == "" || rule.PublisherField == "" {
+				return badgerManager.Invalid("rule needs providerField and publisherField : " + rule.Name)
+			}
+		case RuleDistance:
+			if rule.MaxDistanceKm <= 0 {
+				return badgerManager.Invalid("distance rule needs maxDistanceKm : " + rule.Name)
+			}
+		case RuleRange:
+			if rule.ProviderField == "" || (rule.PublisherField == "" && rule.PublisherMaxField == "") {
+				return badgerManager.Invalid("range rule needs providerField and a publisher bound : " + rule.Name)
+			}
+		default:
+			return badgerManager.Invalid("unknown rule type : " + rule.Type)
+		}
+	}
+	return nil
+}
+
+func splitItems(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '，' || r == '、' || r == ';' || r == '；'
+	})
+	res := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func parseNumber(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v, err == nil
+}
+
+func scoreRule(rule Rule, provider dataManager.Data, publisher dataManager.Data) (float64, string) {
+	switch rule.Type {
+	case RuleEqual:
+		a := strings.ToLower(strings.TrimSpace(provider.Value[rule.ProviderField]))
+		b := strings.ToLower(strings.TrimSpace(publisher.Value[rule.PublisherField]))
+		if a != "" && a == b {
+			return 1, ""
+		}
+		return 0, fmt.Sprintf("%q != %q", a, b)
+	case RuleContains:
+		required := splitItems(publisher.Value[rule.PublisherField])
+		if len(required) == 0 {
+			return 1, "nothing required"
+		}
+		offered := strings.ToLower(provider.Value[rule.ProviderField])
+		found := 0
+		missing := make([]string, 0)
+		for _, item := range required {
+			if strings.Contains(offered, item) {
+				found += 1
+			} else {
+				missing = append(missing, item)
+			}
+		}
+		detail := ""
+		if len(missing) > 0 {
+			detail = "missing " + strings.Join(missing, ",")
+		}
+		return float64(found) / float64(len(required)), detail
+	case RuleDistance:
+		if provider.Location == nil || publisher.Location == nil {
+			return 0, "location unknown"
+		}
+		d := dataManager.DistanceKm(*provider.Location, *publisher.Location)
+		detail := fmt.Sprintf("%.2fkm", d)
+		if d >= rule.MaxDistanceKm {
+			return 0, detail
+		}
+		return 1 - d/rule.MaxDistanceKm, detail
+	case RuleRange:
+		price, ok := parseNumber(provider.Value[rule.ProviderField])
+		if !ok {
+			return 0, "provider value is not a number"
+		}
+		if min, ok := parseNumber(publisher.Value[rule.PublisherField]); ok && price < min {
+			// asking less than the budget floor is still affordable
+			return 1, "below budget"
+		}
+		max, ok := parseNumber(publisher.Value[rule.PublisherMaxField])
+		if !ok || price <= max {
+			return 1, ""
+		}
+		if max <= 0 {
+			return 0, "over budget"
+		}
+		score := 1 - (price-max)/max
+		if score < 0 {
+			score = 0
+		}
+		return score, "over budget"
+	}
+	return 0, "unknown rule type"
+}
+
+// Score rates provider against publisher, ok is false when a required rule scores 0
+func Score(rules []Rule, provider dataManager.Data, publisher dataManager.Data) (score float64, breakdown []RuleScore, ok bool) {
+	breakdown = make([]RuleScore, 0, len(rules))
+	totalWeight := 0.0
+	ok = true
+	for _, rule := range rules {
+		s, detail := scoreRule(rule, provider, publisher)
+		name := rule.Name
+		if name == "" {
+			name = rule.Type
+		}
+		breakdown = append(breakdown, RuleScore{
+			Rule:     name,
+			Type:     rule.Type,
+			Score:    s,
+			Weight:   rule.Weight,
+			Weighted: s * rule.Weight,
+			Detail:   detail,
+		})
+		score += s * rule.Weight
+		totalWeight += rule.Weight
+		if rule.Required && s <= 0 {
+			ok = false
+		}
+	}
+	if totalWeight > 0 {
+		score /= totalWeight
+	}
+	return score, breakdown, ok
+}
+
+// Match ranks the visible providers against one publisher record
+func (p *Manager) Match(req MatchRequest) ([]Candidate, error) {
+	options := p.GetOptions()
+	rules := options.Rules
+	if len(req.Rules) > 0 {
+		rules = req.Rules
+	}
+	err := validateRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = options.DefaultLimit
+	}
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	publisher, exists, err := p.publishDataManager.GetData(req.PublisherKey)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, badgerManager.NotFound("publisher record not found : " + req.PublisherKey)
+	}
+
+	query := dataManager.Query{
+		MatchRules: req.MatchRules,
+	}
+	// a required distance rule lets the geo index drop far providers before scoring
+	for _, rule := range rules {
+		if rule.Type == RuleDistance && rule.Required && publisher.Location != nil {
+			query.Near = &dataManager.GeoRadius{
+				Lat:      publisher.Location.Lat,
+				Lng:      publisher.Location.Lng,
+				RadiusKm: rule.MaxDistanceKm,
+			}
+		}
+	}
+	providers, _, _, err := p.providerDataManager.QueryData(query)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]Candidate, 0)
+	for _, provider := range providers {
+		score, breakdown, ok := Score(rules, provider.Data, publisher)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, Candidate{
+			Key:       provider.Key,
+			Score:     score,
+			Breakdown: breakdown,
+			Provider:  provider.Data,
+		})
+	}
+	// providers come in ranking order, so equal scores keep the better ranked provider first
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
@@ -0,0 +1,107 @@
+package matchManager
+
+import (
+	"go.uber.org/zap/zapcore"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger/console"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"testing"
+	"time"
+)
+
+func TestScore(t *testing.T) {
+	rules := DefaultOptions().Rules
+	publisher := dataManager.Data{
+		Key:      "job",
+		Value:    map[string]string{"category": "Cleaning", "qualification": "first aid, driving", "budgetMin": "50", "budgetMax": "100"},
+		Location: &dataManager.GeoPoint{Lat: 31.23, Lng: 121.47},
+	}
+
+	provider := dataManager.Data{
+		Key:      "a",
+		Value:    map[string]string{"category": "cleaning ", "qualification": "Driving", "price": "150"},
+		Location: &dataManager.GeoPoint{Lat: 31.23, Lng: 121.47},
+	}
+	score, breakdown, ok := Score(rules, provider, publisher)
+	if !ok || len(breakdown) != len(rules) {
+		t.Fatal("unexpected result", ok, breakdown)
+	}
+	// category 1*3 + qualification 0.5*2 + location 1*2 + budget 0.5*1 over weight 8
+	if score < 0.812 || score > 0.813 {
+		t.Fatal("unexpected score", score, breakdown)
+	}
+
+	provider.Value["category"] = "gardening"
+	_, _, ok = Score(rules, provider, publisher)
+	if ok {
+		t.Fatal("failing a required rule should drop the candidate")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	proDm := dataManager.NewDataManager(l, "provider.", m)
+	go proDm.Start()
+	defer proDm.Stop()
+	pubDm := dataManager.NewDataManager(l, "publisher.", m)
+	go pubDm.Start()
+	defer pubDm.Stop()
+
+	_, err := pubDm.InsertData([]dataManager.Data{{
+		Key:      "job",
+		Value:    map[string]string{"category": "cleaning", "qualification": "driving", "budgetMax": "100"},
+		Location: &dataManager.GeoPoint{Lat: 31.23, Lng: 121.47},
+	}}, dataManager.InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = proDm.InsertData([]dataManager.Data{
+		{Key: "near", Value: map[string]string{"category": "cleaning", "qualification": "driving", "price": "80"}, Location: &dataManager.GeoPoint{Lat: 31.231, Lng: 121.471}},
+		{Key: "far", Value: map[string]string{"category": "cleaning", "qualification": "driving", "price": "80"}, Location: &dataManager.GeoPoint{Lat: 31.26, Lng: 121.47}},
+		{Key: "expensive", Value: map[string]string{"category": "cleaning", "price": "300"}, Location: &dataManager.GeoPoint{Lat: 31.23, Lng: 121.47}},
+		{Key: "other", Value: map[string]string{"category": "gardening", "qualification": "driving", "price": "80"}, Location: &dataManager.GeoPoint{Lat: 31.23, Lng: 121.47}},
+	}, dataManager.InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mm := NewMatchManager(l, proDm, pubDm)
+	var candidates []Candidate
+	for i := 0; i < 50; i++ {
+		candidates, err = mm.Match(MatchRequest{PublisherKey: "job"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) == 3 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(candidates) != 3 {
+		t.Fatal("unexpected candidates", candidates)
+	}
+	if candidates[0].Key != "near" || candidates[1].Key != "far" || candidates[2].Key != "expensive" {
+		t.Fatal("unexpected order", candidates[0].Key, candidates[1].Key, candidates[2].Key)
+	}
+	if candidates[0].Provider.Key != "near" || len(candidates[0].Breakdown) != 4 {
+		t.Fatal("unexpected candidate", candidates[0])
+	}
+
+	candidates, err = mm.Match(MatchRequest{PublisherKey: "job", Limit: 1})
+	if err != nil || len(candidates) != 1 {
+		t.Fatal("limit not applied", err, candidates)
+	}
+
+	_, err = mm.Match(MatchRequest{PublisherKey: "missing"})
+	if err == nil {
+		t.Fatal("missing publisher should fail")
+	}
+	_, err = mm.Match(MatchRequest{PublisherKey: "job", Rules: []Rule{{Type: "unknown"}}})
+	if err == nil {
+		t.Fatal("unknown rule type should fail")
+	}
+}
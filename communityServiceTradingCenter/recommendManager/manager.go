@@ -0,0 +1,747 @@
+package recommendManager
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"go.uber.org/zap"
+	"math"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SignalView    = "view"
+	SignalClick   = "click"
+	SignalContact = "contact"
+	SignalDeal    = "deal"
+
+	ScopeCategory = "category:"
+	ScopeUser     = "user:"
+	// ScopeAll in an exclude override hides the item from every scope
+	ScopeAll = "*"
+
+	OverridePin     = "pin"
+	OverrideExclude = "exclude"
+
+	SourceAuto     = "auto"
+	SourceOverride = "override"
+
+	// generated recommender keys start with EntryKeyPrefix, other keys are never touched by the job
+	EntryKeyPrefix = "auto."
+
+	signalPrefix   = "_recommend.signal."
+	affinityPrefix = "_recommend.user."
+	overridePrefix = "_recommend.override."
+	generatedKey   = "_recommend.generated"
+	keySeparator   = "\x00"
+)
+
+type Options struct {
+	IntervalMinutes int `json:"intervalMinutes"`
+	// Sources are the datasets recommended from, entries reference them through the <dataset>Key field
+	Sources       []string `json:"sources"`
+	CategoryField string   `json:"categoryField"`
+	PerScope      int      `json:"perScope"`
+	// UserCategories is how many of a user's favourite categories feed the user scope
+	UserCategories int                `json:"userCategories"`
+	PriorityWeight float64            `json:"priorityWeight"`
+	SignalWeights  map[string]float64 `json:"signalWeights"`
+	// SignalHalfLifeHours decays interaction signals, they never decay when 0
+	SignalHalfLifeHours float64 `json:"signalHalfLifeHours"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		IntervalMinutes: 60,
+		Sources:         []string{"provider", "publisher"},
+		CategoryField:   "category",
+		PerScope:        10,
+		UserCategories:  3,
+		PriorityWeight:  1,
+		SignalWeights: map[string]float64{
+			SignalView:    1,
+			SignalClick:   3,
+			SignalContact: 10,
+			SignalDeal:    30,
+		},
+		SignalHalfLifeHours: 24 * 7,
+	}
+}
+
+type Signal struct {
+	Dataset string `json:"dataset"`
+	Key     string `json:"key"`
+	// User is optional, it builds the category affinity behind the user scope
+	User string `json:"user"`
+	Kind string `json:"kind"`
+}
+
+type Override struct {
+	Scope   string `json:"scope"`
+	Dataset string `json:"dataset"`
+	Key     string `json:"key"`
+	Action  string `json:"action"`
+	// Priority of a pinned entry, it goes above every generated entry of the scope when 0
+	Priority     uint64 `json:"priority"`
+	Note         string `json:"note"`
+	CreateTimeMs uint64 `json:"createTimeMs"`
+}
+
+type Entry struct {
+	Key      string  `json:"key"`
+	Scope    string  `json:"scope"`
+	Dataset  string  `json:"dataset"`
+	ItemKey  string  `json:"itemKey"`
+	Category string  `json:"category"`
+	Score    float64 `json:"score"`
+	Priority uint64  `json:"priority"`
+	Source   string  `json:"source"`
+}
+
+type Plan struct {
+	Entries []Entry `json:"entries"`
+	// Changed are the entries that differ from the stored ones
+	Changed []string `json:"changed"`
+	// Removed are entries of the last run that are no longer recommended
+	Removed []string `json:"removed"`
+	TimeMs  uint64   `json:"timeMs"`
+}
+
+// decayed is a score that halves every half life since UpdateMs
+type decayed struct {
+	Score    float64
+	UpdateMs uint64
+}
+
+func (d decayed) at(now uint64, halfLifeHours float64) float64 {
+	if halfLifeHours <= 0 || d.UpdateMs >= now {
+		return d.Score
+	}
+	ageHours := float64(now-d.UpdateMs) / float64(time.Hour/time.Millisecond)
+	return d.Score * math.Pow(0.5, ageHours/halfLifeHours)
+}
+
+func (d decayed) add(v float64, now uint64, halfLifeHours float64) decayed {
+	return decayed{Score: d.at(now, halfLifeHours) + v, UpdateMs: now}
+}
+
+func init() {
+	gob.Register(decayed{})
+	gob.Register(Override{})
+}
+
+func serialize(v any) []byte {
+	tmp := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(tmp).Encode(v)
+	if err != nil {
+		panic(err)
+	}
+	return tmp.Bytes()
+}
+
+func deSerialize(buffer []byte, v any) error {
+	return gob.NewDecoder(bytes.NewBuffer(buffer)).Decode(v)
+}
+
+var nowMs = func() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+type Manager struct {
+	logger                 logger.ILogger
+	dbManager              *badgerManager.Manager
+	directory              *dataManager.Directory
+	recommenderDataManager *dataManager.Manager
+	optionsLock            sync.RWMutex
+	options                Options
+	runLock                sync.Mutex
+	runSignal              chan int
+	stopSignal             chan int
+	stopOnce               sync.Once
+}
+
+func NewRecommendManager(l logger.ILogger, dbManager *badgerManager.Manager, directory *dataManager.Directory, recDm *dataManager.Manager) *Manager {
+	return &Manager{
+		logger:                 l,
+		dbManager:              dbManager,
+		directory:              directory,
+		recommenderDataManager: recDm,
+		optionsLock:            sync.RWMutex{},
+		options:                DefaultOptions(),
+		runLock:                sync.Mutex{},
+		runSignal:              make(chan int, 1),
+		stopSignal:             make(chan int),
+		stopOnce:               sync.Once{},
+	}
+}
+
+func (p *Manager) SetOptions(options Options) {
+	p.optionsLock.Lock()
+	defer p.optionsLock.Unlock()
+	p.options = options
+}
+
+func (p *Manager) GetOptions() Options {
+	p.optionsLock.RLock()
+	defer p.optionsLock.RUnlock()
+	return p.options
+}
+
+func (p *Manager) Start() {
+	go p.loopMain()
+	<-p.stopSignal
+}
+
+func (p *Manager) Stop() {
+	p.stopOnce.Do(func() {
+		select {
+		case <-p.stopSignal:
+			return
+		default:
+
+		}
+		close(p.stopSignal)
+	})
+}
+
+func (p *Manager) loopMain() {
+	interval := time.Duration(p.GetOptions().IntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for true {
+		select {
+		case <-p.stopSignal:
+			return
+		case <-ticker.C:
+			p.runAndLog()
+		case <-p.runSignal:
+			p.runAndLog()
+		}
+	}
+}
+
+func (p *Manager) runAndLog() {
+	plan, err := p.Run()
+	if err != nil {
+		p.logger.Error("recommendation run failed", zap.Error(err))
+		return
+	}
+	p.logger.Info("recommendation run finished",
+		zap.Int("entries", len(plan.Entries)),
+		zap.Int("changed", len(plan.Changed)),
+		zap.Int("removed", len(plan.Removed)))
+}
+
+// RequestRun asks the job loop to run soon without waiting for it
+func (p *Manager) RequestRun() {
+	select {
+	case p.runSignal <- 1:
+	default:
+	}
+}
+
+func signalKey(dataset string, key string) []byte {
+	return []byte(signalPrefix + dataset + keySeparator + key)
+}
+
+func affinityKey(user string) []byte {
+	return []byte(affinityPrefix + user)
+}
+
+func overrideKey(scope string, dataset string, key string) []byte {
+	return []byte(overridePrefix + scope + keySeparator + dataset + keySeparator + key)
+}
+
+func refField(dataset string) string {
+	return dataset + "Key"
+}
+
+func (p *Manager) source(dataset string) (*dataManager.Manager, error) {
+	for _, s := range p.GetOptions().Sources {
+		if s != dataset {
+			continue
+		}
+		dm, ok := p.directory.Get(dataset)
+		if !ok {
+			return nil, errors.New("unknown dataset : " + dataset)
+		}
+		return dm, nil
+	}
+	return nil, badgerManager.Invalid("dataset is not a recommendation source : " + dataset)
+}
+
+func loadGob(txn *badger.Txn, key []byte, v any) (bool, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, item.Value(func(val []byte) error {
+		return deSerialize(val, v)
+	})
+}
+
+// RecordSignal adds one interaction with a record to its score and to the user's category affinity
+func (p *Manager) RecordSignal(signal Signal) error {
+	options := p.GetOptions()
+	weight, ok := options.SignalWeights[signal.Kind]
+	if !ok {
+		return badgerManager.Invalid("unknown signal kind : " + signal.Kind)
+	}
+	dm, err := p.source(signal.Dataset)
+	if err != nil {
+		return err
+	}
+	data, exists, err := dm.GetData(signal.Key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return badgerManager.NotFound("key not found : " + signal.Key)
+	}
+	category := data.Value[options.CategoryField]
+	now := nowMs()
+
+	return p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		var itemScore decayed
+		_, err := loadGob(txn, signalKey(signal.Dataset, signal.Key), &itemScore)
+		if err != nil {
+			return err
+		}
+		itemScore = itemScore.add(weight, now, options.SignalHalfLifeHours)
+		err = txn.Set(signalKey(signal.Dataset, signal.Key), serialize(itemScore))
+		if err != nil {
+			return err
+		}
+		if signal.User == "" || category == "" {
+			return nil
+		}
+		affinity := make(map[string]decayed)
+		_, err = loadGob(txn, affinityKey(signal.User), &affinity)
+		if err != nil {
+			return err
+		}
+		affinity[category] = affinity[category].add(weight, now, options.SignalHalfLifeHours)
+		return txn.Set(affinityKey(signal.User), serialize(affinity))
+	})
+}
+
+func (p *Manager) SetOverride(override Override) error {
+	if override.Scope == "" {
+		return badgerManager.Invalid("empty scope")
+	}
+	switch override.Action {
+	case OverridePin:
+		if override.Scope == ScopeAll {
+			return badgerManager.Invalid("pin needs a single scope")
+		}
+	case OverrideExclude:
+	default:
+		return badgerManager.Invalid("unknown override action : " + override.Action)
+	}
+	dm, err := p.source(override.Dataset)
+	if err != nil {
+		return err
+	}
+	_, exists, err := dm.GetData(override.Key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return badgerManager.NotFound("key not found : " + override.Key)
+	}
+	override.CreateTimeMs = nowMs()
+	return p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		return txn.Set(overrideKey(override.Scope, override.Dataset, override.Key), serialize(override))
+	})
+}
+
+func (p *Manager) ListOverrides(scope string) ([]Override, error) {
+	prefix := overridePrefix
+	if scope != "" {
+		prefix += scope + keySeparator
+	}
+	res := make([]Override, 0)
+	var innerErr error
+	err := p.dbManager.IterateData(func(key []byte, value []byte) {
+		var override Override
+		if err := deSerialize(value, &override); err != nil {
+			innerErr = err
+			return
+		}
+		res = append(res, override)
+	}, []byte(prefix))
+	if err != nil {
+		return nil, err
+	}
+	return res, innerErr
+}
+
+func (p *Manager) RemoveOverride(scope string, dataset string, key string) error {
+	return p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		_, err := txn.Get(overrideKey(scope, dataset, key))
+		if err == badger.ErrKeyNotFound {
+			return badgerManager.NotFound("override not found")
+		}
+		if err != nil {
+			return err
+		}
+		return txn.Delete(overrideKey(scope, dataset, key))
+	})
+}
+
+type item struct {
+	dataset  string
+	data     dataManager.Data
+	category string
+	score    float64
+}
+
+func itemId(dataset string, key string) string {
+	return dataset + keySeparator + key
+}
+
+func entryKey(scope string, dataset string, key string) string {
+	return EntryKeyPrefix + scope + "." + dataset + "." + key
+}
+
+// Preview computes the entries the next run would write without writing them
+func (p *Manager) Preview() (*Plan, error) {
+	return p.plan()
+}
+
+func (p *Manager) plan() (*Plan, error) {
+	options := p.GetOptions()
+	perScope := options.PerScope
+	if perScope <= 0 {
+		perScope = DefaultOptions().PerScope
+	}
+	now := nowMs()
+
+	items := make(map[string]*item)
+	byCategory := make(map[string][]*item)
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		for _, dataset := range options.Sources {
+			dm, ok := p.directory.Get(dataset)
+			if !ok {
+				return errors.New("unknown dataset : " + dataset)
+			}
+			records, _, _, err := dm.QueryData(dataManager.Query{})
+			if err != nil {
+				return err
+			}
+			for _, record := range records {
+				category := record.Value[options.CategoryField]
+				if category == "" {
+					continue
+				}
+				var signal decayed
+				_, err = loadGob(txn, signalKey(dataset, record.Key), &signal)
+				if err != nil {
+					return err
+				}
+				it := &item{
+					dataset:  dataset,
+					data:     record.Data,
+					category: category,
+					score:    options.PriorityWeight*record.Score + signal.at(now, options.SignalHalfLifeHours),
+				}
+				items[itemId(dataset, record.Key)] = it
+				byCategory[category] = append(byCategory[category], it)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	affinities := make(map[string]map[string]decayed)
+	err = p.dbManager.IterateData(func(key []byte, value []byte) {
+		affinity := make(map[string]decayed)
+		if deSerialize(value, &affinity) == nil {
+			affinities[string(key[len(affinityPrefix):])] = affinity
+		}
+	}, []byte(affinityPrefix))
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := p.ListOverrides("")
+	if err != nil {
+		return nil, err
+	}
+
+	// scored candidates per scope before overrides
+	scopes := make(map[string]map[string]float64)
+	for category, list := range byCategory {
+		scope := make(map[string]float64, len(list))
+		for _, it := range list {
+			scope[itemId(it.dataset, it.data.Key)] = it.score
+		}
+		scopes[ScopeCategory+category] = scope
+	}
+	for user, affinity := range affinities {
+		categories := make([]string, 0, len(affinity))
+		total := 0.0
+		for category, d := range affinity {
+			if _, ok := byCategory[category]; !ok {
+				continue
+			}
+			categories = append(categories, category)
+			total += d.at(now, options.SignalHalfLifeHours)
+		}
+		if total <= 0 {
+			continue
+		}
+		sort.Slice(categories, func(i, j int) bool {
+			return affinity[categories[i]].at(now, options.SignalHalfLifeHours) > affinity[categories[j]].at(now, options.SignalHalfLifeHours)
+		})
+		if options.UserCategories > 0 && len(categories) > options.UserCategories {
+			categories = categories[:options.UserCategories]
+		}
+		scope := make(map[string]float64)
+		for _, category := range categories {
+			share := affinity[category].at(now, options.SignalHalfLifeHours) / total
+			for _, it := range byCategory[category] {
+				scope[itemId(it.dataset, it.data.Key)] = it.score * share
+			}
+		}
+		scopes[ScopeUser+user] = scope
+	}
+
+	excluded := make(map[string]map[string]struct{})
+	pins := make(map[string][]Override)
+	for _, override := range overrides {
+		switch override.Action {
+		case OverrideExclude:
+			if excluded[override.Scope] == nil {
+				excluded[override.Scope] = make(map[string]struct{})
+			}
+			excluded[override.Scope][itemId(override.Dataset, override.Key)] = struct{}{}
+		case OverridePin:
+			pins[override.Scope] = append(pins[override.Scope], override)
+		}
+	}
+	isExcluded := func(scope string, id string) bool {
+		if _, ok := excluded[ScopeAll][id]; ok {
+			return true
+		}
+		_, ok := excluded[scope][id]
+		return ok
+	}
+
+	plan := &Plan{
+		Entries: make([]Entry, 0),
+		Changed: make([]string, 0),
+		Removed: make([]string, 0),
+		TimeMs:  now,
+	}
+	scopeNames := make([]string, 0, len(scopes)+len(pins))
+	for scope := range scopes {
+		scopeNames = append(scopeNames, scope)
+	}
+	for scope := range pins {
+		if _, ok := scopes[scope]; !ok {
+			scopeNames = append(scopeNames, scope)
+		}
+	}
+	sort.Strings(scopeNames)
+
+	for _, scope := range scopeNames {
+		ids := make([]string, 0, len(scopes[scope]))
+		for id := range scopes[scope] {
+			if !isExcluded(scope, id) {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			if scopes[scope][ids[i]] != scopes[scope][ids[j]] {
+				return scopes[scope][ids[i]] > scopes[scope][ids[j]]
+			}
+			return ids[i] < ids[j]
+		})
+		if len(ids) > perScope {
+			ids = ids[:perScope]
+		}
+
+		entries := make([]Entry, 0, len(ids)+len(pins[scope]))
+		top := uint64(0)
+		for _, id := range ids {
+			it := items[id]
+			priority := uint64(math.Round(scopes[scope][id]))
+			if priority > top {
+				top = priority
+			}
+			entries = append(entries, Entry{
+				Key:      entryKey(scope, it.dataset, it.data.Key),
+				Scope:    scope,
+				Dataset:  it.dataset,
+				ItemKey:  it.data.Key,
+				Category: it.category,
+				Score:    scopes[scope][id],
+				Priority: priority,
+				Source:   SourceAuto,
+			})
+		}
+		for _, pin := range pins[scope] {
+			key := entryKey(scope, pin.Dataset, pin.Key)
+			priority := pin.Priority
+			if priority == 0 {
+				priority = top + 1
+			}
+			category := ""
+			if it, ok := items[itemId(pin.Dataset, pin.Key)]; ok {
+				category = it.category
+			}
+			entry := Entry{
+				Key:      key,
+				Scope:    scope,
+				Dataset:  pin.Dataset,
+				ItemKey:  pin.Key,
+				Category: category,
+				Priority: priority,
+				Source:   SourceOverride,
+			}
+			replaced := false
+			for i := range entries {
+				if entries[i].Key == key {
+					entry.Score = entries[i].Score
+					entries[i] = entry
+					replaced = true
+				}
+			}
+			if !replaced {
+				entries = append(entries, entry)
+			}
+		}
+		plan.Entries = append(plan.Entries, entries...)
+	}
+
+	// compare with the stored entries so unchanged ones are not rewritten on every run
+	wanted := make(map[string]struct{}, len(plan.Entries))
+	for _, entry := range plan.Entries {
+		wanted[entry.Key] = struct{}{}
+		old, exists, err := p.recommenderDataManager.GetData(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists || old.Priority != entry.Priority || !sameValue(old.Value, entry.value()) {
+			plan.Changed = append(plan.Changed, entry.Key)
+		}
+	}
+	generated, err := p.generated()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range generated {
+		if _, ok := wanted[key]; !ok {
+			plan.Removed = append(plan.Removed, key)
+		}
+	}
+	return plan, nil
+}
+
+func (e Entry) value() map[string]string {
+	return map[string]string{
+		"scope":             e.Scope,
+		"dataset":           e.Dataset,
+		refField(e.Dataset): e.ItemKey,
+		"category":          e.Category,
+		"source":            e.Source,
+		"score":             strconv.FormatFloat(e.Score, 'f', 2, 64),
+	}
+}
+
+func sameValue(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Manager) generated() ([]string, error) {
+	res := make([]string, 0)
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		_, err := loadGob(txn, []byte(generatedKey), &res)
+		return err
+	})
+	return res, err
+}
+
+// Run computes the recommendations and writes the changed entries into the recommender dataset
+func (p *Manager) Run() (*Plan, error) {
+	p.runLock.Lock()
+	defer p.runLock.Unlock()
+
+	plan, err := p.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]struct{}, len(plan.Changed))
+	for _, key := range plan.Changed {
+		changed[key] = struct{}{}
+	}
+	list := make([]dataManager.Data, 0, len(plan.Changed))
+	for _, entry := range plan.Entries {
+		if _, ok := changed[entry.Key]; !ok {
+			continue
+		}
+		list = append(list, dataManager.Data{
+			Key:      entry.Key,
+			Value:    entry.value(),
+			Priority: entry.Priority,
+		})
+	}
+	written := make([]string, 0, len(plan.Entries))
+	failed := make(map[string]struct{})
+	if len(list) > 0 {
+		results, err := p.recommenderDataManager.InsertData(list, dataManager.InsertOption{BestEffort: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if result.Status.Failed() {
+				// usually the item was deleted since the plan was made
+				failed[result.Key] = struct{}{}
+				p.logger.Warn("write recommendation failed", zap.String("key", result.Key), zap.String("message", result.Message))
+			}
+		}
+	}
+	for _, entry := range plan.Entries {
+		if _, ok := failed[entry.Key]; !ok {
+			written = append(written, entry.Key)
+		}
+	}
+
+	if len(plan.Removed) > 0 {
+		err = p.recommenderDataManager.DeleteData(plan.Removed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		return txn.Set([]byte(generatedKey), serialize(written))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
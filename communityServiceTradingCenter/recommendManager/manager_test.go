@@ -0,0 +1,155 @@
+package recommendManager
+
+import (
+	"go.uber.org/zap/zapcore"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger/console"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"testing"
+	"time"
+)
+
+func waitForCount(t *testing.T, dm *dataManager.Manager, count int) {
+	for i := 0; i < 50; i++ {
+		_, n, _, err := dm.QueryData(dataManager.Query{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == count {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("dataset did not reach", count, "records")
+}
+
+func entryKeys(plan *Plan, scope string) []string {
+	res := make([]string, 0)
+	for _, entry := range plan.Entries {
+		if entry.Scope == scope {
+			res = append(res, entry.ItemKey)
+		}
+	}
+	return res
+}
+
+func TestRecommendationRun(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	directory := dataManager.NewDirectory()
+	proDm := dataManager.NewDataManager(l, "provider.", m)
+	directory.Add("provider", proDm)
+	go proDm.Start()
+	defer proDm.Stop()
+	recDm := dataManager.NewDataManager(l, "recommender.", m)
+	recDm.SetOptions(dataManager.Options{
+		References: []dataManager.Reference{
+			{Field: "providerKey", Dataset: "provider", OnDelete: dataManager.OnDeleteCascade},
+		},
+	})
+	directory.Add("recommender", recDm)
+	go recDm.Start()
+	defer recDm.Stop()
+
+	_, err := proDm.InsertData([]dataManager.Data{
+		{Key: "a", Value: map[string]string{"category": "cleaning"}, Priority: 10},
+		{Key: "b", Value: map[string]string{"category": "cleaning"}, Priority: 5},
+		{Key: "c", Value: map[string]string{"category": "cleaning"}, Priority: 1},
+		{Key: "d", Value: map[string]string{"category": "repair"}, Priority: 1},
+		{Key: "e", Value: map[string]string{}, Priority: 100},
+	}, dataManager.InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCount(t, proDm, 5)
+
+	rm := NewRecommendManager(l, m, directory, recDm)
+	options := DefaultOptions()
+	options.Sources = []string{"provider"}
+	options.PerScope = 2
+	rm.SetOptions(options)
+
+	err = rm.RecordSignal(Signal{Dataset: "provider", Key: "c", User: "bob", Kind: SignalDeal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rm.RecordSignal(Signal{Dataset: "provider", Key: "c", Kind: "unknown"}) == nil {
+		t.Fatal("unknown signal kind should fail")
+	}
+
+	plan, err := rm.Preview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// c gets 30 from the deal signal and passes a
+	if keys := entryKeys(plan, "category:cleaning"); len(keys) != 2 || keys[0] != "c" || keys[1] != "a" {
+		t.Fatal("unexpected cleaning entries", keys)
+	}
+	if keys := entryKeys(plan, "user:bob"); len(keys) != 2 || keys[0] != "c" {
+		t.Fatal("unexpected user entries", keys)
+	}
+	if _, exists, _ := recDm.GetData(plan.Entries[0].Key); exists {
+		t.Fatal("preview should not write")
+	}
+
+	plan, err = rm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changed) != len(plan.Entries) || len(plan.Entries) != 5 {
+		t.Fatal("unexpected plan", plan)
+	}
+	entry, exists, err := recDm.GetData(entryKey("category:cleaning", "provider", "c"))
+	if err != nil || !exists {
+		t.Fatal("entry not written", err)
+	}
+	if entry.Value["providerKey"] != "c" || entry.Priority != 31 || entry.Value["source"] != SourceAuto {
+		t.Fatal("unexpected entry", entry)
+	}
+
+	plan, err = rm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changed) != 0 || len(plan.Removed) != 0 {
+		t.Fatal("unchanged run should not write", plan.Changed, plan.Removed)
+	}
+
+	err = rm.SetOverride(Override{Scope: ScopeAll, Dataset: "provider", Key: "c", Action: OverrideExclude})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rm.SetOverride(Override{Scope: "category:repair", Dataset: "provider", Key: "e", Action: OverridePin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err = rm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := entryKeys(plan, "category:cleaning"); len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatal("exclude not applied", keys)
+	}
+	if keys := entryKeys(plan, "category:repair"); len(keys) != 2 || keys[1] != "e" {
+		t.Fatal("pin not applied", keys)
+	}
+	if _, exists, _ = recDm.GetData(entryKey("category:cleaning", "provider", "c")); exists {
+		t.Fatal("excluded entry should be removed")
+	}
+	pinned, _, _ := recDm.GetData(entryKey("category:repair", "provider", "e"))
+	if pinned.Priority != 2 || pinned.Value["source"] != SourceOverride {
+		t.Fatal("unexpected pinned entry", pinned)
+	}
+
+	err = rm.RemoveOverride(ScopeAll, "provider", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := rm.ListOverrides("")
+	if err != nil || len(overrides) != 1 {
+		t.Fatal("unexpected overrides", err, overrides)
+	}
+}
@@ -0,0 +1,277 @@
+package userManager
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"go.uber.org/zap"
+	"moonlighting/common/database/badgerManager"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyPrefix = "_user.apikey."
+
+	apiKeyIdBytes      = 8
+	usageFlushInterval = time.Minute
+)
+
+var ErrInvalidApiKey = errors.New("invalid, revoked or expired api key")
+
+// ApiKey is the public part of a key, the secret is only returned when the key is created or rotated
+type ApiKey struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Owner is the user who created the key, the key has the owner's permissions plus those of the roles
+	// an admin binds to the key itself, so a key binding can grant more than the owner has
+	Owner string `json:"owner"`
+	// Scopes limit the key to these "<dataset>:<action>" permissions, every permission of the owner is kept when empty
+	Scopes       []string `json:"scopes"`
+	CreateTimeMs uint64   `json:"createTimeMs"`
+	RotateTimeMs uint64   `json:"rotateTimeMs,omitempty"`
+	// ExpireMs is 0 for keys that never expire
+	ExpireMs     uint64 `json:"expireMs,omitempty"`
+	RevokeTimeMs uint64 `json:"revokeTimeMs,omitempty"`
+	LastUsedMs   uint64 `json:"lastUsedMs,omitempty"`
+	RequestCount uint64 `json:"requestCount"`
+}
+
+type apiKey struct {
+	ApiKey
+	SecretHash string
+}
+
+// CreatedApiKey carries the full key, "<id>.<secret>", which cannot be read again later
+type CreatedApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
+
+type keyUsage struct {
+	count      uint64
+	lastUsedMs uint64
+}
+
+func (k ApiKey) usable(now uint64) bool {
+	return k.RevokeTimeMs == 0 && (k.ExpireMs == 0 || k.ExpireMs > now)
+}
+
+// scoped tells if the scopes of the key cover action on dataset
+func (k ApiKey) scoped(dataset string, action string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	return Role{Permissions: k.Scopes}.grants(dataset, action)
+}
+
+func (p *Manager) loadApiKey(txn *badger.Txn, id string) (apiKey, bool, error) {
+	var res apiKey
+	exists, err := loadItem(txn, apiKeyPrefix+id, &res)
+	return res, exists, err
+}
+
+// CreateApiKey issues a key for owner, ttl 0 never expires
+func (p *Manager) CreateApiKey(owner string, name string, scopes []string, ttl time.Duration) (CreatedApiKey, error) {
+	err := (Role{Name: "scope", Permissions: scopes}).Check()
+	if err != nil {
+		return CreatedApiKey{}, err
+	}
+	now := nowMs()
+	k := apiKey{
+		ApiKey: ApiKey{
+			Id:           newToken()[:apiKeyIdBytes*2],
+			Name:         name,
+			Owner:        owner,
+			Scopes:       scopes,
+			CreateTimeMs: now,
+		},
+	}
+	if ttl > 0 {
+		k.ExpireMs = now + uint64(ttl/time.Millisecond)
+	}
+	secret := newToken()
+	k.SecretHash = hashToken(secret)
+	err = p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		_, exists, err := p.loadAccount(txn, owner)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return badgerManager.NotFound("user not found : " + owner)
+		}
+		return txn.Set([]byte(apiKeyPrefix+k.Id), serialize(k))
+	})
+	if err != nil {
+		return CreatedApiKey{}, err
+	}
+	return CreatedApiKey{ApiKey: k.ApiKey, Key: k.Id + "." + secret}, nil
+}
+
+// ListApiKeys lists the keys of owner, every key when owner is empty
+func (p *Manager) ListApiKeys(owner string) ([]ApiKey, error) {
+	res := make([]ApiKey, 0)
+	var innerErr error
+	err := p.dbManager.IterateData(func(key []byte, value []byte) {
+		var k apiKey
+		if err := deSerialize(value, &k); err != nil {
+			innerErr = err
+			return
+		}
+		if owner == "" || k.Owner == owner {
+			res = append(res, p.withUsage(k.ApiKey))
+		}
+	}, []byte(apiKeyPrefix))
+	if err == nil {
+		err = innerErr
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreateTimeMs < res[j].CreateTimeMs
+	})
+	return res, err
+}
+
+// GetApiKey returns the key with the usage not yet written to the db
+func (p *Manager) GetApiKey(id string) (ApiKey, error) {
+	var k apiKey
+	var exists bool
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		var err error
+		k, exists, err = p.loadApiKey(txn, id)
+		return err
+	})
+	if err != nil {
+		return ApiKey{}, err
+	}
+	if !exists {
+		return ApiKey{}, badgerManager.NotFound("api key not found : " + id)
+	}
+	return p.withUsage(k.ApiKey), nil
+}
+
+func (p *Manager) updateApiKey(id string, f func(k *apiKey) error) error {
+	return p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		k, exists, err := p.loadApiKey(txn, id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return badgerManager.NotFound("api key not found : " + id)
+		}
+		err = f(&k)
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte(apiKeyPrefix+id), serialize(k))
+	})
+}
+
+// RevokeApiKey disables the key for good, it stays listed with its usage
+func (p *Manager) RevokeApiKey(id string) error {
+	return p.updateApiKey(id, func(k *apiKey) error {
+		if k.RevokeTimeMs == 0 {
+			k.RevokeTimeMs = nowMs()
+		}
+		return nil
+	})
+}
+
+// RotateApiKey replaces the secret of a live key, the old secret stops working at once
+func (p *Manager) RotateApiKey(id string) (CreatedApiKey, error) {
+	secret := newToken()
+	var res ApiKey
+	err := p.updateApiKey(id, func(k *apiKey) error {
+		if !k.usable(nowMs()) {
+			return ErrInvalidApiKey
+		}
+		k.SecretHash = hashToken(secret)
+		k.RotateTimeMs = nowMs()
+		res = k.ApiKey
+		return nil
+	})
+	if err != nil {
+		return CreatedApiKey{}, err
+	}
+	return CreatedApiKey{ApiKey: p.withUsage(res), Key: id + "." + secret}, nil
+}
+
+// AuthenticateApiKey checks a full key and counts the request
+func (p *Manager) AuthenticateApiKey(key string) (ApiKey, error) {
+	i := strings.Index(key, ".")
+	if i <= 0 {
+		return ApiKey{}, ErrInvalidApiKey
+	}
+	id, secret := key[:i], key[i+1:]
+	var k apiKey
+	var exists bool
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		var err error
+		k, exists, err = p.loadApiKey(txn, id)
+		return err
+	})
+	if err != nil {
+		return ApiKey{}, err
+	}
+	now := nowMs()
+	if !exists || subtle.ConstantTimeCompare([]byte(k.SecretHash), []byte(hashToken(secret))) != 1 || !k.usable(now) {
+		return ApiKey{}, ErrInvalidApiKey
+	}
+	p.usageLock.Lock()
+	u := p.usage[id]
+	u.count += 1
+	u.lastUsedMs = now
+	p.usage[id] = u
+	p.usageLock.Unlock()
+	return p.withUsage(k.ApiKey), nil
+}
+
+func (p *Manager) withUsage(k ApiKey) ApiKey {
+	p.usageLock.Lock()
+	defer p.usageLock.Unlock()
+	if u, ok := p.usage[k.Id]; ok {
+		k.RequestCount += u.count
+		k.LastUsedMs = u.lastUsedMs
+	}
+	return k
+}
+
+// flushUsage adds the counted requests to the stored keys
+func (p *Manager) flushUsage() {
+	p.usageLock.Lock()
+	pending := p.usage
+	p.usage = make(map[string]keyUsage)
+	p.usageLock.Unlock()
+	for id, u := range pending {
+		err := p.updateApiKey(id, func(k *apiKey) error {
+			k.RequestCount += u.count
+			if u.lastUsedMs > k.LastUsedMs {
+				k.LastUsedMs = u.lastUsedMs
+			}
+			return nil
+		})
+		if err != nil {
+			p.logger.Warn("save api key usage failed", zap.String("id", id), zap.Error(err))
+		}
+	}
+}
+
+// apiKeyAllowed checks the scopes of the key, then the roles bound to the key or to its owner.
+// Either binding is enough, scopes are the only limit a key has below its bindings.
+func (p *Manager) apiKeyAllowed(txn *badger.Txn, id string, dataset string, action string) (bool, error) {
+	k, exists, err := p.loadApiKey(txn, id)
+	if err != nil || !exists {
+		return false, err
+	}
+	if !k.usable(nowMs()) || !k.scoped(dataset, action) {
+		return false, nil
+	}
+	if p.isConfigAdmin(UserPrincipal(k.Owner)) {
+		return true, nil
+	}
+	allowed, err := p.bindingAllows(txn, ApiKeyPrincipal(id), dataset, action)
+	if err != nil || allowed {
+		return allowed, err
+	}
+	return p.bindingAllows(txn, UserPrincipal(k.Owner), dataset, action)
+}
@@ -0,0 +1,458 @@
+package userManager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	accountPrefix = "_user.account."
+	sessionPrefix = "_user.session."
+
+	sessionCleanInterval = time.Hour
+	tokenBytes           = 32
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidSession     = errors.New("invalid or expired session")
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrAccountLocked      = errors.New("account locked")
+)
+
+type Options struct {
+	// AllowRegistration lets anyone create an account through Register
+	AllowRegistration bool `json:"allowRegistration"`
+	MinPasswordLength int  `json:"minPasswordLength"`
+	SessionTTLMinutes int  `json:"sessionTTLMinutes"`
+	// MaxFailedLogins in a row lock the account for LockoutMinutes
+	MaxFailedLogins int `json:"maxFailedLogins"`
+	LockoutMinutes  int `json:"lockoutMinutes"`
+	// DefaultRoles are bound to new accounts
+	DefaultRoles []string `json:"defaultRoles"`
+	// Admins are usernames holding every permission whatever their bindings say
+	Admins []string `json:"admins"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		AllowRegistration: true,
+		MinPasswordLength: 8,
+		SessionTTLMinutes: 7 * 24 * 60,
+		MaxFailedLogins:   5,
+		LockoutMinutes:    15,
+		DefaultRoles:      []string{RoleResident},
+	}
+}
+
+// User is the public part of an account
+type User struct {
+	Username      string `json:"username"`
+	CreateTimeMs  uint64 `json:"createTimeMs"`
+	LastLoginMs   uint64 `json:"lastLoginMs,omitempty"`
+	LockedUntilMs uint64 `json:"lockedUntilMs,omitempty"`
+}
+
+type account struct {
+	User
+	PasswordHash []byte
+	FailedLogins int
+}
+
+// Session is returned by Login, the token is only known to the client, the server keeps its hash
+type Session struct {
+	Token        string `json:"token"`
+	Username     string `json:"username"`
+	CreateTimeMs uint64 `json:"createTimeMs"`
+	ExpireMs     uint64 `json:"expireMs"`
+}
+
+type session struct {
+	Username     string
+	CreateTimeMs uint64
+	ExpireMs     uint64
+}
+
+func init() {
+	gob.Register(account{})
+	gob.Register(session{})
+	gob.Register(Role{})
+	gob.Register(Binding{})
+}
+
+func serialize(v any) []byte {
+	tmp := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(tmp).Encode(v)
+	if err != nil {
+		panic(err)
+	}
+	return tmp.Bytes()
+}
+
+func deSerialize(buffer []byte, v any) error {
+	return gob.NewDecoder(bytes.NewBuffer(buffer)).Decode(v)
+}
+
+func nowMs() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+func newToken() string {
+	buf := make([]byte, tokenBytes)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// dummyHash is compared against when the user does not exist, so unknown names take as long as wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+type Manager struct {
+	logger      logger.ILogger
+	dbManager   *badgerManager.Manager
+	optionsLock sync.RWMutex
+	options     Options
+	// loginLock guards loginLocks, the per username locks that serialize the logins of one account,
+	// its failure counter is read and written around a slow bcrypt compare
+	loginLock  sync.Mutex
+	loginLocks map[string]*userLock
+	// usageLock guards the api key requests counted since the last flush
+	usageLock  sync.Mutex
+	usage      map[string]keyUsage
+	stopSignal chan int
+	stopOnce   sync.Once
+}
+
+func NewUserManager(l logger.ILogger, dbManager *badgerManager.Manager) *Manager {
+	return &Manager{
+		logger:      l,
+		dbManager:   dbManager,
+		optionsLock: sync.RWMutex{},
+		options:     DefaultOptions(),
+		loginLock:   sync.Mutex{},
+		loginLocks:  make(map[string]*userLock),
+		usageLock:   sync.Mutex{},
+		usage:       make(map[string]keyUsage),
+		stopSignal:  make(chan int),
+		stopOnce:    sync.Once{},
+	}
+}
+
+// userLock is dropped from loginLocks when nobody holds or waits for it
+type userLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockUser serializes the logins of username and returns the unlock function, logins of other users go on
+func (p *Manager) lockUser(username string) func() {
+	p.loginLock.Lock()
+	l, ok := p.loginLocks[username]
+	if !ok {
+		l = &userLock{}
+		p.loginLocks[username] = l
+	}
+	l.users += 1
+	p.loginLock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		p.loginLock.Lock()
+		l.users -= 1
+		if l.users == 0 {
+			delete(p.loginLocks, username)
+		}
+		p.loginLock.Unlock()
+	}
+}
+
+func (p *Manager) SetOptions(options Options) {
+	p.optionsLock.Lock()
+	defer p.optionsLock.Unlock()
+	p.options = options
+}
+
+func (p *Manager) GetOptions() Options {
+	p.optionsLock.RLock()
+	defer p.optionsLock.RUnlock()
+	return p.options
+}
+
+func (p *Manager) Start() {
+	go p.loopMain()
+	<-p.stopSignal
+}
+
+func (p *Manager) Stop() {
+	p.stopOnce.Do(func() {
+		select {
+		case <-p.stopSignal:
+			return
+		default:
+
+		}
+		// counted requests are written before the db goes down
+		p.flushUsage()
+		close(p.stopSignal)
+	})
+}
+
+func (p *Manager) loopMain() {
+	ticker := time.NewTicker(sessionCleanInterval)
+	defer ticker.Stop()
+	usageTicker := time.NewTicker(usageFlushInterval)
+	defer usageTicker.Stop()
+	for true {
+		select {
+		case <-p.stopSignal:
+			return
+		case <-usageTicker.C:
+			p.flushUsage()
+		case <-ticker.C:
+			err := p.cleanSessions()
+			if err != nil {
+				p.logger.Error("clean sessions failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (p *Manager) loadAccount(txn *badger.Txn, username string) (account, bool, error) {
+	var res account
+	item, err := txn.Get([]byte(accountPrefix + username))
+	if err == badger.ErrKeyNotFound {
+		return res, false, nil
+	}
+	if err != nil {
+		return res, false, err
+	}
+	err = item.Value(func(val []byte) error {
+		return deSerialize(val, &res)
+	})
+	return res, err == nil, err
+}
+
+func (p *Manager) saveAccount(txn *badger.Txn, a account) error {
+	return txn.Set([]byte(accountPrefix+a.Username), serialize(a))
+}
+
+// Register creates an account, it is refused when registration is closed
+func (p *Manager) Register(username string, password string) (User, error) {
+	options := p.GetOptions()
+	if !options.AllowRegistration {
+		return User{}, ErrRegistrationClosed
+	}
+	return p.CreateUser(username, password)
+}
+
+// CreateUser creates an account whether registration is open or not
+func (p *Manager) CreateUser(username string, password string) (User, error) {
+	options := p.GetOptions()
+	if !usernamePattern.MatchString(username) {
+		return User{}, badgerManager.Invalid("invalid username : " + username)
+	}
+	if len(password) < options.MinPasswordLength {
+		return User{}, badgerManager.Invalid("password must have at least " + strconv.Itoa(options.MinPasswordLength) + " characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	a := account{
+		User: User{
+			Username:     username,
+			CreateTimeMs: nowMs(),
+		},
+		PasswordHash: hash,
+	}
+	err = p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		_, exists, err := p.loadAccount(txn, username)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return badgerManager.Conflict("username already taken : " + username)
+		}
+		err = p.saveAccount(txn, a)
+		if err != nil || len(options.DefaultRoles) == 0 {
+			return err
+		}
+		return txn.Set([]byte(bindingPrefix+UserPrincipal(username)), serialize(Binding{
+			Principal: UserPrincipal(username),
+			Roles:     options.DefaultRoles,
+		}))
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return a.User, nil
+}
+
+// Login checks the password and opens a session, failed attempts in a row lock the account for a while
+func (p *Manager) Login(username string, password string) (Session, error) {
+	defer p.lockUser(username)()
+	options := p.GetOptions()
+
+	var a account
+	var exists bool
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		var err error
+		a, exists, err = p.loadAccount(txn, username)
+		return err
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	if !exists {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return Session{}, ErrInvalidCredentials
+	}
+	now := nowMs()
+	if a.LockedUntilMs > now {
+		return Session{}, fmt.Errorf("%w until %s", ErrAccountLocked, time.UnixMilli(int64(a.LockedUntilMs)).Format(time.RFC3339))
+	}
+
+	if bcrypt.CompareHashAndPassword(a.PasswordHash, []byte(password)) != nil {
+		a.FailedLogins += 1
+		if options.MaxFailedLogins > 0 && a.FailedLogins >= options.MaxFailedLogins {
+			a.FailedLogins = 0
+			a.LockedUntilMs = now + uint64(options.LockoutMinutes)*uint64(time.Minute/time.Millisecond)
+			p.logger.Warn("account locked", zap.String("username", username))
+		}
+		err = p.dbManager.UpdateData(func(txn *badger.Txn) error {
+			return p.saveAccount(txn, a)
+		})
+		if err != nil {
+			return Session{}, err
+		}
+		return Session{}, ErrInvalidCredentials
+	}
+
+	a.FailedLogins = 0
+	a.LockedUntilMs = 0
+	a.LastLoginMs = now
+	token := newToken()
+	s := session{
+		Username:     username,
+		CreateTimeMs: now,
+		ExpireMs:     now + uint64(options.SessionTTLMinutes)*uint64(time.Minute/time.Millisecond),
+	}
+	err = p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		err := p.saveAccount(txn, a)
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte(sessionPrefix+hashToken(token)), serialize(s))
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return Session{
+		Token:        token,
+		Username:     username,
+		CreateTimeMs: s.CreateTimeMs,
+		ExpireMs:     s.ExpireMs,
+	}, nil
+}
+
+// Logout revokes the session of token
+func (p *Manager) Logout(token string) error {
+	return p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(sessionPrefix + hashToken(token)))
+	})
+}
+
+// RevokeSessions logs username out everywhere
+func (p *Manager) RevokeSessions(username string) error {
+	return p.deleteSessions(func(s session) bool {
+		return s.Username == username
+	})
+}
+
+func (p *Manager) cleanSessions() error {
+	now := nowMs()
+	return p.deleteSessions(func(s session) bool {
+		return s.ExpireMs <= now
+	})
+}
+
+func (p *Manager) deleteSessions(match func(s session) bool) error {
+	keys := make([][]byte, 0)
+	err := p.dbManager.IterateData(func(key []byte, value []byte) {
+		var s session
+		if deSerialize(value, &s) != nil || match(s) {
+			keys = append(keys, append([]byte{}, key...))
+		}
+	}, []byte(sessionPrefix))
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return p.dbManager.DeleteData(keys)
+}
+
+// Authenticate returns the user of a live session
+func (p *Manager) Authenticate(token string) (User, error) {
+	if token == "" {
+		return User{}, ErrInvalidSession
+	}
+	var a account
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(sessionPrefix + hashToken(token)))
+		if err == badger.ErrKeyNotFound {
+			return ErrInvalidSession
+		}
+		if err != nil {
+			return err
+		}
+		var s session
+		err = item.Value(func(val []byte) error {
+			return deSerialize(val, &s)
+		})
+		if err != nil {
+			return err
+		}
+		if s.ExpireMs <= nowMs() {
+			return ErrInvalidSession
+		}
+		var exists bool
+		a, exists, err = p.loadAccount(txn, s.Username)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrInvalidSession
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return a.User, nil
+}
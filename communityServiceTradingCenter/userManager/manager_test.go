@@ -0,0 +1,307 @@
+package userManager
+
+import (
+	"go.uber.org/zap/zapcore"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger/console"
+	"testing"
+	"time"
+)
+
+func TestUserSessions(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	um := NewUserManager(l, m)
+	options := DefaultOptions()
+	options.MaxFailedLogins = 3
+	um.SetOptions(options)
+
+	if _, err := um.Register("alice", "short"); err == nil {
+		t.Fatal("short password should be refused")
+	}
+	if _, err := um.Register("a b", "long enough"); err == nil {
+		t.Fatal("invalid username should be refused")
+	}
+	if _, err := um.Register("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.Register("alice", "another one"); err == nil {
+		t.Fatal("taken username should be refused")
+	}
+
+	if _, err := um.Login("bob", "correct horse"); err != ErrInvalidCredentials {
+		t.Fatal("unknown user should not log in", err)
+	}
+	first, err := um.Login("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := um.Login("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Token == second.Token {
+		t.Fatal("sessions should have their own token")
+	}
+	if user, err := um.Authenticate(first.Token); err != nil || user.Username != "alice" {
+		t.Fatal("session not accepted", user, err)
+	}
+
+	err = um.Logout(first.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = um.Authenticate(first.Token); err != ErrInvalidSession {
+		t.Fatal("revoked session should be refused", err)
+	}
+	if _, err = um.Authenticate(second.Token); err != nil {
+		t.Fatal("other session should stay open", err)
+	}
+	err = um.RevokeSessions("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = um.Authenticate(second.Token); err != ErrInvalidSession {
+		t.Fatal("sessions should be revoked everywhere", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = um.Login("alice", "wrong password"); err != ErrInvalidCredentials {
+			t.Fatal("wrong password should be refused", err)
+		}
+	}
+	if _, err = um.Login("alice", "correct horse"); err == nil || err == ErrInvalidCredentials {
+		t.Fatal("account should be locked", err)
+	}
+
+	options.AllowRegistration = false
+	um.SetOptions(options)
+	if _, err := um.Register("carol", "correct horse"); err == nil {
+		t.Fatal("registration should be closed")
+	}
+	if _, err := um.CreateUser("carol", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a login in progress only holds back the logins of the same account
+	unlock := um.lockUser("alice")
+	done := make(chan error)
+	go func() {
+		_, err := um.Login("carol", "correct horse")
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("login of another account should not wait")
+	}
+	unlock()
+	if len(um.loginLocks) != 0 {
+		t.Fatal("unused login locks should be dropped", um.loginLocks)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	um := NewUserManager(l, m)
+	options := DefaultOptions()
+	options.Admins = []string{"root"}
+	um.SetOptions(options)
+	err := um.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = um.CreateUser("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := UserPrincipal("alice")
+
+	check := func(principal string, dataset string, action string, expected bool) {
+		allowed, err := um.Allowed(principal, dataset, action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != expected {
+			t.Fatal("unexpected permission", principal, Permission(dataset, action), allowed)
+		}
+	}
+	// new accounts and anonymous requests are residents
+	check(alice, "provider", ActionQuery, true)
+	check(alice, "provider", ActionInsert, false)
+	check(PrincipalAnonymous, "publisher", ActionQuery, true)
+	check(PrincipalAnonymous, "publisher", ActionInsert, false)
+
+	err = um.SetBinding(alice, []string{RoleResident, RoleProvider})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(alice, "provider", ActionInsert, true)
+	check(alice, "publisher", ActionInsert, false)
+	check(alice, AnyDataset, ActionAdmin, false)
+
+	if um.SetRole(Role{Name: "broken", Permissions: []string{"provider:write"}}) == nil {
+		t.Fatal("unknown action should be refused")
+	}
+	err = um.SetRole(Role{Name: "taskOwner", Permissions: []string{"task:admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a key only acting through its own binding
+	_, err = um.CreateUser("bot", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = um.SetBinding(UserPrincipal("bot"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := um.CreateApiKey("bot", "sync", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := ApiKeyPrincipal(created.Id)
+	err = um.SetBinding(key, []string{"taskOwner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// admin on a dataset allows every action on it and nothing else
+	check(key, "task", ActionDelete, true)
+	check(key, "task", ActionModerate, true)
+	check(key, "provider", ActionQuery, false)
+	check(key, AnyDataset, ActionAdmin, false)
+	check(UserPrincipal("root"), AnyDataset, ActionAdmin, true)
+
+	if um.DeleteRole("taskOwner") == nil {
+		t.Fatal("bound role should not be deleted")
+	}
+	if um.SetBinding("someone", []string{RoleResident}) == nil {
+		t.Fatal("invalid principal should be refused")
+	}
+	if um.SetBinding(key, []string{"missing"}) == nil {
+		t.Fatal("unknown role should be refused")
+	}
+	err = um.SetBinding(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = um.DeleteRole("taskOwner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, _ := um.ListRoles()
+	if len(roles) != len(DefaultRoles()) {
+		t.Fatal("unexpected roles", roles)
+	}
+}
+
+func TestApiKeys(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	um := NewUserManager(l, m)
+	err := um.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = um.CreateUser("partner", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = um.SetBinding(UserPrincipal("partner"), []string{RoleResident, RoleProvider, RolePublisher})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = um.CreateApiKey("nobody", "sync", nil, 0); err == nil {
+		t.Fatal("key of unknown user should be refused")
+	}
+	if _, err = um.CreateApiKey("partner", "sync", []string{"provider:write"}, 0); err == nil {
+		t.Fatal("invalid scope should be refused")
+	}
+	created, err := um.CreateApiKey("partner", "sync", []string{"provider:insert", "provider:query"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal := ApiKeyPrincipal(created.Id)
+
+	check := func(dataset string, action string, expected bool) {
+		allowed, err := um.Allowed(principal, dataset, action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != expected {
+			t.Fatal("unexpected permission", Permission(dataset, action), allowed)
+		}
+	}
+	// scopes narrow what the owner may do
+	check("provider", ActionInsert, true)
+	check("publisher", ActionInsert, false)
+	check("provider", ActionDelete, false)
+
+	for i := 0; i < 3; i++ {
+		if _, err = um.AuthenticateApiKey(created.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = um.AuthenticateApiKey(created.Id + ".wrong"); err != ErrInvalidApiKey {
+		t.Fatal("wrong secret should be refused", err)
+	}
+	um.flushUsage()
+	k, err := um.GetApiKey(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.RequestCount != 3 || k.LastUsedMs == 0 {
+		t.Fatal("usage should be recorded", k)
+	}
+
+	rotated, err := um.RotateApiKey(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = um.AuthenticateApiKey(created.Key); err != ErrInvalidApiKey {
+		t.Fatal("old secret should stop working", err)
+	}
+	if _, err = um.AuthenticateApiKey(rotated.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	err = um.RevokeApiKey(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = um.AuthenticateApiKey(rotated.Key); err != ErrInvalidApiKey {
+		t.Fatal("revoked key should be refused", err)
+	}
+	check("provider", ActionInsert, false)
+
+	expired, err := um.CreateApiKey("partner", "old", nil, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err = um.AuthenticateApiKey(expired.Key); err != ErrInvalidApiKey {
+		t.Fatal("expired key should be refused", err)
+	}
+
+	list, err := um.ListApiKeys("partner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].RequestCount != 4 {
+		t.Fatal("unexpected keys", list)
+	}
+}
@@ -0,0 +1,327 @@
+package userManager
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"moonlighting/common/database/badgerManager"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	ActionQuery    = "query"
+	ActionInsert   = "insert"
+	ActionDelete   = "delete"
+	ActionModerate = "moderate"
+	// ActionAdmin on a dataset allows every action on it, on AnyDataset it allows everything
+	ActionAdmin = "admin"
+
+	AnyDataset = "*"
+
+	PrincipalUser   = "user:"
+	PrincipalApiKey = "apikey:"
+	// PrincipalAnonymous is used for requests without credentials
+	PrincipalAnonymous = "anonymous"
+
+	RoleResident  = "resident"
+	RoleProvider  = "provider"
+	RolePublisher = "publisher"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+
+	rolePrefix    = "_user.role."
+	bindingPrefix = "_user.binding."
+)
+
+var (
+	roleNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,63}$`)
+	actions         = []string{ActionQuery, ActionInsert, ActionDelete, ActionModerate, ActionAdmin}
+)
+
+// Role grants permissions written as "<dataset>:<action>", the dataset may be * for every dataset
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type Binding struct {
+	Principal string   `json:"principal"`
+	Roles     []string `json:"roles"`
+}
+
+// DefaultRoles are created by Load when missing, they can be changed afterwards
+func DefaultRoles() []Role {
+	return []Role{
+		{Name: RoleResident, Permissions: []string{"*:query"}},
+		{Name: RoleProvider, Permissions: []string{"*:query", "provider:insert", "provider:delete"}},
+		{Name: RolePublisher, Permissions: []string{"*:query", "publisher:insert", "publisher:delete"}},
+		{Name: RoleModerator, Permissions: []string{"*:query", "*:moderate"}},
+		{Name: RoleAdmin, Permissions: []string{"*:admin"}},
+	}
+}
+
+func Permission(dataset string, action string) string {
+	return dataset + ":" + action
+}
+
+func splitPermission(permission string) (dataset string, action string, err error) {
+	i := strings.LastIndex(permission, ":")
+	if i <= 0 {
+		return "", "", badgerManager.Invalid("invalid permission, expected <dataset>:<action> : " + permission)
+	}
+	dataset, action = permission[:i], permission[i+1:]
+	for _, a := range actions {
+		if a == action {
+			return dataset, action, nil
+		}
+	}
+	return "", "", badgerManager.Invalid("unknown action : " + action)
+}
+
+func UserPrincipal(username string) string {
+	return PrincipalUser + username
+}
+
+func ApiKeyPrincipal(id string) string {
+	return PrincipalApiKey + id
+}
+
+func checkPrincipal(principal string) error {
+	if principal == PrincipalAnonymous {
+		return nil
+	}
+	if strings.HasPrefix(principal, PrincipalUser) && len(principal) > len(PrincipalUser) {
+		return nil
+	}
+	if strings.HasPrefix(principal, PrincipalApiKey) && len(principal) > len(PrincipalApiKey) {
+		return nil
+	}
+	return badgerManager.Invalid("invalid principal, expected user:<name>, apikey:<id> or anonymous : " + principal)
+}
+
+func (r Role) Check() error {
+	if !roleNamePattern.MatchString(r.Name) {
+		return badgerManager.Invalid("invalid role name : " + r.Name)
+	}
+	for _, permission := range r.Permissions {
+		_, _, err := splitPermission(permission)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grants tells if the role allows action on dataset
+func (r Role) grants(dataset string, action string) bool {
+	for _, permission := range r.Permissions {
+		d, a, err := splitPermission(permission)
+		if err != nil {
+			continue
+		}
+		if d != AnyDataset && d != dataset {
+			continue
+		}
+		if a == action || a == ActionAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Load creates the default roles and gives anonymous requests the resident role, both only when missing
+func (p *Manager) Load() error {
+	return p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		for _, role := range DefaultRoles() {
+			_, err := txn.Get([]byte(rolePrefix + role.Name))
+			if err == nil {
+				continue
+			}
+			if err != badger.ErrKeyNotFound {
+				return err
+			}
+			err = txn.Set([]byte(rolePrefix+role.Name), serialize(role))
+			if err != nil {
+				return err
+			}
+		}
+		_, err := txn.Get([]byte(bindingPrefix + PrincipalAnonymous))
+		if err == badger.ErrKeyNotFound {
+			return txn.Set([]byte(bindingPrefix+PrincipalAnonymous), serialize(Binding{
+				Principal: PrincipalAnonymous,
+				Roles:     []string{RoleResident},
+			}))
+		}
+		return err
+	})
+}
+
+func loadItem(txn *badger.Txn, key string, v any) (bool, error) {
+	item, err := txn.Get([]byte(key))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = item.Value(func(val []byte) error {
+		return deSerialize(val, v)
+	})
+	return err == nil, err
+}
+
+func (p *Manager) SetRole(role Role) error {
+	err := role.Check()
+	if err != nil {
+		return err
+	}
+	return p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		return txn.Set([]byte(rolePrefix+role.Name), serialize(role))
+	})
+}
+
+// DeleteRole refuses roles still bound to a principal
+func (p *Manager) DeleteRole(name string) error {
+	bindings, err := p.ListBindings()
+	if err != nil {
+		return err
+	}
+	for _, b := range bindings {
+		for _, r := range b.Roles {
+			if r == name {
+				return badgerManager.Conflict("role is bound to " + b.Principal + " : " + name)
+			}
+		}
+	}
+	return p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		exists, err := loadItem(txn, rolePrefix+name, &Role{})
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return badgerManager.NotFound("role not found : " + name)
+		}
+		return txn.Delete([]byte(rolePrefix + name))
+	})
+}
+
+func (p *Manager) ListRoles() ([]Role, error) {
+	res := make([]Role, 0)
+	var innerErr error
+	err := p.dbManager.IterateData(func(key []byte, value []byte) {
+		var r Role
+		if err := deSerialize(value, &r); err != nil {
+			innerErr = err
+			return
+		}
+		res = append(res, r)
+	}, []byte(rolePrefix))
+	if err == nil {
+		err = innerErr
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, err
+}
+
+// SetBinding replaces the roles of principal, no roles removes the binding
+func (p *Manager) SetBinding(principal string, roles []string) error {
+	err := checkPrincipal(principal)
+	if err != nil {
+		return err
+	}
+	return p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		if len(roles) == 0 {
+			return txn.Delete([]byte(bindingPrefix + principal))
+		}
+		for _, name := range roles {
+			exists, err := loadItem(txn, rolePrefix+name, &Role{})
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return badgerManager.NotFound("role not found : " + name)
+			}
+		}
+		return txn.Set([]byte(bindingPrefix+principal), serialize(Binding{
+			Principal: principal,
+			Roles:     roles,
+		}))
+	})
+}
+
+func (p *Manager) GetBinding(principal string) (Binding, error) {
+	res := Binding{Principal: principal, Roles: make([]string, 0)}
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		_, err := loadItem(txn, bindingPrefix+principal, &res)
+		return err
+	})
+	return res, err
+}
+
+func (p *Manager) ListBindings() ([]Binding, error) {
+	res := make([]Binding, 0)
+	var innerErr error
+	err := p.dbManager.IterateData(func(key []byte, value []byte) {
+		var b Binding
+		if err := deSerialize(value, &b); err != nil {
+			innerErr = err
+			return
+		}
+		res = append(res, b)
+	}, []byte(bindingPrefix))
+	if err == nil {
+		err = innerErr
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Principal < res[j].Principal
+	})
+	return res, err
+}
+
+func (p *Manager) isConfigAdmin(principal string) bool {
+	for _, name := range p.GetOptions().Admins {
+		if principal == UserPrincipal(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed tells if principal may run action on dataset, AnyDataset asks for a permission over every dataset
+func (p *Manager) Allowed(principal string, dataset string, action string) (bool, error) {
+	if p.isConfigAdmin(principal) {
+		return true, nil
+	}
+	allowed := false
+	err := p.dbManager.ViewData(func(txn *badger.Txn) error {
+		var err error
+		if strings.HasPrefix(principal, PrincipalApiKey) {
+			allowed, err = p.apiKeyAllowed(txn, strings.TrimPrefix(principal, PrincipalApiKey), dataset, action)
+		} else {
+			allowed, err = p.bindingAllows(txn, principal, dataset, action)
+		}
+		return err
+	})
+	return allowed, err
+}
+
+func (p *Manager) bindingAllows(txn *badger.Txn, principal string, dataset string, action string) (bool, error) {
+	var b Binding
+	_, err := loadItem(txn, bindingPrefix+principal, &b)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range b.Roles {
+		var r Role
+		exists, err := loadItem(txn, rolePrefix+name, &r)
+		if err != nil {
+			return false, err
+		}
+		if exists && r.grants(dataset, action) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,652 @@
+package webhookManager
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"go.uber.org/zap"
+	"io"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	EventInsert = "insert"
+	EventUpdate = "update"
+	EventDelete = "delete"
+
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	hookPrefix   = "_webhook.hook."
+	outboxPrefix = "_webhook.outbox."
+	logPrefix    = "_webhook.log."
+
+	maxAttempts      = 8
+	deliveryLogTTL   = 7 * 24 * time.Hour
+	deliveryInterval = time.Second
+	maxResponseBody  = 1024
+	// deliveryWorkers is how many webhooks are delivered to at the same time
+	deliveryWorkers = 4
+)
+
+type Webhook struct {
+	Id      string `json:"id"`
+	Dataset string `json:"dataset"`
+	Url     string `json:"url"`
+	// Secret signs every payload, it is only shown when the webhook is registered
+	Secret string `json:"secret,omitempty"`
+	// Events limits the event types sent, all types are sent when empty
+	Events []string `json:"events"`
+	// MatchRules filters on the record, the old record is used for deletes
+	MatchRules   []map[string]string `json:"matchRules"`
+	CreateTimeMs uint64              `json:"createTimeMs"`
+}
+
+// Event is a change of what the queries of a dataset list, see Watch
+type Event struct {
+	Id      string            `json:"id"`
+	Dataset string            `json:"dataset"`
+	Type    string            `json:"type"`
+	Key     string            `json:"key"`
+	Old     *dataManager.Data `json:"old,omitempty"`
+	New     *dataManager.Data `json:"new,omitempty"`
+	TimeMs  uint64            `json:"timeMs"`
+}
+
+type Delivery struct {
+	Id         string `json:"id"`
+	WebhookId  string `json:"webhookId"`
+	EventId    string `json:"eventId"`
+	EventType  string `json:"eventType"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"statusCode"`
+	Response   string `json:"response,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Succeeded  bool   `json:"succeeded"`
+	// Final is set on the last attempt of an event, successful or not
+	Final      bool   `json:"final"`
+	TimeMs     uint64 `json:"timeMs"`
+	DurationMs uint64 `json:"durationMs"`
+}
+
+type outboxItem struct {
+	Id            string
+	WebhookId     string
+	EventId       string
+	EventType     string
+	Payload       []byte
+	Attempts      int
+	NextAttemptMs uint64
+	CreateTimeMs  uint64
+}
+
+func init() {
+	gob.Register(Webhook{})
+	gob.Register(outboxItem{})
+	gob.Register(Delivery{})
+}
+
+func serialize(v any) []byte {
+	tmp := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(tmp).Encode(v)
+	if err != nil {
+		panic(err)
+	}
+	return tmp.Bytes()
+}
+
+func deSerialize(buffer []byte, v any) error {
+	return gob.NewDecoder(bytes.NewBuffer(buffer)).Decode(v)
+}
+
+func newId(n int) string {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func nowMs() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+// Sign returns the signature header value for body sent at timestamp
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type Manager struct {
+	logger        logger.ILogger
+	dbManager     *badgerManager.Manager
+	client        *http.Client
+	retryBase     time.Duration
+	retryMax      time.Duration
+	datasetsLock  sync.RWMutex
+	datasets      map[string]struct{}
+	hooksLock     sync.RWMutex
+	hooks         map[string]Webhook
+	hooksLoaded   bool
+	deliverSignal chan int
+	stopSignal    chan int
+	stopOnce      sync.Once
+	// endpointTimeout bounds the time a round of deliveries spends on one webhook, the rest waits for the next round
+	endpointTimeout time.Duration
+}
+
+func NewWebhookManager(l logger.ILogger, dbManager *badgerManager.Manager) *Manager {
+	return &Manager{
+		logger:    l,
+		dbManager: dbManager,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		retryBase:       5 * time.Second,
+		retryMax:        time.Hour,
+		datasetsLock:    sync.RWMutex{},
+		datasets:        make(map[string]struct{}),
+		hooksLock:       sync.RWMutex{},
+		hooks:           make(map[string]Webhook),
+		deliverSignal:   make(chan int, 1),
+		stopSignal:      make(chan int),
+		stopOnce:        sync.Once{},
+		endpointTimeout: 30 * time.Second,
+	}
+}
+
+func (p *Manager) Start() {
+	go p.loopMain()
+	<-p.stopSignal
+}
+
+func (p *Manager) Stop() {
+	p.stopOnce.Do(func() {
+		select {
+		case <-p.stopSignal:
+			return
+		default:
+
+		}
+		close(p.stopSignal)
+	})
+}
+
+func (p *Manager) loopMain() {
+	ticker := time.NewTicker(deliveryInterval)
+	defer ticker.Stop()
+	for true {
+		select {
+		case <-p.stopSignal:
+			return
+		case <-ticker.C:
+			p.deliverDue()
+		case <-p.deliverSignal:
+			p.deliverDue()
+		}
+	}
+}
+
+func (p *Manager) signalDeliver() {
+	select {
+	case p.deliverSignal <- 1:
+	default:
+	}
+}
+
+// Watch turns the changes of dm into events of dataset. Events follow what queries list, so a record that becomes
+// visible is an insert and one that stops being visible, like a draft or a record sent back to review, is a delete.
+// The outbox items are written in the transaction of the change, an event is only lost together with its change.
+func (p *Manager) Watch(dataset string, dm *dataManager.Manager) {
+	p.datasetsLock.Lock()
+	p.datasets[dataset] = struct{}{}
+	p.datasetsLock.Unlock()
+
+	dm.OnWrite(func(txn *badger.Txn, old *dataManager.Data, data *dataManager.Data) error {
+		if old != nil && !dm.Visible(*old) {
+			old = nil
+		}
+		if data != nil && !dm.Visible(*data) {
+			data = nil
+		}
+		switch {
+		case old == nil && data == nil:
+			return nil
+		case old == nil:
+			return p.enqueue(txn, dataset, EventInsert, nil, data)
+		case data == nil:
+			return p.enqueue(txn, dataset, EventDelete, old, nil)
+		}
+		return p.enqueue(txn, dataset, EventUpdate, old, data)
+	})
+	// deliveries start once the change is committed
+	dm.OnInsert(func(dataManager.Data) {
+		p.signalDeliver()
+	})
+	dm.OnUpdate(func(dataManager.Data, dataManager.Data) {
+		p.signalDeliver()
+	})
+	dm.OnDelete(func(dataManager.Data) {
+		p.signalDeliver()
+	})
+}
+
+func (p *Manager) loadHooks() error {
+	p.hooksLock.Lock()
+	defer p.hooksLock.Unlock()
+	if p.hooksLoaded {
+		return nil
+	}
+	hooks := make(map[string]Webhook)
+	var innerErr error
+	err := p.dbManager.IterateData(func(key []byte, value []byte) {
+		var hook Webhook
+		if err := deSerialize(value, &hook); err != nil {
+			innerErr = err
+			return
+		}
+		hooks[hook.Id] = hook
+	}, []byte(hookPrefix))
+	if err != nil {
+		return err
+	}
+	if innerErr != nil {
+		return innerErr
+	}
+	p.hooks = hooks
+	p.hooksLoaded = true
+	return nil
+}
+
+func (p *Manager) getHook(id string) (Webhook, bool, error) {
+	err := p.loadHooks()
+	if err != nil {
+		return Webhook{}, false, err
+	}
+	p.hooksLock.RLock()
+	defer p.hooksLock.RUnlock()
+	hook, ok := p.hooks[id]
+	return hook, ok, nil
+}
+
+func (hook Webhook) wants(eventType string, data dataManager.Data) bool {
+	if len(hook.Events) > 0 {
+		found := false
+		for _, e := range hook.Events {
+			if e == eventType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	matched, err := dataManager.MatchData(hook.MatchRules, data)
+	return err == nil && matched
+}
+
+// enqueue writes the outbox items of an event in txn, the transaction of the change
+func (p *Manager) enqueue(txn *badger.Txn, dataset string, eventType string, old *dataManager.Data, data *dataManager.Data) error {
+	err := p.loadHooks()
+	if err != nil {
+		return err
+	}
+	subject := data
+	if subject == nil {
+		subject = old
+	}
+	event := Event{
+		Id:      newId(16),
+		Dataset: dataset,
+		Type:    eventType,
+		Key:     subject.Key,
+		Old:     old,
+		New:     data,
+		TimeMs:  nowMs(),
+	}
+
+	items := make([]outboxItem, 0)
+	var payload []byte
+	p.hooksLock.RLock()
+	for _, hook := range p.hooks {
+		if hook.Dataset != dataset || !hook.wants(eventType, *subject) {
+			continue
+		}
+		if payload == nil {
+			payload, _ = json.Marshal(event)
+		}
+		items = append(items, outboxItem{
+			Id:            newId(16),
+			WebhookId:     hook.Id,
+			EventId:       event.Id,
+			EventType:     eventType,
+			Payload:       payload,
+			NextAttemptMs: event.TimeMs,
+			CreateTimeMs:  event.TimeMs,
+		})
+	}
+	p.hooksLock.RUnlock()
+
+	for _, item := range items {
+		err = txn.Set([]byte(outboxPrefix+item.Id), serialize(item))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Manager) Register(hook Webhook) (Webhook, error) {
+	p.datasetsLock.RLock()
+	_, ok := p.datasets[hook.Dataset]
+	p.datasetsLock.RUnlock()
+	if !ok {
+		return Webhook{}, badgerManager.Invalid("unknown dataset : " + hook.Dataset)
+	}
+	u, err := url.Parse(hook.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, badgerManager.Invalid("invalid url : " + hook.Url)
+	}
+	for _, e := range hook.Events {
+		if e != EventInsert && e != EventUpdate && e != EventDelete {
+			return Webhook{}, badgerManager.Invalid("unknown event : " + e)
+		}
+	}
+	_, err = dataManager.MatchData(hook.MatchRules, dataManager.Data{})
+	if err != nil {
+		return Webhook{}, err
+	}
+	err = p.loadHooks()
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	hook.Id = newId(8)
+	if hook.Secret == "" {
+		hook.Secret = newId(32)
+	}
+	hook.CreateTimeMs = nowMs()
+	err = p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		return txn.Set([]byte(hookPrefix+hook.Id), serialize(hook))
+	})
+	if err != nil {
+		return Webhook{}, err
+	}
+	p.hooksLock.Lock()
+	p.hooks[hook.Id] = hook
+	p.hooksLock.Unlock()
+	return hook, nil
+}
+
+// List returns the webhooks of dataset, or all of them when dataset is empty, without their secrets
+func (p *Manager) List(dataset string) ([]Webhook, error) {
+	err := p.loadHooks()
+	if err != nil {
+		return nil, err
+	}
+	p.hooksLock.RLock()
+	res := make([]Webhook, 0, len(p.hooks))
+	for _, hook := range p.hooks {
+		if dataset != "" && hook.Dataset != dataset {
+			continue
+		}
+		hook.Secret = ""
+		res = append(res, hook)
+	}
+	p.hooksLock.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreateTimeMs < res[j].CreateTimeMs
+	})
+	return res, nil
+}
+
+// Remove deletes the webhook together with its pending deliveries, the delivery log is kept until it expires
+func (p *Manager) Remove(id string) error {
+	_, ok, err := p.getHook(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return badgerManager.NotFound("webhook not found : " + id)
+	}
+	pending, err := p.loadOutbox()
+	if err != nil {
+		return err
+	}
+	err = p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		for _, item := range pending {
+			if item.WebhookId != id {
+				continue
+			}
+			err := txn.Delete([]byte(outboxPrefix + item.Id))
+			if err != nil {
+				return err
+			}
+		}
+		return txn.Delete([]byte(hookPrefix + id))
+	})
+	if err != nil {
+		return err
+	}
+	p.hooksLock.Lock()
+	delete(p.hooks, id)
+	p.hooksLock.Unlock()
+	return nil
+}
+
+// Deliveries returns up to limit attempts of the webhook, newest first
+func (p *Manager) Deliveries(id string, limit int) ([]Delivery, error) {
+	res := make([]Delivery, 0)
+	var innerErr error
+	err := p.dbManager.IterateData(func(key []byte, value []byte) {
+		var d Delivery
+		if err := deSerialize(value, &d); err != nil {
+			innerErr = err
+			return
+		}
+		res = append(res, d)
+	}, []byte(logPrefix+id+"."))
+	if err != nil {
+		return nil, err
+	}
+	if innerErr != nil {
+		return nil, innerErr
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (p *Manager) loadOutbox() ([]outboxItem, error) {
+	res := make([]outboxItem, 0)
+	var innerErr error
+	err := p.dbManager.IterateData(func(key []byte, value []byte) {
+		var item outboxItem
+		if err := deSerialize(value, &item); err != nil {
+			innerErr = err
+			return
+		}
+		res = append(res, item)
+	}, []byte(outboxPrefix))
+	if err != nil {
+		return nil, err
+	}
+	return res, innerErr
+}
+
+func (p *Manager) backoff(attempts int) time.Duration {
+	d := p.retryBase
+	for i := 1; i < attempts && d < p.retryMax; i++ {
+		d *= 2
+	}
+	if d > p.retryMax {
+		d = p.retryMax
+	}
+	return d
+}
+
+func (p *Manager) deliverDue() {
+	pending, err := p.loadOutbox()
+	if err != nil {
+		p.logger.Error("load webhook outbox failed", zap.Error(err))
+		return
+	}
+	now := nowMs()
+	due := make([]outboxItem, 0)
+	for _, item := range pending {
+		if item.NextAttemptMs <= now {
+			due = append(due, item)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreateTimeMs < due[j].CreateTimeMs
+	})
+	// every webhook gets its items in order, a slow one only holds up its own
+	byHook := make(map[string][]outboxItem)
+	for _, item := range due {
+		byHook[item.WebhookId] = append(byHook[item.WebhookId], item)
+	}
+	queue := make(chan []outboxItem, len(byHook))
+	for _, items := range byHook {
+		queue <- items
+	}
+	close(queue)
+
+	workers := deliveryWorkers
+	if len(byHook) < workers {
+		workers = len(byHook)
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for items := range queue {
+				p.deliverHook(items)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliverHook sends the due items of one webhook until endpointTimeout runs out
+func (p *Manager) deliverHook(items []outboxItem) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.endpointTimeout)
+	defer cancel()
+	for _, item := range items {
+		select {
+		case <-p.stopSignal:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+		p.deliver(ctx, item)
+	}
+}
+
+func (p *Manager) deliver(ctx context.Context, item outboxItem) {
+	hook, ok, err := p.getHook(item.WebhookId)
+	if err != nil {
+		p.logger.Error("load webhook failed", zap.Error(err))
+		return
+	}
+	if !ok {
+		_ = p.dbManager.DeleteData([][]byte{[]byte(outboxPrefix + item.Id)})
+		return
+	}
+
+	item.Attempts += 1
+	delivery := p.post(ctx, hook, item)
+	delivery.Final = delivery.Succeeded || item.Attempts >= maxAttempts
+	if !delivery.Succeeded {
+		p.logger.Warn("webhook delivery failed",
+			zap.String("webhook", hook.Id),
+			zap.String("event", item.EventId),
+			zap.Int("attempt", item.Attempts),
+			zap.Int("status", delivery.StatusCode),
+			zap.String("error", delivery.Error))
+	}
+
+	err = p.dbManager.UpdateData(func(txn *badger.Txn) error {
+		logKey := fmt.Sprintf("%s%s.%020d.%s", logPrefix, hook.Id, delivery.TimeMs, delivery.Id)
+		err := txn.SetEntry(badger.NewEntry([]byte(logKey), serialize(delivery)).WithTTL(deliveryLogTTL))
+		if err != nil {
+			return err
+		}
+		if delivery.Final {
+			return txn.Delete([]byte(outboxPrefix + item.Id))
+		}
+		item.NextAttemptMs = nowMs() + uint64(p.backoff(item.Attempts)/time.Millisecond)
+		return txn.Set([]byte(outboxPrefix+item.Id), serialize(item))
+	})
+	if err != nil {
+		p.logger.Error("update webhook outbox failed", zap.String("item", item.Id), zap.Error(err))
+	}
+}
+
+// post sends item once, delivery is a named result so the deferred duration reaches the caller
+func (p *Manager) post(ctx context.Context, hook Webhook, item outboxItem) (delivery Delivery) {
+	start := time.Now()
+	delivery = Delivery{
+		Id:        newId(8),
+		WebhookId: hook.Id,
+		EventId:   item.EventId,
+		EventType: item.EventType,
+		Attempt:   item.Attempts,
+		TimeMs:    uint64(start.UnixMilli()),
+	}
+	defer func() {
+		delivery.DurationMs = uint64(time.Since(start).Milliseconds())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(item.Payload))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, item.EventType)
+	req.Header.Set(DeliveryHeader, item.Id)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, item.Payload))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	delivery.StatusCode = resp.StatusCode
+	delivery.Response = string(body)
+	delivery.Succeeded = resp.StatusCode >= 200 && resp.StatusCode < 300
+	return delivery
+}
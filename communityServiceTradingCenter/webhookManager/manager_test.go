@@ -0,0 +1,263 @@
+package webhookManager
+
+import (
+	"encoding/json"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"moonlighting/common/database/badgerManager"
+	"moonlighting/common/logger/console"
+	"moonlighting/communityServiceTradingCenter/dataManager"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	dm := dataManager.NewDataManager(l, "provider.", m)
+	go dm.Start()
+	defer dm.Stop()
+
+	wm := NewWebhookManager(l, m)
+	wm.retryBase = 10 * time.Millisecond
+	wm.Watch("provider", dm)
+	go wm.Start()
+	defer wm.Stop()
+
+	var lock sync.Mutex
+	received := make([]Event, 0)
+	calls := 0
+	var secret string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls += 1
+		if calls == 1 {
+			time.Sleep(5 * time.Millisecond)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign(secret, r.Header.Get(TimestampHeader), body) {
+			t.Error("bad signature")
+		}
+		var event Event
+		_ = json.Unmarshal(body, &event)
+		received = append(received, event)
+	}))
+	defer receiver.Close()
+
+	_, err := wm.Register(Webhook{Dataset: "unknown", Url: receiver.URL})
+	if err == nil {
+		t.Fatal("unknown dataset should be rejected")
+	}
+	hook, err := wm.Register(Webhook{
+		Dataset:    "provider",
+		Url:        receiver.URL,
+		Events:     []string{EventInsert, EventDelete},
+		MatchRules: []map[string]string{{"category": "^repair$"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret = hook.Secret
+
+	_, err = dm.InsertData([]dataManager.Data{
+		{Key: "k1", Value: map[string]string{"category": "repair"}},
+		{Key: "k2", Value: map[string]string{"category": "cleaning"}},
+	}, dataManager.InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dm.PatchData([]dataManager.Patch{{Key: "k1", PriorityIncrement: 1}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dm.DeleteData([]string{"k1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		lock.Lock()
+		n := len(received)
+		lock.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("events not delivered", n)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	lock.Lock()
+	types := map[string]bool{}
+	for _, e := range received {
+		types[e.Type] = true
+		if e.Key != "k1" || e.Dataset != "provider" {
+			t.Error("unexpected event", e)
+		}
+	}
+	lock.Unlock()
+	if !types[EventInsert] || !types[EventDelete] {
+		t.Fatal("unexpected events", received)
+	}
+
+	deliveries, err := wm.Deliveries(hook.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, succeeded := 0, 0
+	for _, d := range deliveries {
+		if d.Succeeded {
+			succeeded += 1
+		} else {
+			failed += 1
+			if d.DurationMs < 5 {
+				t.Fatal("delivery duration not recorded", d)
+			}
+		}
+	}
+	if failed != 1 || succeeded != 2 {
+		t.Fatal("unexpected delivery log", deliveries)
+	}
+
+	list, err := wm.List("provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Secret != "" {
+		t.Fatal("unexpected list", list)
+	}
+	err = wm.Remove(hook.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, _ = wm.List("")
+	if len(list) != 0 {
+		t.Fatal("webhook should be removed", list)
+	}
+}
+
+func TestWebhookVisibility(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	dm := dataManager.NewDataManager(l, "publisher.", m)
+	dm.SetOptions(dataManager.Options{Lifecycle: dataManager.DefaultLifecycle("")})
+	go dm.Start()
+	defer dm.Stop()
+
+	// the delivery loop is not started, so the outbox keeps every event
+	wm := NewWebhookManager(l, m)
+	wm.Watch("publisher", dm)
+	_, err := wm.Register(Webhook{Dataset: "publisher", Url: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := func() []string {
+		items, err := wm.loadOutbox()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].CreateTimeMs < items[j].CreateTimeMs
+		})
+		res := make([]string, 0, len(items))
+		for _, item := range items {
+			res = append(res, item.EventType)
+		}
+		return res
+	}
+
+	// the outbox item is committed with the record, drafts are not sent
+	_, err = dm.InsertData([]dataManager.Data{{Key: "k1"}}, dataManager.InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := events(); len(list) != 0 {
+		t.Fatal("draft should not be sent", list)
+	}
+	for _, state := range []string{dataManager.StatePendingReview, dataManager.StatePublished, dataManager.StateClosed} {
+		err = dm.TransitionState([]string{"k1"}, state, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if list := events(); len(list) != 2 || list[0] != EventInsert || list[1] != EventDelete {
+		t.Fatal("records should appear when published and go away when closed", list)
+	}
+}
+
+func TestSlowWebhook(t *testing.T) {
+	l := console.NewConsoleLogger(zapcore.InfoLevel)
+	m := badgerManager.NewBadgerManager(l, t.TempDir())
+	go m.Start()
+	defer m.Stop()
+
+	dm := dataManager.NewDataManager(l, "provider.", m)
+	go dm.Start()
+	defer dm.Stop()
+
+	wm := NewWebhookManager(l, m)
+	wm.endpointTimeout = 100 * time.Millisecond
+	wm.Watch("provider", dm)
+
+	release := make(chan int)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	var lock sync.Mutex
+	fast := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		fast += 1
+	}))
+	defer receiver.Close()
+
+	slowHook, err := wm.Register(Webhook{Dataset: "provider", Url: slow.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wm.Register(Webhook{Dataset: "provider", Url: receiver.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dm.InsertData([]dataManager.Data{{Key: "k1"}, {Key: "k2"}}, dataManager.InsertOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	wm.deliverDue()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatal("slow webhook should be cut off", elapsed)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if fast != 2 {
+		t.Fatal("other webhooks should not wait for a slow one", fast)
+	}
+	deliveries, err := wm.Deliveries(slowHook.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Succeeded {
+		t.Fatal("slow delivery should fail and leave the rest for the next round", deliveries)
+	}
+}